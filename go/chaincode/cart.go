@@ -0,0 +1,182 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/units"
+)
+
+// CartCheckout is what CheckoutCart needs besides the cart items to create an
+// order. ItemQRCodes maps product ids of the cart to the QR codes of the
+// commercial products created for them.
+type CartCheckout struct {
+	DeliveryStatus DeliveryStatusCreateOrder `json:"deliveryStatus"`
+	QRCode         string                    `json:"qrCode"`
+	ItemQRCodes    map[string]string         `json:"itemQRCodes" metadata:",optional"`
+}
+
+// cartQuantity checks that quantity of a product can go into a cart: the
+// product must be orderable and the quantity a positive amount of its unit.
+// Stock is only checked at checkout, when the order is created.
+func cartQuantity(ctx contractapi.TransactionContextInterface, productId string, quantity string) (units.Amount, error) {
+	product, err := getProduct(ctx, productId)
+	if err != nil {
+		return 0, err
+	}
+	if product.Status != "MANUFACTURED" {
+		return 0, fmt.Errorf("product %s is %s, only MANUFACTURED products can be ordered", productId, product.Status)
+	}
+	err = checkNotExpired(ctx, product.ProductId, product.Expired)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := units.ParseQuantity(quantity, product.Unit)
+	if err != nil {
+		return 0, err
+	}
+	if value <= 0 {
+		return 0, fmt.Errorf("quantity of product %s must be positive", productId)
+	}
+	return value, nil
+}
+
+func cartItemIndex(cart []ProductIdItem, productId string) int {
+	for i, item := range cart {
+		if item.ProductId == productId {
+			return i
+		}
+	}
+	return -1
+}
+
+// putCart stores the cart of the caller and returns it, never as null.
+func putCart(ctx contractapi.TransactionContextInterface, identity *Identity, cart []ProductIdItem) ([]ProductIdItem, error) {
+	identity.Cart = cart
+	err := putIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if cart == nil {
+		return []ProductIdItem{}, nil
+	}
+	return cart, nil
+}
+
+// AddToCart adds quantity of a product to the cart of the caller, on top of
+// what the cart already holds of it.
+func (s *OrderContract) AddToCart(ctx contractapi.TransactionContextInterface, productId string, quantity string) ([]ProductIdItem, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := cartQuantity(ctx, productId, quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := identity.Cart
+	if i := cartItemIndex(cart, productId); i >= 0 {
+		inCart, err := units.ParseQuantity(cart[i].Quantity, "")
+		if err != nil {
+			return nil, err
+		}
+		cart[i].Quantity = units.FormatQuantity(inCart + value)
+	} else {
+		cart = append(cart, ProductIdItem{ProductId: productId, Quantity: units.FormatQuantity(value)})
+	}
+
+	return putCart(ctx, identity, cart)
+}
+
+// UpdateCartItem sets the quantity of a product already in the cart of the
+// caller.
+func (s *OrderContract) UpdateCartItem(ctx contractapi.TransactionContextInterface, productId string, quantity string) ([]ProductIdItem, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i := cartItemIndex(identity.Cart, productId)
+	if i < 0 {
+		return nil, fmt.Errorf("product %s is not in the cart", productId)
+	}
+
+	value, err := cartQuantity(ctx, productId, quantity)
+	if err != nil {
+		return nil, err
+	}
+	identity.Cart[i].Quantity = units.FormatQuantity(value)
+
+	return putCart(ctx, identity, identity.Cart)
+}
+
+// RemoveFromCart takes a product out of the cart of the caller.
+func (s *OrderContract) RemoveFromCart(ctx contractapi.TransactionContextInterface, productId string) ([]ProductIdItem, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i := cartItemIndex(identity.Cart, productId)
+	if i < 0 {
+		return nil, fmt.Errorf("product %s is not in the cart", productId)
+	}
+	cart := append(identity.Cart[:i:i], identity.Cart[i+1:]...)
+
+	return putCart(ctx, identity, cart)
+}
+
+// GetCart returns the cart of the caller.
+func (s *OrderContract) GetCart(ctx contractapi.TransactionContextInterface) ([]ProductIdItem, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Cart == nil {
+		return []ProductIdItem{}, nil
+	}
+	return identity.Cart, nil
+}
+
+// CheckoutCart creates an order from the cart of the caller and empties the
+// cart. CreateOrder checks the stock and expiry of every item.
+func (s *OrderContract) CheckoutCart(ctx contractapi.TransactionContextInterface, checkout CartCheckout) (*Order, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(identity.Cart) == 0 {
+		return nil, fmt.Errorf("the cart is empty")
+	}
+
+	orderObj := OrderForCreate{
+		DeliveryStatus: checkout.DeliveryStatus,
+		Signatures:     []string{},
+		QRCode:         checkout.QRCode,
+	}
+	for _, item := range identity.Cart {
+		orderObj.ProductIdQRCodeItems = append(orderObj.ProductIdQRCodeItems, ProductIdQRCodeItem{
+			ProductId: item.ProductId,
+			Quantity:  item.Quantity,
+			QRCode:    checkout.ItemQRCodes[item.ProductId],
+		})
+	}
+
+	order, err := s.CreateOrder(ctx, orderObj)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = putCart(ctx, identity, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
@@ -0,0 +1,116 @@
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/units"
+)
+
+func TestCartBuildsAnOrder(t *testing.T) {
+	n := newTestNetwork(t)
+	rice := n.manufactured("100")
+	beans := n.manufacture("manufacturer1", n.harvest("supplier1", "DX01", "50").ProductId, "2030-01-01")
+	harvested := n.harvest("supplier2", "ST24", "10")
+
+	cartAction := func(user string, function string, productId string, quantity string) ([]ProductIdItem, error) {
+		t.Helper()
+		return invoke(n, user, function, func(ctx contractapi.TransactionContextInterface) ([]ProductIdItem, error) {
+			switch function {
+			case "AddToCart":
+				return n.contract.AddToCart(ctx, productId, quantity)
+			case "UpdateCartItem":
+				return n.contract.UpdateCartItem(ctx, productId, quantity)
+			case "RemoveFromCart":
+				return n.contract.RemoveFromCart(ctx, productId)
+			}
+			return n.contract.GetCart(ctx)
+		})
+	}
+
+	cart, err := cartAction("retailer1", "GetCart", "", "")
+	expectNoError(t, err)
+	if cart == nil || len(cart) != 0 {
+		t.Fatalf("expected an empty cart, got %v", cart)
+	}
+
+	_, err = cartAction("manufacturer1", "AddToCart", rice.ProductId, "10")
+	expectError(t, err, "is not allowed to invoke AddToCart")
+	_, err = cartAction("retailer1", "AddToCart", harvested.ProductId, "10")
+	expectError(t, err, "only MANUFACTURED products can be ordered")
+	_, err = cartAction("retailer1", "AddToCart", rice.ProductId, "10 l")
+	expectError(t, err, "is not in kg")
+	_, err = cartAction("retailer1", "UpdateCartItem", beans.ProductId, "5")
+	expectError(t, err, "is not in the cart")
+
+	_, err = cartAction("retailer1", "AddToCart", rice.ProductId, "10")
+	expectNoError(t, err)
+	_, err = cartAction("retailer1", "AddToCart", rice.ProductId, "15 kg")
+	expectNoError(t, err)
+	_, err = cartAction("retailer1", "AddToCart", beans.ProductId, "5")
+	expectNoError(t, err)
+	cart, err = cartAction("retailer1", "UpdateCartItem", beans.ProductId, "8")
+	expectNoError(t, err)
+	if len(cart) != 2 || cart[0].Quantity != "25" || cart[1].Quantity != "8" {
+		t.Fatalf("unexpected cart %+v", cart)
+	}
+	cart, err = cartAction("retailer2", "GetCart", "", "")
+	expectNoError(t, err)
+	if len(cart) != 0 {
+		t.Fatalf("carts must not be shared, got %+v", cart)
+	}
+
+	checkout := func(user string) (*Order, error) {
+		t.Helper()
+		return invoke(n, user, "CheckoutCart", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+			return n.contract.CheckoutCart(ctx, CartCheckout{
+				DeliveryStatus: DeliveryStatusCreateOrder{Address: user + " store"},
+				QRCode:         "QR-cart",
+				ItemQRCodes:    map[string]string{rice.ProductId: "QR-rice"},
+			})
+		})
+	}
+
+	_, err = checkout("retailer2")
+	expectError(t, err, "the cart is empty")
+
+	// stock is checked at checkout, not when the cart is filled
+	_, err = cartAction("retailer1", "UpdateCartItem", beans.ProductId, "80")
+	expectNoError(t, err)
+	_, err = checkout("retailer1")
+	expectError(t, err, "has 50 kg available, 80 requested")
+
+	_, err = cartAction("retailer1", "UpdateCartItem", beans.ProductId, "8")
+	expectNoError(t, err)
+	order, err := checkout("retailer1")
+	expectNoError(t, err)
+	n.expectEventTypes("OrderCreated", "ProductCommercialCreated", "ProductCommercialCreated")
+	if order.Retailer.UserId != "retailer1" || order.QRCode != "QR-cart" || len(order.ProductItemList) != 2 {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	if order.ProductItemList[0].Product.QRCode != "QR-rice" || order.ProductItemList[0].OrderedQuantity.Value != 25*units.One || order.ProductItemList[1].OrderedQuantity.Value != 8*units.One {
+		t.Fatalf("unexpected order items %+v", order.ProductItemList)
+	}
+
+	cart, err = cartAction("retailer1", "GetCart", "", "")
+	expectNoError(t, err)
+	if len(cart) != 0 {
+		t.Fatalf("checkout must empty the cart, got %+v", cart)
+	}
+
+	// expiry is checked again at checkout
+	_, err = cartAction("retailer1", "AddToCart", rice.ProductId, "10")
+	expectNoError(t, err)
+	cart, err = cartAction("retailer1", "RemoveFromCart", rice.ProductId, "")
+	expectNoError(t, err)
+	if len(cart) != 0 {
+		t.Fatalf("unexpected cart %+v", cart)
+	}
+	_, err = cartAction("retailer1", "AddToCart", rice.ProductId, "10")
+	expectNoError(t, err)
+	n.ledger.Clock = time.Date(2031, time.January, 1, 0, 0, 0, 0, time.UTC)
+	_, err = checkout("retailer1")
+	expectError(t, err, "expired at 2030-01-01T00:00:00Z")
+}
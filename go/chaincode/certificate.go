@@ -0,0 +1,458 @@
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/dates"
+	"supplychain/internal/ledger"
+)
+
+// certificateIndex lists every certificate, by holder and product code.
+const certificateIndex = "holderId~productCode~certificateId"
+
+// certificateTypes are the certificates the registry knows. OCOP certificates
+// carry a star level from 1 to 5.
+var certificateTypes = []string{"OCOP", "VIETGAP", "GLOBALGAP", "ORGANIC"}
+
+// Certificate is a certification of the goods of one product code made by one
+// holder, issued by a certifier or regulator for a validity window.
+// DocumentHash is the hex SHA-256 hash of the certificate document.
+type Certificate struct {
+	CertificateId string     `json:"certificateId"`
+	Type          string     `json:"type"`
+	StarLevel     int        `json:"starLevel,omitempty" metadata:",optional"`
+	ProductCode   string     `json:"productCode"`
+	HolderId      string     `json:"holderId"`
+	HolderMSPId   string     `json:"holderMspId"`
+	Issuer        Actor      `json:"issuer"`
+	IssuerMSPId   string     `json:"issuerMspId"`
+	ValidFrom     string     `json:"validFrom"`
+	ValidUntil    string     `json:"validUntil"`
+	DocumentHash  string     `json:"documentHash"`
+	Documents     []Document `json:"documents,omitempty" metadata:",optional"`
+	Status        string     `json:"status"`
+	RevokeReason  string     `json:"revokeReason,omitempty" metadata:",optional"`
+	CreateDate    string     `json:"createDate"`
+	UpdateDate    string     `json:"updateDate"`
+}
+
+type CertificateForIssue struct {
+	Type         string `json:"type"`
+	StarLevel    int    `json:"starLevel" metadata:",optional"`
+	ProductCode  string `json:"productCode"`
+	HolderId     string `json:"holderId"`
+	HolderMSPId  string `json:"holderMspId"`
+	ValidFrom    string `json:"validFrom"`
+	ValidUntil   string `json:"validUntil"`
+	DocumentHash string `json:"documentHash"`
+}
+
+// CertificateRequirement lists the certificate types goods of a product code
+// need to be cultivated and exported. MinStarLevel applies to OCOP.
+type CertificateRequirement struct {
+	ProductCode  string   `json:"productCode"`
+	Types        []string `json:"types"`
+	MinStarLevel int      `json:"minStarLevel,omitempty" metadata:",optional"`
+}
+
+// CertificateVerification tells whether a certificate is in force at the
+// transaction time and who issued it.
+type CertificateVerification struct {
+	Certificate *Certificate `json:"certificate"`
+	Valid       bool         `json:"valid"`
+	Reason      string       `json:"reason,omitempty" metadata:",optional"`
+}
+
+func certificateRequirementKey(productCode string) string {
+	return "CertificateRequirement" + productCode
+}
+
+func getCertificate(ctx contractapi.TransactionContextInterface, certificateId string) (*Certificate, error) {
+	certificateAsBytes, err := ctx.GetStub().GetState(certificateId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if certificateAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", certificateId)
+	}
+
+	certificate := new(Certificate)
+	_ = json.Unmarshal(certificateAsBytes, certificate)
+
+	return certificate, nil
+}
+
+func getCertificateRequirement(ctx contractapi.TransactionContextInterface, productCode string) (*CertificateRequirement, error) {
+	requirementAsBytes, err := ctx.GetStub().GetState(certificateRequirementKey(productCode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if requirementAsBytes == nil {
+		return nil, nil
+	}
+
+	requirement := new(CertificateRequirement)
+	_ = json.Unmarshal(requirementAsBytes, requirement)
+
+	return requirement, nil
+}
+
+// certificateInForce returns why a certificate is not in force at now, or ""
+// when it is.
+func certificateInForce(certificate *Certificate, now time.Time) string {
+	if certificate.Status != "ACTIVE" {
+		return fmt.Sprintf("certificate %s is %s", certificate.CertificateId, certificate.Status)
+	}
+	validFrom, _ := time.Parse(time.RFC3339, certificate.ValidFrom)
+	validUntil, _ := time.Parse(time.RFC3339, certificate.ValidUntil)
+	if now.Before(validFrom) {
+		return fmt.Sprintf("certificate %s is valid from %s", certificate.CertificateId, certificate.ValidFrom)
+	}
+	if !now.Before(validUntil) {
+		return fmt.Sprintf("certificate %s expired at %s", certificate.CertificateId, certificate.ValidUntil)
+	}
+	return ""
+}
+
+// checkHeldCertificates checks that certificates given for new goods of
+// productCode exist and were issued to holder for that product code.
+// Certificates issued before holders had an organization are held by no one.
+func checkHeldCertificates(ctx contractapi.TransactionContextInterface, certificateIds []string, productCode string, holder Actor) error {
+	for _, certificateId := range certificateIds {
+		certificate, err := getCertificate(ctx, certificateId)
+		if err != nil {
+			return err
+		}
+		if certificate.ProductCode != productCode || certificate.HolderId != holder.UserId || certificate.HolderMSPId != actorMSPId(holder) {
+			return fmt.Errorf("certificate %s is not issued to %s for %s", certificateId, holder.UserId, productCode)
+		}
+	}
+	return nil
+}
+
+// checkRequiredCertificates fails unless, for every type the product code
+// requires, one of certificateIds is of that type and in force.
+func checkRequiredCertificates(ctx contractapi.TransactionContextInterface, assetId string, productCode string, certificateIds []string) error {
+	requirement, err := getCertificateRequirement(ctx, productCode)
+	if err != nil {
+		return err
+	}
+	if requirement == nil {
+		return nil
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	var certificates []*Certificate
+	for _, certificateId := range certificateIds {
+		certificate, err := getCertificate(ctx, certificateId)
+		if err != nil {
+			return err
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	for _, requiredType := range requirement.Types {
+		reason := fmt.Sprintf("%s has no %s certificate", assetId, requiredType)
+		for _, certificate := range certificates {
+			if certificate.Type != requiredType {
+				continue
+			}
+			if requiredType == "OCOP" && certificate.StarLevel < requirement.MinStarLevel {
+				reason = fmt.Sprintf("certificate %s has %d OCOP stars, %d required", certificate.CertificateId, certificate.StarLevel, requirement.MinStarLevel)
+				continue
+			}
+			reason = certificateInForce(certificate, now)
+			if reason == "" {
+				break
+			}
+		}
+		if reason != "" {
+			return fmt.Errorf("%s", reason)
+		}
+	}
+	return nil
+}
+
+// commonCertificates returns the certificate ids every product holds.
+func commonCertificates(products []*Product) []string {
+	var common []string
+	for _, certificateId := range products[0].CertificateIds {
+		held := true
+		for _, product := range products[1:] {
+			if !containsString(product.CertificateIds, certificateId) {
+				held = false
+				break
+			}
+		}
+		if held {
+			common = append(common, certificateId)
+		}
+	}
+	return common
+}
+
+// IssueCertificate records a certificate issued by the caller.
+func (s *ProductContract) IssueCertificate(ctx contractapi.TransactionContextInterface, certificateObj CertificateForIssue) (*Certificate, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mspId, err := cid.GetMSPID(ctx.GetStub())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client MSP ID: %s", err.Error())
+	}
+
+	if !containsString(certificateTypes, certificateObj.Type) {
+		return nil, fmt.Errorf("certificate type must be one of %v", certificateTypes)
+	}
+	if certificateObj.Type == "OCOP" && (certificateObj.StarLevel < 1 || certificateObj.StarLevel > 5) {
+		return nil, fmt.Errorf("OCOP certificates need a star level from 1 to 5")
+	}
+	if certificateObj.Type != "OCOP" && certificateObj.StarLevel != 0 {
+		return nil, fmt.Errorf("only OCOP certificates have a star level")
+	}
+	if certificateObj.ProductCode == "" || certificateObj.HolderId == "" || certificateObj.HolderMSPId == "" {
+		return nil, fmt.Errorf("certificates need a product code, a holder and the MSP ID of the holder")
+	}
+	documentHash, err := hex.DecodeString(certificateObj.DocumentHash)
+	if err != nil || len(documentHash) != 32 {
+		return nil, fmt.Errorf("document hash must be a hex SHA-256 hash")
+	}
+
+	validFrom, err := dates.Parse(certificateObj.ValidFrom)
+	if err != nil {
+		return nil, err
+	}
+	validUntil, err := dates.Parse(certificateObj.ValidUntil)
+	if err != nil {
+		return nil, err
+	}
+	if !validFrom.Before(validUntil) {
+		return nil, fmt.Errorf("certificate must be valid from before it is valid until")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	certificate := Certificate{
+		CertificateId: ledger.NewAssetId(ctx.GetStub(), "Certificate"),
+		Type:          certificateObj.Type,
+		StarLevel:     certificateObj.StarLevel,
+		ProductCode:   certificateObj.ProductCode,
+		HolderId:      certificateObj.HolderId,
+		HolderMSPId:   certificateObj.HolderMSPId,
+		Issuer:        actor,
+		IssuerMSPId:   mspId,
+		ValidFrom:     validFrom.UTC().Format(time.RFC3339),
+		ValidUntil:    validUntil.UTC().Format(time.RFC3339),
+		DocumentHash:  hex.EncodeToString(documentHash),
+		Status:        "ACTIVE",
+		CreateDate:    txTimeAsPtr,
+		UpdateDate:    txTimeAsPtr,
+	}
+
+	certificateAsBytes, _ := json.Marshal(certificate)
+	ctx.GetStub().PutState(certificate.CertificateId, certificateAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), certificateIndex, certificate.HolderId, certificate.ProductCode, certificate.CertificateId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvents(ctx, newEvent(ctx, "CertificateIssued", "Certificate", certificate.CertificateId, "", certificate.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate, nil
+}
+
+// RevokeCertificate withdraws a certificate. Only its issuer or a regulator
+// may revoke it.
+func (s *ProductContract) RevokeCertificate(ctx contractapi.TransactionContextInterface, certificateId string, reason string) (*Certificate, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := getCertificate(ctx, certificateId)
+	if err != nil {
+		return nil, err
+	}
+	if !sameActor(certificate.Issuer, actor) && actor.Role != "regulator" {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	if certificate.Status == "REVOKED" {
+		return nil, fmt.Errorf("certificate %s is already revoked", certificateId)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	oldStatus := certificate.Status
+	certificate.Status = "REVOKED"
+	certificate.RevokeReason = reason
+	certificate.UpdateDate = txTimeAsPtr
+
+	certificateAsBytes, _ := json.Marshal(certificate)
+	ctx.GetStub().PutState(certificate.CertificateId, certificateAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "CertificateRevoked", "Certificate", certificate.CertificateId, oldStatus, certificate.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return certificate, nil
+}
+
+// LinkCertificate adds a certificate of the caller to one of its products of
+// the certified product code. Commercial products already ordered keep the
+// certificates their product had.
+func (s *ProductContract) LinkCertificate(ctx contractapi.TransactionContextInterface, certificateId string, productId string) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := getProduct(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+	if !sameActor(productHolder(product), actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	err = checkHeldCertificates(ctx, []string{certificateId}, product.ProductCode, actor)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(product.CertificateIds, certificateId) {
+		return nil, fmt.Errorf("certificate %s is already linked to %s", certificateId, productId)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	product.CertificateIds = append(product.CertificateIds, certificateId)
+
+	productAsBytes, _ := json.Marshal(product)
+	ctx.GetStub().PutState(product.ProductId, productAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductCertified", "Product", product.ProductId, product.Status, product.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// SetCertificateRequirement sets the certificates goods of a product code need
+// to be cultivated and exported. No types removes the requirement.
+func (s *ProductContract) SetCertificateRequirement(ctx contractapi.TransactionContextInterface, requirement CertificateRequirement) (*CertificateRequirement, error) {
+	if requirement.ProductCode == "" {
+		return nil, fmt.Errorf("requirement product code is required")
+	}
+	for _, requiredType := range requirement.Types {
+		if !containsString(certificateTypes, requiredType) {
+			return nil, fmt.Errorf("certificate type must be one of %v", certificateTypes)
+		}
+	}
+
+	if len(requirement.Types) == 0 {
+		err := ctx.GetStub().DelState(certificateRequirementKey(requirement.ProductCode))
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete from world state. %s", err.Error())
+		}
+		return &requirement, nil
+	}
+
+	requirementAsBytes, _ := json.Marshal(requirement)
+	err := ctx.GetStub().PutState(certificateRequirementKey(requirement.ProductCode), requirementAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %s", err.Error())
+	}
+
+	return &requirement, nil
+}
+
+// GetCertificateRequirement returns the certificates a product code needs.
+func (s *ProductContract) GetCertificateRequirement(ctx contractapi.TransactionContextInterface, productCode string) (*CertificateRequirement, error) {
+	requirement, err := getCertificateRequirement(ctx, productCode)
+	if err != nil {
+		return nil, err
+	}
+	if requirement == nil {
+		return &CertificateRequirement{ProductCode: productCode, Types: []string{}}, nil
+	}
+	return requirement, nil
+}
+
+func (s *ProductContract) GetCertificate(ctx contractapi.TransactionContextInterface, certificateId string) (*Certificate, error) {
+	return getCertificate(ctx, certificateId)
+}
+
+// GetCertificatesOfHolder returns the certificates issued to the holder
+// holderId of organization mspId, optionally only those for productCode.
+func (s *ProductContract) GetCertificatesOfHolder(ctx contractapi.TransactionContextInterface, mspId string, holderId string, productCode string) ([]*Certificate, error) {
+	attributes := []string{holderId}
+	if productCode != "" {
+		attributes = append(attributes, productCode)
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(certificateIndex, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	certificates := []*Certificate{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		certificateAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		certificate := new(Certificate)
+		_ = json.Unmarshal(certificateAsBytes, certificate)
+		if certificate.HolderMSPId != mspId {
+			continue
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	return certificates, nil
+}
+
+// VerifyCertificate tells whether a certificate is in force at the
+// transaction time.
+func (s *ProductContract) VerifyCertificate(ctx contractapi.TransactionContextInterface, certificateId string) (*CertificateVerification, error) {
+	certificate, err := getCertificate(ctx, certificateId)
+	if err != nil {
+		return nil, err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := certificateInForce(certificate, now)
+	return &CertificateVerification{Certificate: certificate, Valid: reason == "", Reason: reason}, nil
+}
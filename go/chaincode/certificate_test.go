@@ -0,0 +1,155 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const testDocumentHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func (n *testNetwork) issueCertificate(user string, certificateObj CertificateForIssue) (*Certificate, error) {
+	n.t.Helper()
+	return invoke(n, user, "IssueCertificate", func(ctx contractapi.TransactionContextInterface) (*Certificate, error) {
+		return n.contract.IssueCertificate(ctx, certificateObj)
+	})
+}
+
+func (n *testNetwork) cultivate(supplier string, productCode string, certificateIds ...string) (*Product, error) {
+	n.t.Helper()
+	return invoke(n, supplier, "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductName: "Rice " + productCode, ProductCode: productCode, Amount: "100", Unit: "kg", Image: []string{}, CertificateIds: certificateIds})
+	})
+}
+
+func TestIssueAndRevokeCertificate(t *testing.T) {
+	n := newTestNetwork(t)
+	ocop := CertificateForIssue{Type: "OCOP", StarLevel: 4, ProductCode: "ST25", HolderId: "supplier1", HolderMSPId: "SupplierMSP", ValidFrom: "2023-01-01", ValidUntil: "2024-01-01", DocumentHash: testDocumentHash}
+
+	_, err := n.issueCertificate("supplier1", ocop)
+	expectError(t, err, "is not allowed to invoke IssueCertificate")
+	_, err = n.issueCertificate("certifier1", CertificateForIssue{Type: "ISO", ProductCode: "ST25", HolderId: "supplier1", HolderMSPId: "SupplierMSP", ValidFrom: "2023-01-01", ValidUntil: "2024-01-01", DocumentHash: testDocumentHash})
+	expectError(t, err, "certificate type must be one of")
+	_, err = n.issueCertificate("certifier1", CertificateForIssue{Type: "OCOP", StarLevel: 6, ProductCode: "ST25", HolderId: "supplier1", HolderMSPId: "SupplierMSP", ValidFrom: "2023-01-01", ValidUntil: "2024-01-01", DocumentHash: testDocumentHash})
+	expectError(t, err, "star level from 1 to 5")
+	_, err = n.issueCertificate("certifier1", CertificateForIssue{Type: "OCOP", StarLevel: 4, ProductCode: "ST25", HolderId: "supplier1", HolderMSPId: "SupplierMSP", ValidFrom: "2023-01-01", ValidUntil: "2024-01-01", DocumentHash: "not a hash"})
+	expectError(t, err, "document hash must be")
+
+	certificate, err := n.issueCertificate("certifier1", ocop)
+	expectNoError(t, err)
+	n.expectEventTypes("CertificateIssued")
+	if certificate.Status != "ACTIVE" || certificate.Issuer.UserId != "certifier1" || certificate.IssuerMSPId != "ConsumerMSP" || certificate.ValidUntil != "2024-01-01T00:00:00Z" {
+		t.Fatalf("unexpected certificate %+v", certificate)
+	}
+
+	certificates := mustInvoke(n, "supplier1", "GetCertificatesOfHolder", func(ctx contractapi.TransactionContextInterface) ([]*Certificate, error) {
+		return n.contract.GetCertificatesOfHolder(ctx, "SupplierMSP", "supplier1", "ST25")
+	})
+	if len(certificates) != 1 || certificates[0].CertificateId != certificate.CertificateId {
+		t.Fatalf("unexpected certificates %+v", certificates)
+	}
+
+	// a holder of the same enrollment ID in another organization holds nothing
+	elsewhere := ocop
+	elsewhere.HolderMSPId = "ManufacturerMSP"
+	_, err = n.issueCertificate("certifier1", CertificateForIssue{Type: "OCOP", StarLevel: 4, ProductCode: "ST25", HolderId: "supplier1", ValidFrom: "2023-01-01", ValidUntil: "2024-01-01", DocumentHash: testDocumentHash})
+	expectError(t, err, "the MSP ID of the holder")
+	misissued, err := n.issueCertificate("certifier1", elsewhere)
+	expectNoError(t, err)
+	_, err = n.cultivate("supplier1", "ST25", misissued.CertificateId)
+	expectError(t, err, "is not issued to supplier1 for ST25")
+	_, err = n.cultivate("supplier1", "ST25", certificate.CertificateId)
+	expectNoError(t, err)
+	certificates = mustInvoke(n, "supplier1", "GetCertificatesOfHolder", func(ctx contractapi.TransactionContextInterface) ([]*Certificate, error) {
+		return n.contract.GetCertificatesOfHolder(ctx, "ManufacturerMSP", "supplier1", "")
+	})
+	if len(certificates) != 1 || certificates[0].CertificateId != misissued.CertificateId {
+		t.Fatalf("unexpected certificates %+v", certificates)
+	}
+
+	verify := func() *CertificateVerification {
+		return mustInvoke(n, "consumer1", "VerifyCertificate", func(ctx contractapi.TransactionContextInterface) (*CertificateVerification, error) {
+			return n.contract.VerifyCertificate(ctx, certificate.CertificateId)
+		})
+	}
+	if verification := verify(); !verification.Valid {
+		t.Fatalf("unexpected verification %+v", verification)
+	}
+
+	revoke := func(user string) (*Certificate, error) {
+		return invoke(n, user, "RevokeCertificate", func(ctx contractapi.TransactionContextInterface) (*Certificate, error) {
+			return n.contract.RevokeCertificate(ctx, certificate.CertificateId, "failed audit")
+		})
+	}
+	_, err = revoke("supplier1")
+	expectError(t, err, "is not allowed to invoke RevokeCertificate")
+	_, err = revoke("regulator1")
+	expectNoError(t, err)
+	n.expectEventTypes("CertificateRevoked")
+	_, err = revoke("certifier1")
+	expectError(t, err, "is already revoked")
+
+	if verification := verify(); verification.Valid || !strings.Contains(verification.Reason, "is REVOKED") {
+		t.Fatalf("unexpected verification %+v", verification)
+	}
+}
+
+func TestRequiredCertificatesGateCultivationAndOrders(t *testing.T) {
+	n := newTestNetwork(t)
+	setRequirement := func(user string, requirement CertificateRequirement) (*CertificateRequirement, error) {
+		return invoke(n, user, "SetCertificateRequirement", func(ctx contractapi.TransactionContextInterface) (*CertificateRequirement, error) {
+			return n.contract.SetCertificateRequirement(ctx, requirement)
+		})
+	}
+
+	_, err := setRequirement("supplier1", CertificateRequirement{ProductCode: "ST25", Types: []string{"OCOP"}})
+	expectError(t, err, "is not allowed to invoke SetCertificateRequirement")
+	_, err = setRequirement("regulator1", CertificateRequirement{ProductCode: "ST25", Types: []string{"OCOP"}, MinStarLevel: 3})
+	expectNoError(t, err)
+
+	_, err = n.cultivate("supplier1", "ST25")
+	expectError(t, err, "ST25 has no OCOP certificate")
+
+	twoStars, err := n.issueCertificate("certifier1", CertificateForIssue{Type: "OCOP", StarLevel: 2, ProductCode: "ST25", HolderId: "supplier1", HolderMSPId: "SupplierMSP", ValidFrom: "2023-01-01", ValidUntil: "2024-01-01", DocumentHash: testDocumentHash})
+	expectNoError(t, err)
+	_, err = n.cultivate("supplier1", "ST25", twoStars.CertificateId)
+	expectError(t, err, "has 2 OCOP stars, 3 required")
+
+	certificate, err := n.issueCertificate("certifier1", CertificateForIssue{Type: "OCOP", StarLevel: 4, ProductCode: "ST25", HolderId: "supplier1", HolderMSPId: "SupplierMSP", ValidFrom: "2023-01-01", ValidUntil: "2023-06-01", DocumentHash: testDocumentHash})
+	expectNoError(t, err)
+	_, err = n.cultivate("supplier2", "ST25", certificate.CertificateId)
+	expectError(t, err, "is not issued to supplier2 for ST25")
+
+	product, err := n.cultivate("supplier1", "ST25", certificate.CertificateId)
+	expectNoError(t, err)
+	mustInvoke(n, "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.HarvestProduct(ctx, Product{ProductId: product.ProductId, Amount: "100"})
+	})
+	n.manufacture("manufacturer1", product.ProductId, "2030-01-01")
+
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10", QRCode: "QR-certified"})
+	if ids := order.ProductItemList[0].Product.CertificateIds; len(ids) != 1 || ids[0] != certificate.CertificateId {
+		t.Fatalf("commercial products must carry the certificates of their product, got %v", ids)
+	}
+
+	n.ledger.Clock = time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	_, err = n.orderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	expectError(t, err, "expired at 2023-06-01T00:00:00Z")
+
+	// within its validity the certificate lets the order through and reaches the trace
+	n.ledger.Clock = time.Date(2023, time.February, 1, 0, 0, 0, 0, time.UTC)
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	trace := mustInvoke(n, "consumer1", "TraceByQRCode", func(ctx contractapi.TransactionContextInterface) (*ProvenanceTrace, error) {
+		return n.contract.TraceByQRCode(ctx, "QR-certified")
+	})
+	if len(trace.Certificates) != 1 || !trace.Certificates[0].Valid || trace.Certificates[0].StarLevel != 4 || trace.Certificates[0].Issuer.UserId != "certifier1" {
+		t.Fatalf("unexpected trace certificates %+v", trace.Certificates)
+	}
+
+	_, err = setRequirement("regulator1", CertificateRequirement{ProductCode: "ST25"})
+	expectNoError(t, err)
+	_, err = n.cultivate("supplier2", "ST25")
+	expectNoError(t, err)
+}
@@ -0,0 +1,108 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChaincodeRoutesToNamedAndDefaultContracts(t *testing.T) {
+	n := newTestNetwork(t)
+	chaincode, err := NewChaincode()
+	expectNoError(t, err)
+
+	payload := `{"productName":"Rice","productCode":"ST25","amount":"10","unit":"kg","description":"","certificateUrl":"","image":[]}`
+	tests := []struct {
+		user     string
+		function string
+		args     []string
+		err      string
+	}{
+		{"supplier1", "CultivateProduct", []string{payload}, ""},
+		{"supplier1", "ProductContract:CultivateProduct", []string{payload}, ""},
+		{"retailer1", "ProductContract:CultivateProduct", []string{payload}, "is not allowed to invoke CultivateProduct"},
+		{"supplier1", "OrderContract:CultivateProduct", []string{payload}, "Function CultivateProduct not found in contract OrderContract"},
+		{"supplier1", "IdentityContract:GetIdentity", nil, ""},
+		{"retailer1", "OrderContract:GetAllOrders", []string{""}, ""},
+		{"supplier1", "UnknownContract:GetIdentity", nil, "Contract not found with name UnknownContract"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.function+"/"+test.user, func(t *testing.T) {
+			stub := n.ledger.NewStub(n.identities[test.user].Creator, test.function, test.args...)
+			response := chaincode.Invoke(stub)
+			if test.err != "" {
+				if response.Status == http.StatusOK || !strings.Contains(response.Message, test.err) {
+					t.Fatalf("expected an error containing %q, got %d %s", test.err, response.Status, response.Message)
+				}
+				return
+			}
+			if response.Status != http.StatusOK {
+				t.Fatalf("unexpected error %s", response.Message)
+			}
+			stub.Commit()
+		})
+	}
+
+	// a recall of a product never ordered has empty lists, not null ones
+	harvested := n.harvest("supplier1", "ST24", "10")
+	recall := n.ledger.NewStub(n.identities["supplier1"].Creator, "ProductContract:InitiateRecall", harvested.ProductId, "mould")
+	if response := chaincode.Invoke(recall); response.Status != http.StatusOK {
+		t.Fatalf("unexpected error %s", response.Message)
+	}
+	recall.Commit()
+
+	products := n.ledger.NewStub(n.identities["consumer1"].Creator, "GetAllProducts")
+	response := chaincode.Invoke(products)
+	if response.Status != http.StatusOK {
+		t.Fatalf("unexpected error %s", response.Message)
+	}
+	var records []*Product
+	_ = json.Unmarshal(response.Payload, &records)
+	if len(records) != 3 {
+		t.Fatalf("expected the 3 products cultivated, got %s", response.Payload)
+	}
+}
+
+func TestChaincodeMetadataListsEveryContract(t *testing.T) {
+	n := newTestNetwork(t)
+	chaincode, err := NewChaincode()
+	expectNoError(t, err)
+
+	response := chaincode.Invoke(n.ledger.NewStub(nil, "org.hyperledger.fabric:GetMetadata"))
+	var metadata struct {
+		Contracts map[string]struct {
+			Default      bool `json:"default"`
+			Transactions []struct {
+				Name string `json:"name"`
+			} `json:"transactions"`
+		} `json:"contracts"`
+	}
+	expectNoError(t, json.Unmarshal(response.Payload, &metadata))
+
+	tests := []struct {
+		contract    string
+		isDefault   bool
+		transaction string
+		absent      string
+	}{
+		{"SmartContract", true, "CreateOrder", "walkProducts"},
+		{"ProductContract", false, "CultivateProduct", "CreateOrder"},
+		{"OrderContract", false, "CreateOrder", "RegisterUser"},
+		{"IdentityContract", false, "RegisterUser", "CultivateProduct"},
+	}
+	for _, test := range tests {
+		contract, ok := metadata.Contracts[test.contract]
+		if !ok || contract.Default != test.isDefault {
+			t.Fatalf("unexpected metadata for %s: %+v", test.contract, contract)
+		}
+		transactions := map[string]bool{}
+		for _, transaction := range contract.Transactions {
+			transactions[transaction.Name] = true
+		}
+		if !transactions[test.transaction] || transactions[test.absent] {
+			t.Fatalf("%s has the wrong transactions: %v", test.contract, transactions)
+		}
+	}
+}
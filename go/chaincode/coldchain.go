@@ -0,0 +1,514 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// shipmentReadingIndex lists the reading batches recorded for each order.
+const shipmentReadingIndex = "orderId~shipmentReadingsId"
+
+// maxShipmentReadings bounds the readings of one batch.
+const maxShipmentReadings = 500
+
+// Device is a sensor that travels with shipments. Its readings are signed
+// with the private key of PublicKey and recorded by the distributor owning it.
+type Device struct {
+	DeviceId    string `json:"deviceId"`
+	PublicKey   string `json:"publicKey"`
+	Description string `json:"description"`
+	Owner       Actor  `json:"owner"`
+	CreateDate  string `json:"createDate"`
+}
+
+type DeviceForRegister struct {
+	DeviceId    string `json:"deviceId"`
+	PublicKey   string `json:"publicKey"`
+	Description string `json:"description" metadata:",optional"`
+}
+
+// ColdChainThreshold is the temperature (°C) and relative humidity (%) range
+// goods of a product code must be kept in while they are shipped.
+type ColdChainThreshold struct {
+	ProductCode    string  `json:"productCode"`
+	MinTemperature float64 `json:"minTemperature"`
+	MaxTemperature float64 `json:"maxTemperature"`
+	MinHumidity    float64 `json:"minHumidity"`
+	MaxHumidity    float64 `json:"maxHumidity"`
+}
+
+type ShipmentReading struct {
+	Time        string  `json:"time"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+}
+
+// ShipmentReadingPayload is what a device signs: the readings it took during
+// the shipment of an order.
+type ShipmentReadingPayload struct {
+	OrderId  string            `json:"orderId"`
+	DeviceId string            `json:"deviceId"`
+	Readings []ShipmentReading `json:"readings"`
+}
+
+// ShipmentReadings is a recorded batch of readings. Its id is the hex SHA-256
+// hash of the signed payload, so a batch cannot be recorded twice. Breaches
+// lists the product codes whose threshold a reading of the batch exceeded, and
+// BreachedReadings the indexes of those readings, both against the thresholds
+// in force when the batch was recorded. The order is not stored as orderId,
+// which rich queries take for orders.
+type ShipmentReadings struct {
+	ShipmentReadingsId string            `json:"shipmentReadingsId"`
+	ShipmentOrderId    string            `json:"shipmentOrderId"`
+	DeviceId           string            `json:"deviceId"`
+	Readings           []ShipmentReading `json:"readings"`
+	Signature          string            `json:"signature"`
+	Breaches           []string          `json:"breaches"`
+	BreachedReadings   []int             `json:"breachedReadings,omitempty" metadata:",optional"`
+	Recorder           Actor             `json:"recorder"`
+	RecordDate         string            `json:"recordDate"`
+}
+
+// ShipmentReadingPoint is one reading of the series of an order.
+type ShipmentReadingPoint struct {
+	Time        string  `json:"time"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+	DeviceId    string  `json:"deviceId"`
+	Breach      bool    `json:"breach"`
+}
+
+// ShipmentReadingSeries is every reading recorded for an order, by time.
+type ShipmentReadingSeries struct {
+	OrderId  string                 `json:"orderId"`
+	Status   string                 `json:"status"`
+	Readings []ShipmentReadingPoint `json:"readings"`
+}
+
+func deviceKey(deviceId string) string {
+	return "Device" + deviceId
+}
+
+func coldChainThresholdKey(productCode string) string {
+	return "ColdChainThreshold" + productCode
+}
+
+func getDevice(ctx contractapi.TransactionContextInterface, deviceId string) (*Device, error) {
+	deviceAsBytes, err := ctx.GetStub().GetState(deviceKey(deviceId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if deviceAsBytes == nil {
+		return nil, fmt.Errorf("device %s does not exist", deviceId)
+	}
+
+	device := new(Device)
+	_ = json.Unmarshal(deviceAsBytes, device)
+
+	return device, nil
+}
+
+func getColdChainThreshold(ctx contractapi.TransactionContextInterface, productCode string) (*ColdChainThreshold, error) {
+	thresholdAsBytes, err := ctx.GetStub().GetState(coldChainThresholdKey(productCode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if thresholdAsBytes == nil {
+		return nil, nil
+	}
+
+	threshold := new(ColdChainThreshold)
+	_ = json.Unmarshal(thresholdAsBytes, threshold)
+
+	return threshold, nil
+}
+
+func (threshold *ColdChainThreshold) exceededBy(reading ShipmentReading) bool {
+	return reading.Temperature < threshold.MinTemperature || reading.Temperature > threshold.MaxTemperature ||
+		reading.Humidity < threshold.MinHumidity || reading.Humidity > threshold.MaxHumidity
+}
+
+// orderColdChainThresholds returns the thresholds of the product codes of an
+// order that have one.
+func orderColdChainThresholds(ctx contractapi.TransactionContextInterface, order *Order) ([]*ColdChainThreshold, error) {
+	var thresholds []*ColdChainThreshold
+	seen := map[string]bool{}
+	for _, item := range order.ProductItemList {
+		if seen[item.Product.ProductCode] {
+			continue
+		}
+		seen[item.Product.ProductCode] = true
+
+		threshold, err := getColdChainThreshold(ctx, item.Product.ProductCode)
+		if err != nil {
+			return nil, err
+		}
+		if threshold != nil {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	return thresholds, nil
+}
+
+// checkShipmentReadings checks that readings were taken while the order was
+// shipping, up to now.
+func checkShipmentReadings(order *Order, readings []ShipmentReading, now time.Time) error {
+	if len(readings) == 0 || len(readings) > maxShipmentReadings {
+		return fmt.Errorf("a batch holds from 1 to %d readings", maxShipmentReadings)
+	}
+
+	var shippedFrom time.Time
+	for _, delivery := range order.DeliveryStatuses {
+		if delivery.Status == "SHIPPING" {
+			var err error
+			shippedFrom, err = ledger.ParseTxTimestamp(delivery.DeliveryDate)
+			if err != nil {
+				return fmt.Errorf("invalid shipping date %s of order %s", delivery.DeliveryDate, order.OrderId)
+			}
+		}
+	}
+
+	for _, reading := range readings {
+		readingTime, err := time.Parse(time.RFC3339, reading.Time)
+		if err != nil {
+			return fmt.Errorf("invalid reading time %s, expected RFC 3339", reading.Time)
+		}
+		if readingTime.Before(shippedFrom) || readingTime.After(now) {
+			return fmt.Errorf("reading at %s was not taken while order %s was shipping", reading.Time, order.OrderId)
+		}
+		if reading.Humidity < 0 || reading.Humidity > 100 {
+			return fmt.Errorf("reading at %s has a humidity outside 0-100%%", reading.Time)
+		}
+	}
+	return nil
+}
+
+// flagColdChainBreach moves a shipping order and its items to
+// COLD_CHAIN_BREACH, so that they cannot be delivered or sold. Items that
+// already left the shipment, recalled for instance, keep their status.
+func flagColdChainBreach(ctx contractapi.TransactionContextInterface, order *Order, actor Actor, timestamp string) ([]SupplyChainEvent, error) {
+	err := checkOrderTransition(order, "COLD_CHAIN_BREACH")
+	if err != nil {
+		return nil, err
+	}
+
+	var events []SupplyChainEvent
+	date := ProductDate{Status: "COLD_CHAIN_BREACH", Time: timestamp, Actor: actor}
+	for i, item := range order.ProductItemList {
+		productCommercial, err := getProductCommercial(ctx, item.Product.ProductCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		if checkProductCommercialTransition(productCommercial, "COLD_CHAIN_BREACH") != nil {
+			order.ProductItemList[i].Product = *productCommercial
+			continue
+		}
+
+		oldStatus := productCommercial.Status
+		productCommercial.Dates = append(productCommercial.Dates, date)
+		productCommercial.Status = "COLD_CHAIN_BREACH"
+
+		productCommercialAsBytes, _ := json.Marshal(productCommercial)
+		ctx.GetStub().PutState(productCommercial.ProductCommercialId, productCommercialAsBytes)
+
+		order.ProductItemList[i].Product = *productCommercial
+		events = append(events, newEvent(ctx, "ProductColdChainBreach", "ProductCommercial", productCommercial.ProductCommercialId, oldStatus, productCommercial.Status, actor, timestamp))
+	}
+
+	oldStatus := order.Status
+	order.DeliveryStatuses = append(order.DeliveryStatuses, DeliveryStatus{
+		Status:       "COLD_CHAIN_BREACH",
+		DeliveryDate: timestamp,
+		Address:      actor.Address,
+		Actor:        actor,
+	})
+	order.UpdateDate = timestamp
+	order.Status = "COLD_CHAIN_BREACH"
+
+	orderAsBytes, _ := json.Marshal(order)
+	ctx.GetStub().PutState(order.OrderId, orderAsBytes)
+
+	return append([]SupplyChainEvent{newEvent(ctx, "OrderColdChainBreach", "Order", order.OrderId, oldStatus, order.Status, actor, timestamp)}, events...), nil
+}
+
+// RegisterDevice registers a sensor of the caller and the PEM encoded ECDSA
+// or Ed25519 public key its readings are verified with.
+func (s *OrderContract) RegisterDevice(ctx contractapi.TransactionContextInterface, deviceObj DeviceForRegister) (*Device, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deviceObj.DeviceId == "" {
+		return nil, fmt.Errorf("device id is required")
+	}
+	existing, err := ctx.GetStub().GetState(deviceKey(deviceObj.DeviceId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("device %s is already registered", deviceObj.DeviceId)
+	}
+	_, err = parsePublicKey(deviceObj.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	device := Device{
+		DeviceId:    deviceObj.DeviceId,
+		PublicKey:   deviceObj.PublicKey,
+		Description: deviceObj.Description,
+		Owner:       actor,
+		CreateDate:  txTimeAsPtr,
+	}
+
+	deviceAsBytes, _ := json.Marshal(device)
+	err = ctx.GetStub().PutState(deviceKey(device.DeviceId), deviceAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %s", err.Error())
+	}
+
+	return &device, nil
+}
+
+func (s *OrderContract) GetDevice(ctx contractapi.TransactionContextInterface, deviceId string) (*Device, error) {
+	return getDevice(ctx, deviceId)
+}
+
+// SetColdChainThreshold sets the range goods of a product code must be
+// shipped in.
+func (s *OrderContract) SetColdChainThreshold(ctx contractapi.TransactionContextInterface, threshold ColdChainThreshold) (*ColdChainThreshold, error) {
+	if threshold.ProductCode == "" {
+		return nil, fmt.Errorf("threshold product code is required")
+	}
+	if threshold.MinTemperature >= threshold.MaxTemperature {
+		return nil, fmt.Errorf("minimum temperature must be below maximum temperature")
+	}
+	if threshold.MinHumidity < 0 || threshold.MaxHumidity > 100 || threshold.MinHumidity >= threshold.MaxHumidity {
+		return nil, fmt.Errorf("humidity must range within 0-100%%, minimum below maximum")
+	}
+
+	thresholdAsBytes, _ := json.Marshal(threshold)
+	err := ctx.GetStub().PutState(coldChainThresholdKey(threshold.ProductCode), thresholdAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %s", err.Error())
+	}
+
+	return &threshold, nil
+}
+
+func (s *OrderContract) GetColdChainThreshold(ctx contractapi.TransactionContextInterface, productCode string) (*ColdChainThreshold, error) {
+	threshold, err := getColdChainThreshold(ctx, productCode)
+	if err != nil {
+		return nil, err
+	}
+	if threshold == nil {
+		return nil, fmt.Errorf("no cold chain threshold is set for %s", productCode)
+	}
+	return threshold, nil
+}
+
+// RecordShipmentReading records a batch of readings of a device of the
+// distributor shipping an order. payload is the JSON ShipmentReadingPayload
+// exactly as signed: signature is the base64 signature of its SHA-256 digest
+// with the device key. A reading outside the threshold of a product code of
+// the order flags the order and its items as COLD_CHAIN_BREACH.
+func (s *OrderContract) RecordShipmentReading(ctx contractapi.TransactionContextInterface, payload string, signature string) (*ShipmentReadings, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var readingPayload ShipmentReadingPayload
+	err = json.Unmarshal([]byte(payload), &readingPayload)
+	if err != nil {
+		return nil, fmt.Errorf("payload must be a JSON shipment reading batch: %s", err.Error())
+	}
+
+	device, err := getDevice(ctx, readingPayload.DeviceId)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := parsePublicKey(device.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(payload))
+	shipmentReadingsId := hex.EncodeToString(hash[:])
+	_, err = verifySignature(publicKey, shipmentReadingsId, signature)
+	if err != nil {
+		return nil, fmt.Errorf("readings are not signed by device %s", device.DeviceId)
+	}
+
+	existing, err := ctx.GetStub().GetState(shipmentReadingsId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("readings %s are already recorded", shipmentReadingsId)
+	}
+
+	order, err := getOrder(ctx, readingPayload.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != "SHIPPING" && order.Status != "COLD_CHAIN_BREACH" {
+		return nil, fmt.Errorf("order %s is not shipping", order.OrderId)
+	}
+	if !sameActor(order.Distributor, actor) || !sameActor(device.Owner, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = checkShipmentReadings(order, readingPayload.Readings, now)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	thresholds, err := orderColdChainThresholds(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	breaches := []string{}
+	for _, threshold := range thresholds {
+		for _, reading := range readingPayload.Readings {
+			if threshold.exceededBy(reading) {
+				breaches = append(breaches, threshold.ProductCode)
+				break
+			}
+		}
+	}
+	var breachedReadings []int
+	for i, reading := range readingPayload.Readings {
+		for _, threshold := range thresholds {
+			if threshold.exceededBy(reading) {
+				breachedReadings = append(breachedReadings, i)
+				break
+			}
+		}
+	}
+
+	shipmentReadings := ShipmentReadings{
+		ShipmentReadingsId: shipmentReadingsId,
+		ShipmentOrderId:    order.OrderId,
+		DeviceId:           device.DeviceId,
+		Readings:           readingPayload.Readings,
+		Signature:          signature,
+		Breaches:           breaches,
+		BreachedReadings:   breachedReadings,
+		Recorder:           actor,
+		RecordDate:         txTimeAsPtr,
+	}
+
+	shipmentReadingsAsBytes, _ := json.Marshal(shipmentReadings)
+	ctx.GetStub().PutState(shipmentReadings.ShipmentReadingsId, shipmentReadingsAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), shipmentReadingIndex, order.OrderId, shipmentReadings.ShipmentReadingsId)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []SupplyChainEvent{newEvent(ctx, "ShipmentReadingsRecorded", "ShipmentReadings", shipmentReadings.ShipmentReadingsId, "", order.Status, actor, txTimeAsPtr)}
+	if len(breaches) > 0 && order.Status == "SHIPPING" {
+		breachEvents, err := flagColdChainBreach(ctx, order, actor, txTimeAsPtr)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, breachEvents...)
+	}
+
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shipmentReadings, nil
+}
+
+// readingBreached tells whether the reading at index of a batch exceeded a
+// threshold when the batch was recorded. Batches recorded before breached
+// readings were kept only tell whether any of their readings did.
+func readingBreached(shipmentReadings *ShipmentReadings, index int) bool {
+	if len(shipmentReadings.Breaches) == 0 {
+		return false
+	}
+	if len(shipmentReadings.BreachedReadings) == 0 {
+		return true
+	}
+	for _, breached := range shipmentReadings.BreachedReadings {
+		if breached == index {
+			return true
+		}
+	}
+	return false
+}
+
+// GetShipmentReadings returns the readings recorded for an order, by time.
+// Breach marks readings that were outside the threshold of a product code of
+// the order when they were recorded; later threshold changes do not rewrite
+// them.
+func (s *OrderContract) GetShipmentReadings(ctx contractapi.TransactionContextInterface, orderId string) (*ShipmentReadingSeries, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(shipmentReadingIndex, []string{orderId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	series := ShipmentReadingSeries{OrderId: order.OrderId, Status: order.Status, Readings: []ShipmentReadingPoint{}}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		shipmentReadingsAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		shipmentReadings := new(ShipmentReadings)
+		_ = json.Unmarshal(shipmentReadingsAsBytes, shipmentReadings)
+		for i, reading := range shipmentReadings.Readings {
+			series.Readings = append(series.Readings, ShipmentReadingPoint{
+				Time:        reading.Time,
+				Temperature: reading.Temperature,
+				Humidity:    reading.Humidity,
+				DeviceId:    shipmentReadings.DeviceId,
+				Breach:      readingBreached(shipmentReadings, i),
+			})
+		}
+	}
+
+	sort.SliceStable(series.Readings, func(i, j int) bool {
+		first, _ := time.Parse(time.RFC3339, series.Readings[i].Time)
+		second, _ := time.Parse(time.RFC3339, series.Readings[j].Time)
+		return first.Before(second)
+	})
+
+	return &series, nil
+}
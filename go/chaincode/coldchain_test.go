@@ -0,0 +1,178 @@
+package chaincode
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// registerDevice registers a new Ed25519 device of distributor and returns
+// its private key.
+func (n *testNetwork) registerDevice(distributor string, deviceId string) ed25519.PrivateKey {
+	n.t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	expectNoError(n.t, err)
+	publicKeyAsBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	expectNoError(n.t, err)
+	publicKeyPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyAsBytes}))
+	mustInvoke(n, distributor, "RegisterDevice", func(ctx contractapi.TransactionContextInterface) (*Device, error) {
+		return n.contract.RegisterDevice(ctx, DeviceForRegister{DeviceId: deviceId, PublicKey: publicKeyPem})
+	})
+	return privateKey
+}
+
+func TestColdChainBreachFlagsShipment(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"}, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "5"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+
+	_, err := invoke(n, "retailer1", "SetColdChainThreshold", func(ctx contractapi.TransactionContextInterface) (*ColdChainThreshold, error) {
+		return n.contract.SetColdChainThreshold(ctx, ColdChainThreshold{ProductCode: "ST25", MinTemperature: 2, MaxTemperature: 8, MinHumidity: 30, MaxHumidity: 90})
+	})
+	expectError(t, err, "is not allowed to invoke SetColdChainThreshold")
+	mustInvoke(n, "regulator1", "SetColdChainThreshold", func(ctx contractapi.TransactionContextInterface) (*ColdChainThreshold, error) {
+		return n.contract.SetColdChainThreshold(ctx, ColdChainThreshold{ProductCode: "ST25", MinTemperature: 2, MaxTemperature: 8, MinHumidity: 30, MaxHumidity: 90})
+	})
+
+	deviceKey := n.registerDevice("distributor1", "truck-7")
+	otherKey := n.registerDevice("distributor2", "truck-9")
+	n.ledger.Clock = time.Date(2023, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+	payload := func(deviceId string, readings ...ShipmentReading) string {
+		payloadAsBytes, _ := json.Marshal(ShipmentReadingPayload{OrderId: order.OrderId, DeviceId: deviceId, Readings: readings})
+		return string(payloadAsBytes)
+	}
+	sign := func(privateKey ed25519.PrivateKey, payload string) string {
+		digest := sha256.Sum256([]byte(payload))
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:]))
+	}
+	record := func(user string, payload string, signature string) (*ShipmentReadings, error) {
+		return invoke(n, user, "RecordShipmentReading", func(ctx contractapi.TransactionContextInterface) (*ShipmentReadings, error) {
+			return n.contract.RecordShipmentReading(ctx, payload, signature)
+		})
+	}
+
+	cold := payload("truck-7", ShipmentReading{Time: "2023-01-01T06:00:00Z", Temperature: 4.5, Humidity: 70}, ShipmentReading{Time: "2023-01-01T07:00:00Z", Temperature: 5, Humidity: 72})
+	_, err = record("distributor1", cold, sign(otherKey, cold))
+	expectError(t, err, "readings are not signed by device truck-7")
+	notTheCarrier := payload("truck-9", ShipmentReading{Time: "2023-01-01T06:00:00Z", Temperature: 4, Humidity: 70})
+	_, err = record("distributor2", notTheCarrier, sign(otherKey, notTheCarrier))
+	expectError(t, err, "Permission denied")
+	early := payload("truck-7", ShipmentReading{Time: "2022-12-31T23:00:00Z", Temperature: 4, Humidity: 70})
+	_, err = record("distributor1", early, sign(deviceKey, early))
+	expectError(t, err, "was not taken while order")
+
+	recorded, err := record("distributor1", cold, sign(deviceKey, cold))
+	expectNoError(t, err)
+	n.expectEventTypes("ShipmentReadingsRecorded")
+	if len(recorded.Breaches) != 0 || n.getOrder(order.OrderId).Status != "SHIPPING" {
+		t.Fatalf("readings within the threshold must not flag the order, got %+v", recorded)
+	}
+	_, err = record("distributor1", cold, sign(deviceKey, cold))
+	expectError(t, err, "are already recorded")
+
+	warm := payload("truck-7", ShipmentReading{Time: "2023-01-01T09:00:00Z", Temperature: 5, Humidity: 70}, ShipmentReading{Time: "2023-01-01T08:00:00Z", Temperature: 11.5, Humidity: 70})
+	recorded, err = record("distributor1", warm, sign(deviceKey, warm))
+	expectNoError(t, err)
+	n.expectEventTypes("ShipmentReadingsRecorded", "OrderColdChainBreach", "ProductColdChainBreach", "ProductColdChainBreach")
+	if len(recorded.Breaches) != 1 || recorded.Breaches[0] != "ST25" {
+		t.Fatalf("unexpected breaches %v", recorded.Breaches)
+	}
+
+	breached := n.getOrder(order.OrderId)
+	if breached.Status != "COLD_CHAIN_BREACH" || breached.ProductItemList[1].Product.Status != "COLD_CHAIN_BREACH" {
+		t.Fatalf("unexpected order %+v", breached)
+	}
+	if n.getProductCommercial(order.ProductItemList[0].Product.ProductCommercialId).Status != "COLD_CHAIN_BREACH" {
+		t.Fatal("breached products must be flagged")
+	}
+	_, err = n.orderAction("distributor1", "FinishOrder", order.OrderId)
+	expectError(t, err, "cannot move from COLD_CHAIN_BREACH to SHIPPED")
+
+	series := mustInvoke(n, "retailer1", "GetShipmentReadings", func(ctx contractapi.TransactionContextInterface) (*ShipmentReadingSeries, error) {
+		return n.contract.GetShipmentReadings(ctx, order.OrderId)
+	})
+	var times []string
+	var breaches []bool
+	for _, reading := range series.Readings {
+		times = append(times, reading.Time)
+		breaches = append(breaches, reading.Breach)
+	}
+	if len(times) != 4 || times[0] != "2023-01-01T06:00:00Z" || times[2] != "2023-01-01T08:00:00Z" || times[3] != "2023-01-01T09:00:00Z" || breaches[1] || !breaches[2] || breaches[3] {
+		t.Fatalf("unexpected series %v %v", times, breaches)
+	}
+
+	// a stricter threshold set later does not rewrite the recorded breaches
+	mustInvoke(n, "regulator1", "SetColdChainThreshold", func(ctx contractapi.TransactionContextInterface) (*ColdChainThreshold, error) {
+		return n.contract.SetColdChainThreshold(ctx, ColdChainThreshold{ProductCode: "ST25", MinTemperature: 2, MaxTemperature: 4, MinHumidity: 30, MaxHumidity: 90})
+	})
+	series = mustInvoke(n, "retailer1", "GetShipmentReadings", func(ctx contractapi.TransactionContextInterface) (*ShipmentReadingSeries, error) {
+		return n.contract.GetShipmentReadings(ctx, order.OrderId)
+	})
+	for i, reading := range series.Readings {
+		if reading.Breach != breaches[i] {
+			t.Fatalf("reading %d at %s changed its breach to %v", i, reading.Time, reading.Breach)
+		}
+	}
+}
+
+func TestColdChainBreachSkipsItemsThatLeftTheShipment(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"}, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "5"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+	mustInvoke(n, "regulator1", "SetColdChainThreshold", func(ctx contractapi.TransactionContextInterface) (*ColdChainThreshold, error) {
+		return n.contract.SetColdChainThreshold(ctx, ColdChainThreshold{ProductCode: "ST25", MinTemperature: 2, MaxTemperature: 8, MinHumidity: 30, MaxHumidity: 90})
+	})
+	deviceKey := n.registerDevice("distributor1", "truck-7")
+	n.ledger.Clock = time.Date(2023, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+	// the first item already reached the retailer
+	delivered := n.getProductCommercial(order.ProductItemList[0].Product.ProductCommercialId)
+	delivered.Status = "RETAILING"
+	deliveredAsBytes, _ := json.Marshal(delivered)
+	n.ledger.PutState(delivered.ProductCommercialId, deliveredAsBytes)
+
+	warmAsBytes, _ := json.Marshal(ShipmentReadingPayload{OrderId: order.OrderId, DeviceId: "truck-7", Readings: []ShipmentReading{{Time: "2023-01-01T08:00:00Z", Temperature: 11.5, Humidity: 70}}})
+	digest := sha256.Sum256(warmAsBytes)
+	recorded, err := invoke(n, "distributor1", "RecordShipmentReading", func(ctx contractapi.TransactionContextInterface) (*ShipmentReadings, error) {
+		return n.contract.RecordShipmentReading(ctx, string(warmAsBytes), base64.StdEncoding.EncodeToString(ed25519.Sign(deviceKey, digest[:])))
+	})
+	expectNoError(t, err)
+	n.expectEventTypes("ShipmentReadingsRecorded", "OrderColdChainBreach", "ProductColdChainBreach")
+	if len(recorded.BreachedReadings) != 1 || n.getOrder(order.OrderId).Status != "COLD_CHAIN_BREACH" {
+		t.Fatalf("unexpected readings %+v", recorded)
+	}
+	if status := n.getProductCommercial(delivered.ProductCommercialId).Status; status != "RETAILING" {
+		t.Fatalf("a delivered item must keep its status, got %s", status)
+	}
+	if status := n.getProductCommercial(order.ProductItemList[1].Product.ProductCommercialId).Status; status != "COLD_CHAIN_BREACH" {
+		t.Fatalf("a shipped item must be flagged, got %s", status)
+	}
+}
+
+func TestShipmentReadingsNeedAReadableShippingDate(t *testing.T) {
+	readings := []ShipmentReading{{Time: "2023-01-01T06:00:00Z", Temperature: 4, Humidity: 70}}
+	now := time.Date(2023, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+	order := &Order{OrderId: "Order-1", DeliveryStatuses: []DeliveryStatus{{Status: "SHIPPING", DeliveryDate: "2023-01-01T05:00:00.000000000Z"}}}
+	expectNoError(t, checkShipmentReadings(order, readings, now))
+	order.DeliveryStatuses[0].DeliveryDate = "2023-01-01 05:00:00 +0000 UTC"
+	expectNoError(t, checkShipmentReadings(order, readings, now))
+
+	order.DeliveryStatuses[0].DeliveryDate = "1 January"
+	expectError(t, checkShipmentReadings(order, readings, now), "invalid shipping date 1 January of order Order-1")
+}
@@ -0,0 +1,437 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// consumerOrderIndex links a retailer to the consumer orders placed with it.
+const consumerOrderIndex = "retailer~consumerOrderId"
+
+// Delivery methods of a consumer order. Pickup orders are handed over in store
+// and confirmed by the retailer, delivery orders are confirmed by the
+// consumer on receipt.
+const (
+	consumerPickup   = "PICKUP"
+	consumerDelivery = "DELIVERY"
+)
+
+// ConsumerOrder is a purchase of commercial products on retail by a consumer.
+// The consumer is only known by ConsumerId, a pseudonym derived from its
+// certificate.
+type ConsumerOrder struct {
+	ConsumerOrderId      string   `json:"consumerOrderId"`
+	ConsumerId           string   `json:"consumerId"`
+	ProductCommercialIds []string `json:"productCommercialIds"`
+	DeliveryMethod       string   `json:"deliveryMethod"`
+	Retailer             Actor    `json:"retailer"`
+	Status               string   `json:"status"`
+	CreateDate           string   `json:"createDate"`
+	UpdateDate           string   `json:"updateDate"`
+	FinishDate           string   `json:"finishDate"`
+}
+
+type ConsumerOrderForCreate struct {
+	ProductCommercialIds []string `json:"productCommercialIds"`
+	DeliveryMethod       string   `json:"deliveryMethod"`
+}
+
+// consumerId returns the pseudonym of the caller. The salt passed in the
+// transient map keeps it from being recomputed from the enrollment ID, so it
+// is required; the consumer must pass the same salt to confirm receipt.
+func consumerId(ctx contractapi.TransactionContextInterface) (string, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transient map: %s", err.Error())
+	}
+	salt, err := transientSaltOf(transientMap)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(identityKey(client.MSPId, client.EnrollmentId) + "\x00" + salt))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// consumerActor is the actor recorded for a consumer: its pseudonym only.
+func consumerActor(consumerId string) Actor {
+	return Actor{UserId: consumerId, Role: "consumer"}
+}
+
+// productCommercialOrder returns the order that created a commercial product,
+// or nil for commercial products recorded before orders were indexed.
+func productCommercialOrder(ctx contractapi.TransactionContextInterface, productCommercial *ProductCommercial) (*Order, error) {
+	sources, err := productSources(ctx, productCommercial.ProductId)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		if source[0] == productCommercial.ProductCommercialId {
+			return getOrder(ctx, source[1])
+		}
+	}
+	return nil, nil
+}
+
+// productCommercialRetailer returns the retailer whose order created a
+// commercial product.
+func productCommercialRetailer(ctx contractapi.TransactionContextInterface, productCommercial *ProductCommercial) (Actor, error) {
+	order, err := productCommercialOrder(ctx, productCommercial)
+	if err != nil {
+		return Actor{}, err
+	}
+	if order == nil {
+		return Actor{}, fmt.Errorf("no order created %s", productCommercial.ProductCommercialId)
+	}
+	return order.Retailer, nil
+}
+
+func getConsumerOrder(ctx contractapi.TransactionContextInterface, consumerOrderId string) (*ConsumerOrder, error) {
+	consumerOrderAsBytes, err := ctx.GetStub().GetState(consumerOrderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if consumerOrderAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", consumerOrderId)
+	}
+
+	consumerOrder := new(ConsumerOrder)
+	_ = json.Unmarshal(consumerOrderAsBytes, consumerOrder)
+
+	return consumerOrder, nil
+}
+
+// PlaceConsumerOrder reserves commercial products on retail for the caller.
+// All of them must be sold by the same retailer.
+func (s *OrderContract) PlaceConsumerOrder(ctx contractapi.TransactionContextInterface, orderObj ConsumerOrderForCreate) (*ConsumerOrder, error) {
+	_, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := consumerId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	actor := consumerActor(consumer)
+
+	if orderObj.DeliveryMethod != consumerPickup && orderObj.DeliveryMethod != consumerDelivery {
+		return nil, fmt.Errorf("delivery method must be %s or %s", consumerPickup, consumerDelivery)
+	}
+	if len(orderObj.ProductCommercialIds) == 0 {
+		return nil, fmt.Errorf("a consumer order needs at least one product")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	consumerOrder := ConsumerOrder{
+		ConsumerOrderId:      ledger.NewAssetId(ctx.GetStub(), "ConsumerOrder"),
+		ConsumerId:           consumer,
+		ProductCommercialIds: []string{},
+		DeliveryMethod:       orderObj.DeliveryMethod,
+		Status:               "PLACED",
+		CreateDate:           txTimeAsPtr,
+	}
+
+	var events []SupplyChainEvent
+	placed := map[string]bool{}
+	for i, productCommercialId := range orderObj.ProductCommercialIds {
+		if placed[productCommercialId] {
+			return nil, fmt.Errorf("product commercial %s is ordered twice", productCommercialId)
+		}
+		placed[productCommercialId] = true
+
+		productCommercial, err := getProductCommercial(ctx, productCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		err = checkProductCommercialTransition(productCommercial, "RESERVED")
+		if err != nil {
+			return nil, err
+		}
+		err = checkNotExpired(ctx, productCommercial.ProductCommercialId, productCommercial.Expired)
+		if err != nil {
+			return nil, err
+		}
+
+		retailer, err := productCommercialRetailer(ctx, productCommercial)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && !sameActor(retailer, consumerOrder.Retailer) {
+			return nil, fmt.Errorf("products of one consumer order must come from the same retailer")
+		}
+		consumerOrder.Retailer = retailer
+
+		oldStatus := productCommercial.Status
+		productCommercial.Dates = append(productCommercial.Dates, ProductDate{
+			Status: "RESERVED",
+			Time:   txTimeAsPtr,
+			Actor:  actor,
+		})
+		productCommercial.Status = "RESERVED"
+
+		productCommercialAsBytes, _ := json.Marshal(productCommercial)
+		ctx.GetStub().PutState(productCommercial.ProductCommercialId, productCommercialAsBytes)
+
+		consumerOrder.ProductCommercialIds = append(consumerOrder.ProductCommercialIds, productCommercialId)
+		events = append(events, newEvent(ctx, "ProductReserved", "ProductCommercial", productCommercial.ProductCommercialId, oldStatus, productCommercial.Status, actor, txTimeAsPtr))
+	}
+
+	consumerOrderAsBytes, _ := json.Marshal(consumerOrder)
+	ctx.GetStub().PutState(consumerOrder.ConsumerOrderId, consumerOrderAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), consumerOrderIndex, consumerOrder.Retailer.UserId, consumerOrder.ConsumerOrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "ConsumerOrderPlaced", "ConsumerOrder", consumerOrder.ConsumerOrderId, "", consumerOrder.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consumerOrder, nil
+}
+
+// ConfirmPickup is called by the retailer when the consumer collects a pickup
+// order. The products are sold to the consumer.
+func (s *OrderContract) ConfirmPickup(ctx contractapi.TransactionContextInterface, consumerOrderId string) (*ConsumerOrder, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	consumerOrder, err := getConsumerOrder(ctx, consumerOrderId)
+	if err != nil {
+		return nil, err
+	}
+	if !sameActor(consumerOrder.Retailer, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	if consumerOrder.DeliveryMethod != consumerPickup {
+		return nil, fmt.Errorf("consumer order %s is not a pickup order", consumerOrderId)
+	}
+
+	return completeConsumerOrder(ctx, consumerOrder, "PICKED_UP", "ConsumerOrderPickedUp", actor)
+}
+
+// ConfirmReceipt is called by the consumer when a delivery order arrives. The
+// products are sold to the consumer.
+func (s *OrderContract) ConfirmReceipt(ctx contractapi.TransactionContextInterface, consumerOrderId string) (*ConsumerOrder, error) {
+	_, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := consumerId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	consumerOrder, err := getConsumerOrder(ctx, consumerOrderId)
+	if err != nil {
+		return nil, err
+	}
+	if consumerOrder.ConsumerId != consumer {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	if consumerOrder.DeliveryMethod != consumerDelivery {
+		return nil, fmt.Errorf("consumer order %s is not a delivery order", consumerOrderId)
+	}
+
+	return completeConsumerOrder(ctx, consumerOrder, "RECEIVED", "ConsumerOrderReceived", consumerActor(consumer))
+}
+
+// completeConsumerOrder moves a consumer order to status and sells its
+// products the way SellProduct does, recording the consumer pseudonym as
+// buyer.
+func completeConsumerOrder(ctx contractapi.TransactionContextInterface, consumerOrder *ConsumerOrder, status string, eventType string, actor Actor) (*ConsumerOrder, error) {
+	err := checkConsumerOrderTransition(consumerOrder, status)
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := consumerOrder.Status
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	var events []SupplyChainEvent
+	for _, productCommercialId := range consumerOrder.ProductCommercialIds {
+		productCommercial, err := getProductCommercial(ctx, productCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		err = checkProductCommercialTransition(productCommercial, "SOLD")
+		if err != nil {
+			return nil, err
+		}
+		err = checkNotExpired(ctx, productCommercial.ProductCommercialId, productCommercial.Expired)
+		if err != nil {
+			return nil, err
+		}
+
+		oldItemStatus := productCommercial.Status
+		productCommercial.Dates = append(productCommercial.Dates, ProductDate{
+			Status: "SOLD",
+			Time:   txTimeAsPtr,
+			Actor:  consumerOrder.Retailer,
+		})
+		productCommercial.Status = "SOLD"
+		productCommercial.SoldTo = consumerOrder.ConsumerId
+
+		productCommercialAsBytes, _ := json.Marshal(productCommercial)
+		ctx.GetStub().PutState(productCommercial.ProductCommercialId, productCommercialAsBytes)
+		events = append(events, newEvent(ctx, "ProductSold", "ProductCommercial", productCommercial.ProductCommercialId, oldItemStatus, productCommercial.Status, actor, txTimeAsPtr))
+	}
+
+	consumerOrder.Status = status
+	consumerOrder.UpdateDate = txTimeAsPtr
+	consumerOrder.FinishDate = txTimeAsPtr
+
+	consumerOrderAsBytes, _ := json.Marshal(consumerOrder)
+	ctx.GetStub().PutState(consumerOrder.ConsumerOrderId, consumerOrderAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, eventType, "ConsumerOrder", consumerOrder.ConsumerOrderId, oldStatus, consumerOrder.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return consumerOrder, nil
+}
+
+// CancelConsumerOrder releases the products of a placed consumer order back
+// to retail. Either the consumer or the retailer may cancel.
+func (s *OrderContract) CancelConsumerOrder(ctx contractapi.TransactionContextInterface, consumerOrderId string) (*ConsumerOrder, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	consumerOrder, err := getConsumerOrder(ctx, consumerOrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	var actor Actor
+	if client.Role == "retailer" {
+		actor, err = getActor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !sameActor(consumerOrder.Retailer, actor) {
+			return nil, fmt.Errorf("Permission denied!")
+		}
+	} else {
+		_, err = getCallerIdentity(ctx)
+		if err != nil {
+			return nil, err
+		}
+		consumer, err := consumerId(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if consumerOrder.ConsumerId != consumer {
+			return nil, fmt.Errorf("Permission denied!")
+		}
+		actor = consumerActor(consumer)
+	}
+
+	err = checkConsumerOrderTransition(consumerOrder, "CANCELLED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := consumerOrder.Status
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	// recalled products stay recalled
+	var events []SupplyChainEvent
+	for _, productCommercialId := range consumerOrder.ProductCommercialIds {
+		productCommercial, err := getProductCommercial(ctx, productCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		if productCommercial.Status != "RESERVED" {
+			continue
+		}
+
+		productCommercial.Dates = append(productCommercial.Dates, ProductDate{
+			Status: "RETAILING",
+			Time:   txTimeAsPtr,
+			Actor:  actor,
+		})
+		productCommercial.Status = "RETAILING"
+
+		productCommercialAsBytes, _ := json.Marshal(productCommercial)
+		ctx.GetStub().PutState(productCommercial.ProductCommercialId, productCommercialAsBytes)
+		events = append(events, newEvent(ctx, "ProductReleased", "ProductCommercial", productCommercial.ProductCommercialId, "RESERVED", productCommercial.Status, actor, txTimeAsPtr))
+	}
+
+	consumerOrder.Status = "CANCELLED"
+	consumerOrder.UpdateDate = txTimeAsPtr
+
+	consumerOrderAsBytes, _ := json.Marshal(consumerOrder)
+	ctx.GetStub().PutState(consumerOrder.ConsumerOrderId, consumerOrderAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "ConsumerOrderCancelled", "ConsumerOrder", consumerOrder.ConsumerOrderId, oldStatus, consumerOrder.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return consumerOrder, nil
+}
+
+func (s *OrderContract) GetConsumerOrder(ctx contractapi.TransactionContextInterface, consumerOrderId string) (*ConsumerOrder, error) {
+	return getConsumerOrder(ctx, consumerOrderId)
+}
+
+// GetConsumerOrdersOfRetailer returns the consumer orders placed with a
+// retailer, optionally only those in status.
+func (s *OrderContract) GetConsumerOrdersOfRetailer(ctx contractapi.TransactionContextInterface, userId string, status string) ([]*ConsumerOrder, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consumerOrderIndex, []string{userId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	consumerOrders := []*ConsumerOrder{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		consumerOrderAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		consumerOrder := new(ConsumerOrder)
+		_ = json.Unmarshal(consumerOrderAsBytes, consumerOrder)
+
+		if status == "" || consumerOrder.Status == status {
+			consumerOrders = append(consumerOrders, consumerOrder)
+		}
+	}
+
+	return consumerOrders, nil
+}
@@ -0,0 +1,163 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// retailing returns the ids of commercial products RETAILING at retailer, one
+// per quantity.
+func (n *testNetwork) retailing(retailer string, quantities ...string) []string {
+	n.t.Helper()
+	product := n.manufactured("100")
+	var items []ProductIdQRCodeItem
+	for _, quantity := range quantities {
+		items = append(items, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: quantity})
+	}
+	order := n.mustCreateOrder(retailer, items...)
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+	n.mustOrderAction("distributor1", "FinishOrder", order.OrderId)
+
+	var productCommercialIds []string
+	for _, item := range order.ProductItemList {
+		productCommercialIds = append(productCommercialIds, item.Product.ProductCommercialId)
+	}
+	return productCommercialIds
+}
+
+func TestConsumerPickupOrder(t *testing.T) {
+	n := newTestNetwork(t)
+	atRetailer1 := n.retailing("retailer1", "1", "1")
+	atRetailer2 := n.retailing("retailer2", "1")
+
+	salt := map[string]string{transientSalt: "consumer1 secret"}
+	placeSalted := func(user string, salt map[string]string, deliveryMethod string, productCommercialIds ...string) (*ConsumerOrder, error) {
+		return invokeWithTransient(n, user, "PlaceConsumerOrder", salt, func(ctx contractapi.TransactionContextInterface) (*ConsumerOrder, error) {
+			return n.contract.PlaceConsumerOrder(ctx, ConsumerOrderForCreate{ProductCommercialIds: productCommercialIds, DeliveryMethod: deliveryMethod})
+		})
+	}
+	place := func(user string, deliveryMethod string, productCommercialIds ...string) (*ConsumerOrder, error) {
+		return placeSalted(user, salt, deliveryMethod, productCommercialIds...)
+	}
+	consumerAction := func(user string, function string, consumerOrderId string) (*ConsumerOrder, error) {
+		return invokeWithTransient(n, user, function, salt, func(ctx contractapi.TransactionContextInterface) (*ConsumerOrder, error) {
+			switch function {
+			case "ConfirmPickup":
+				return n.contract.ConfirmPickup(ctx, consumerOrderId)
+			case "ConfirmReceipt":
+				return n.contract.ConfirmReceipt(ctx, consumerOrderId)
+			}
+			return n.contract.CancelConsumerOrder(ctx, consumerOrderId)
+		})
+	}
+
+	_, err := place("retailer1", consumerPickup, atRetailer1[0])
+	expectError(t, err, "is not allowed to invoke PlaceConsumerOrder")
+	_, err = place("consumer1", "DRONE", atRetailer1[0])
+	expectError(t, err, "delivery method must be")
+	_, err = place("consumer1", consumerPickup, atRetailer1[0], atRetailer2[0])
+	expectError(t, err, "must come from the same retailer")
+	_, err = placeSalted("consumer1", nil, consumerPickup, atRetailer1[0])
+	expectError(t, err, "a salt of at least 16 bytes")
+	_, err = placeSalted("consumer1", map[string]string{transientSalt: "short"}, consumerPickup, atRetailer1[0])
+	expectError(t, err, "a salt of at least 16 bytes")
+
+	consumerOrder, err := place("consumer1", consumerPickup, atRetailer1...)
+	expectNoError(t, err)
+	n.expectEventTypes("ConsumerOrderPlaced", "ProductReserved", "ProductReserved")
+	if consumerOrder.Status != "PLACED" || consumerOrder.Retailer.UserId != "retailer1" || len(consumerOrder.ConsumerId) != 64 || strings.Contains(consumerOrder.ConsumerId, "consumer1") {
+		t.Fatalf("unexpected consumer order %+v", consumerOrder)
+	}
+	if n.getProductCommercial(atRetailer1[0]).Status != "RESERVED" {
+		t.Fatal("placed products must be reserved")
+	}
+
+	_, err = place("consumer1", consumerPickup, atRetailer1[0])
+	expectError(t, err, "cannot move from RESERVED to RESERVED")
+	_, err = invoke(n, "retailer1", "SellProduct", func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+		return n.contract.SellProduct(ctx, ProductCommercial{ProductCommercialId: atRetailer1[0]})
+	})
+	expectError(t, err, "is reserved by a consumer order")
+
+	_, err = consumerAction("consumer1", "ConfirmReceipt", consumerOrder.ConsumerOrderId)
+	expectError(t, err, "is not a delivery order")
+	_, err = consumerAction("retailer2", "ConfirmPickup", consumerOrder.ConsumerOrderId)
+	expectError(t, err, "Permission denied")
+
+	pickedUp, err := consumerAction("retailer1", "ConfirmPickup", consumerOrder.ConsumerOrderId)
+	expectNoError(t, err)
+	n.expectEventTypes("ConsumerOrderPickedUp", "ProductSold", "ProductSold")
+	if pickedUp.Status != "PICKED_UP" || pickedUp.FinishDate == "" {
+		t.Fatalf("unexpected consumer order %+v", pickedUp)
+	}
+	sold := n.getProductCommercial(atRetailer1[1])
+	if sold.Status != "SOLD" || sold.SoldTo != consumerOrder.ConsumerId || sold.Dates[len(sold.Dates)-1].Actor.UserId != "retailer1" {
+		t.Fatalf("unexpected sold product %+v", sold)
+	}
+
+	_, err = consumerAction("consumer1", "CancelConsumerOrder", consumerOrder.ConsumerOrderId)
+	expectError(t, err, "cannot move from PICKED_UP to CANCELLED")
+
+	orders := mustInvoke(n, "retailer1", "GetConsumerOrdersOfRetailer", func(ctx contractapi.TransactionContextInterface) ([]*ConsumerOrder, error) {
+		return n.contract.GetConsumerOrdersOfRetailer(ctx, "retailer1", "PICKED_UP")
+	})
+	if len(orders) != 1 || orders[0].ConsumerOrderId != consumerOrder.ConsumerOrderId {
+		t.Fatalf("unexpected consumer orders %+v", orders)
+	}
+}
+
+func TestConsumerDeliveryOrderUsesASaltedPseudonym(t *testing.T) {
+	n := newTestNetwork(t)
+	productCommercialIds := n.retailing("retailer1", "1", "1")
+	salt := map[string]string{transientSalt: "consumer1 secret"}
+
+	consumerAction := func(user string, function string, transient map[string]string, consumerOrderId string) (*ConsumerOrder, error) {
+		return invokeWithTransient(n, user, function, transient, func(ctx contractapi.TransactionContextInterface) (*ConsumerOrder, error) {
+			if function == "ConfirmReceipt" {
+				return n.contract.ConfirmReceipt(ctx, consumerOrderId)
+			}
+			return n.contract.CancelConsumerOrder(ctx, consumerOrderId)
+		})
+	}
+
+	cancelled, err := invokeWithTransient(n, "consumer1", "PlaceConsumerOrder", salt, func(ctx contractapi.TransactionContextInterface) (*ConsumerOrder, error) {
+		return n.contract.PlaceConsumerOrder(ctx, ConsumerOrderForCreate{ProductCommercialIds: productCommercialIds[:1], DeliveryMethod: consumerDelivery})
+	})
+	expectNoError(t, err)
+	_, err = consumerAction("regulator1", "CancelConsumerOrder", salt, cancelled.ConsumerOrderId)
+	expectError(t, err, "is not allowed to invoke CancelConsumerOrder")
+	_, err = consumerAction("consumer1", "CancelConsumerOrder", map[string]string{transientSalt: "consumer1 guessed"}, cancelled.ConsumerOrderId)
+	expectError(t, err, "Permission denied")
+	_, err = consumerAction("consumer1", "CancelConsumerOrder", salt, cancelled.ConsumerOrderId)
+	expectNoError(t, err)
+	n.expectEventTypes("ConsumerOrderCancelled", "ProductReleased")
+	if n.getProductCommercial(productCommercialIds[0]).Status != "RETAILING" {
+		t.Fatal("cancelled products must go back to retail")
+	}
+
+	consumerOrder, err := invokeWithTransient(n, "consumer1", "PlaceConsumerOrder", salt, func(ctx contractapi.TransactionContextInterface) (*ConsumerOrder, error) {
+		return n.contract.PlaceConsumerOrder(ctx, ConsumerOrderForCreate{ProductCommercialIds: productCommercialIds, DeliveryMethod: consumerDelivery})
+	})
+	expectNoError(t, err)
+	if consumerOrder.ConsumerId != cancelled.ConsumerId {
+		t.Fatal("the same salt must give the same pseudonym")
+	}
+
+	_, err = consumerAction("consumer1", "ConfirmReceipt", map[string]string{transientSalt: "another long salt"}, consumerOrder.ConsumerOrderId)
+	expectError(t, err, "Permission denied")
+	received, err := consumerAction("consumer1", "ConfirmReceipt", salt, consumerOrder.ConsumerOrderId)
+	expectNoError(t, err)
+	n.expectEventTypes("ConsumerOrderReceived", "ProductSold", "ProductSold")
+	if received.Status != "RECEIVED" {
+		t.Fatalf("unexpected consumer order %+v", received)
+	}
+	for _, event := range n.lastEvents() {
+		if event.Actor.UserId != consumerOrder.ConsumerId || event.Actor.FullName != "" || event.Actor.PhoneNumber != "" {
+			t.Fatalf("consumer events must carry the pseudonym only, got %+v", event.Actor)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// documentIndex finds the assets a document digest is anchored on.
+const documentIndex = "digest~assetType~assetId"
+
+// documentKinds are the kinds of document an asset can carry. Images and
+// certificates of a product fill in its Image and CertificateUrl.
+var documentKinds = []string{"IMAGE", "CERTIFICATE", "OTHER"}
+
+// documentAssetTypes are the assets documents can be attached to.
+var documentAssetTypes = []string{"Product", "Order", "Certificate"}
+
+// Document describes a document kept off chain. Digest is the hex SHA-256
+// hash of its content, so a copy fetched from Uri can be checked against it.
+type Document struct {
+	Kind       string `json:"kind"`
+	Uri        string `json:"uri"`
+	Digest     string `json:"digest"`
+	MediaType  string `json:"mediaType"`
+	Size       int64  `json:"size"`
+	Uploader   Actor  `json:"uploader"`
+	UploadDate string `json:"uploadDate"`
+}
+
+type DocumentForAttach struct {
+	AssetType string `json:"assetType"`
+	AssetId   string `json:"assetId"`
+	Kind      string `json:"kind"`
+	Uri       string `json:"uri"`
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
+
+// DocumentAnchor is a document as attached to one asset.
+type DocumentAnchor struct {
+	AssetType string   `json:"assetType"`
+	AssetId   string   `json:"assetId"`
+	Document  Document `json:"document"`
+}
+
+// DocumentVerification tells whether a digest was anchored, and where.
+type DocumentVerification struct {
+	Digest   string           `json:"digest"`
+	Anchored bool             `json:"anchored"`
+	Anchors  []DocumentAnchor `json:"anchors"`
+}
+
+// checkDocument validates the descriptor of a document to attach and returns
+// its digest in lower case.
+func checkDocument(documentObj DocumentForAttach) (string, error) {
+	if !containsString(documentKinds, documentObj.Kind) {
+		return "", fmt.Errorf("document kind must be one of %v", documentKinds)
+	}
+	uri, err := url.Parse(documentObj.Uri)
+	if err != nil || uri.Scheme == "" {
+		return "", fmt.Errorf("document uri must be an absolute URI")
+	}
+	digest := strings.ToLower(documentObj.Digest)
+	digestAsBytes, err := hex.DecodeString(digest)
+	if err != nil || len(digestAsBytes) != 32 {
+		return "", fmt.Errorf("document digest must be a hex SHA-256 hash")
+	}
+	mediaType, _, err := mime.ParseMediaType(documentObj.MediaType)
+	if err != nil {
+		return "", fmt.Errorf("invalid media type %s", documentObj.MediaType)
+	}
+	if documentObj.Kind == "IMAGE" && !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("images must have an image media type")
+	}
+	if documentObj.Size <= 0 {
+		return "", fmt.Errorf("document size must be positive")
+	}
+	return digest, nil
+}
+
+// appendDocument adds document to documents unless its digest is already
+// attached.
+func appendDocument(documents []Document, document Document, assetId string) ([]Document, error) {
+	for _, attached := range documents {
+		if attached.Digest == document.Digest {
+			return nil, fmt.Errorf("document %s is already attached to %s", document.Digest, assetId)
+		}
+	}
+	return append(documents, document), nil
+}
+
+// AttachDocument anchors an off-chain document on a product, an order or a
+// certificate. Products take documents from their holder, orders from their
+// retailer, manufacturer or distributor and certificates from their issuer.
+func (s *ProductContract) AttachDocument(ctx contractapi.TransactionContextInterface, documentObj DocumentForAttach) (*Document, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := checkDocument(documentObj)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	document := Document{
+		Kind:       documentObj.Kind,
+		Uri:        documentObj.Uri,
+		Digest:     digest,
+		MediaType:  documentObj.MediaType,
+		Size:       documentObj.Size,
+		Uploader:   actor,
+		UploadDate: txTimeAsPtr,
+	}
+
+	var status string
+	var assetAsBytes []byte
+	switch documentObj.AssetType {
+	case "Product":
+		product, err := getProduct(ctx, documentObj.AssetId)
+		if err != nil {
+			return nil, err
+		}
+		if !sameActor(productHolder(product), actor) {
+			return nil, fmt.Errorf("Permission denied!")
+		}
+		product.Documents, err = appendDocument(product.Documents, document, product.ProductId)
+		if err != nil {
+			return nil, err
+		}
+		switch document.Kind {
+		case "IMAGE":
+			product.Image = append(product.Image, document.Uri)
+		case "CERTIFICATE":
+			product.CertificateUrl = document.Uri
+		}
+		status = product.Status
+		assetAsBytes, _ = json.Marshal(product)
+	case "Order":
+		order, err := getOrder(ctx, documentObj.AssetId)
+		if err != nil {
+			return nil, err
+		}
+		if !sameActor(actor, order.Retailer) && !sameActor(actor, order.Manufacturer) && !sameActor(actor, order.Distributor) {
+			return nil, fmt.Errorf("Permission denied!")
+		}
+		order.Documents, err = appendDocument(order.Documents, document, order.OrderId)
+		if err != nil {
+			return nil, err
+		}
+		status = order.Status
+		assetAsBytes, _ = json.Marshal(order)
+	case "Certificate":
+		certificate, err := getCertificate(ctx, documentObj.AssetId)
+		if err != nil {
+			return nil, err
+		}
+		if !sameActor(certificate.Issuer, actor) {
+			return nil, fmt.Errorf("Permission denied!")
+		}
+		if document.Kind == "CERTIFICATE" && document.Digest != certificate.DocumentHash {
+			return nil, fmt.Errorf("document %s is not the document of certificate %s", document.Digest, certificate.CertificateId)
+		}
+		certificate.Documents, err = appendDocument(certificate.Documents, document, certificate.CertificateId)
+		if err != nil {
+			return nil, err
+		}
+		status = certificate.Status
+		assetAsBytes, _ = json.Marshal(certificate)
+	default:
+		return nil, fmt.Errorf("documents can be attached to %v", documentAssetTypes)
+	}
+
+	ctx.GetStub().PutState(documentObj.AssetId, assetAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), documentIndex, document.Digest, documentObj.AssetType, documentObj.AssetId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvents(ctx, newEvent(ctx, "DocumentAttached", documentObj.AssetType, documentObj.AssetId, status, status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// VerifyDocument tells whether digest, the hex SHA-256 hash of a document,
+// was anchored, and returns every asset it is attached to.
+func (s *ProductContract) VerifyDocument(ctx contractapi.TransactionContextInterface, digest string) (*DocumentVerification, error) {
+	digest = strings.ToLower(digest)
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(documentIndex, []string{digest})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	verification := DocumentVerification{Digest: digest, Anchors: []DocumentAnchor{}}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(response.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(attributes) != 3 {
+			return nil, fmt.Errorf("malformed index key %s", response.Key)
+		}
+
+		assetAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var asset struct {
+			Documents []Document `json:"documents"`
+		}
+		_ = json.Unmarshal(assetAsBytes, &asset)
+		for _, document := range asset.Documents {
+			if document.Digest == digest {
+				verification.Anchors = append(verification.Anchors, DocumentAnchor{AssetType: attributes[1], AssetId: attributes[2], Document: document})
+			}
+		}
+	}
+	verification.Anchored = len(verification.Anchors) > 0
+
+	return &verification, nil
+}
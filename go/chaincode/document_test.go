@@ -0,0 +1,133 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (n *testNetwork) attachDocument(user string, documentObj DocumentForAttach) (*Document, error) {
+	n.t.Helper()
+	return invoke(n, user, "AttachDocument", func(ctx contractapi.TransactionContextInterface) (*Document, error) {
+		return n.contract.AttachDocument(ctx, documentObj)
+	})
+}
+
+func digestOf(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestAttachAndVerifyDocuments(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.harvest("supplier1", "ST25", "100")
+	photo := DocumentForAttach{AssetType: "Product", AssetId: product.ProductId, Kind: "IMAGE", Uri: "ipfs://bafy-field-photo", Digest: strings.ToUpper(digestOf("field photo")), MediaType: "image/jpeg", Size: 2048}
+
+	_, err := invoke(n, "supplier1", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductName: "Rice", ProductCode: "ST25", Amount: "1", Unit: "kg", Image: []string{"https://example.com/swap-me.jpg"}})
+	})
+	expectError(t, err, "must be attached with AttachDocument")
+
+	_, err = n.attachDocument("supplier2", photo)
+	expectError(t, err, "Permission denied")
+	_, err = n.attachDocument("supplier1", DocumentForAttach{AssetType: "Product", AssetId: product.ProductId, Kind: "IMAGE", Uri: "field-photo.jpg", Digest: digestOf("field photo"), MediaType: "image/jpeg", Size: 2048})
+	expectError(t, err, "must be an absolute URI")
+	_, err = n.attachDocument("supplier1", DocumentForAttach{AssetType: "Product", AssetId: product.ProductId, Kind: "IMAGE", Uri: "ipfs://bafy-field-photo", Digest: "abc", MediaType: "image/jpeg", Size: 2048})
+	expectError(t, err, "must be a hex SHA-256 hash")
+	_, err = n.attachDocument("supplier1", DocumentForAttach{AssetType: "Product", AssetId: product.ProductId, Kind: "IMAGE", Uri: "ipfs://bafy-field-photo", Digest: digestOf("field photo"), MediaType: "application/pdf", Size: 2048})
+	expectError(t, err, "images must have an image media type")
+	_, err = n.attachDocument("supplier1", DocumentForAttach{AssetType: "Recall", AssetId: product.ProductId, Kind: "OTHER", Uri: "ipfs://bafy-note", Digest: digestOf("note"), MediaType: "text/plain", Size: 4})
+	expectError(t, err, "documents can be attached to")
+
+	document, err := n.attachDocument("supplier1", photo)
+	expectNoError(t, err)
+	n.expectEventTypes("DocumentAttached")
+	if document.Digest != digestOf("field photo") || document.Uploader.UserId != "supplier1" {
+		t.Fatalf("unexpected document %+v", document)
+	}
+	_, err = n.attachDocument("supplier1", photo)
+	expectError(t, err, "is already attached to")
+
+	// the image reaches the product and is kept as it moves on
+	manufactured := n.manufacture("manufacturer1", product.ProductId, "2030-01-01")
+	if len(manufactured.Image) != 1 || manufactured.Image[0] != "ipfs://bafy-field-photo" || len(manufactured.Documents) != 1 {
+		t.Fatalf("unexpected product %+v", manufactured)
+	}
+	_, err = invoke(n, "manufacturer1", "UpdateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		swapped := *manufactured
+		swapped.Image = []string{"https://example.com/swap-me.jpg"}
+		swapped.Documents = nil
+		return n.contract.UpdateProduct(ctx, swapped)
+	})
+	expectNoError(t, err)
+	if updated := n.getProduct(product.ProductId); updated.Image[0] != "ipfs://bafy-field-photo" || len(updated.Documents) != 1 {
+		t.Fatalf("updating a product must keep its anchored documents, got %+v", updated)
+	}
+
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	invoice := DocumentForAttach{AssetType: "Order", AssetId: order.OrderId, Kind: "OTHER", Uri: "https://docs.example.com/invoice-1.pdf", Digest: digestOf("invoice"), MediaType: "application/pdf", Size: 512}
+	_, err = n.attachDocument("retailer2", invoice)
+	expectError(t, err, "Permission denied")
+	_, err = n.attachDocument("retailer1", invoice)
+	expectNoError(t, err)
+
+	certificate, err := n.issueCertificate("certifier1", CertificateForIssue{Type: "VIETGAP", ProductCode: "ST25", HolderId: "supplier1", HolderMSPId: "SupplierMSP", ValidFrom: "2023-01-01", ValidUntil: "2024-01-01", DocumentHash: digestOf("scan")})
+	expectNoError(t, err)
+	_, err = n.attachDocument("certifier1", DocumentForAttach{AssetType: "Certificate", AssetId: certificate.CertificateId, Kind: "CERTIFICATE", Uri: "ipfs://bafy-other-scan", Digest: digestOf("other scan"), MediaType: "application/pdf", Size: 100})
+	expectError(t, err, "is not the document of certificate")
+	_, err = n.attachDocument("certifier1", DocumentForAttach{AssetType: "Certificate", AssetId: certificate.CertificateId, Kind: "CERTIFICATE", Uri: "ipfs://bafy-scan", Digest: digestOf("scan"), MediaType: "application/pdf", Size: 100})
+	expectNoError(t, err)
+
+	verify := func(digest string) *DocumentVerification {
+		return mustInvoke(n, "consumer1", "VerifyDocument", func(ctx contractapi.TransactionContextInterface) (*DocumentVerification, error) {
+			return n.contract.VerifyDocument(ctx, digest)
+		})
+	}
+	if verification := verify(digestOf("tampered photo")); verification.Anchored || len(verification.Anchors) != 0 {
+		t.Fatalf("unexpected verification %+v", verification)
+	}
+	for digest, assetId := range map[string]string{digestOf("field photo"): product.ProductId, digestOf("invoice"): order.OrderId, digestOf("scan"): certificate.CertificateId} {
+		verification := verify(digest)
+		if !verification.Anchored || len(verification.Anchors) != 1 || verification.Anchors[0].AssetId != assetId || verification.Anchors[0].Document.Digest != digest {
+			t.Fatalf("unexpected verification %+v", verification)
+		}
+	}
+}
+
+func TestProductsWithoutImagesThroughRouter(t *testing.T) {
+	n := newTestNetwork(t)
+	chaincode, err := NewChaincode()
+	expectNoError(t, err)
+
+	route := func(user string, function string, args ...string) *Product {
+		t.Helper()
+		stub := n.ledger.NewStub(n.identities[user].Creator, function, args...)
+		response := chaincode.Invoke(stub)
+		if response.Status != http.StatusOK {
+			t.Fatalf("%s failed: %s", function, response.Message)
+		}
+		stub.Commit()
+		product := new(Product)
+		expectNoError(t, json.Unmarshal(response.Payload, product))
+		return product
+	}
+
+	cultivated := route("supplier1", "CultivateProduct", `{"productName":"Rice","productCode":"ST25","amount":"10","unit":"kg","description":"","certificateUrl":""}`)
+	inventory, _ := json.Marshal(Product{ProductCode: "ST25", ProductName: "Rice", Dates: []ProductDate{}, Expired: "2030-01-01", Amount: "10", Unit: "kg"})
+	inventoried := route("manufacturer1", "InventoryProduct", string(inventory))
+	if strings.Contains(string(inventory), "image") || len(inventoried.Image) != 0 {
+		t.Fatalf("unexpected product %+v", inventoried)
+	}
+
+	cultivated.Description = "fragrant rice"
+	update, _ := json.Marshal(cultivated)
+	updated := route("supplier1", "UpdateProduct", string(update))
+	if updated.Description != "fragrant rice" || len(updated.Image) != 0 {
+		t.Fatalf("unexpected product %+v", updated)
+	}
+}
@@ -0,0 +1,77 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// batchEventName is the name of the event emitted when a transaction changes
+// several assets. Fabric keeps a single event per transaction, so those
+// changes are delivered together instead of one event each.
+const batchEventName = "SupplyChainBatch"
+
+// SupplyChainEvent is the payload of the event emitted for a status change.
+// The event name is EventType. Its JSON form is relied on by event listeners
+// and must stay stable.
+type SupplyChainEvent struct {
+	EventType string `json:"eventType"`
+	AssetType string `json:"assetType"`
+	AssetId   string `json:"assetId"`
+	OldStatus string `json:"oldStatus"`
+	NewStatus string `json:"newStatus"`
+	Actor     Actor  `json:"actor"`
+	Timestamp string `json:"timestamp"`
+	TxId      string `json:"txId"`
+}
+
+// SupplyChainBatchEvent is the payload of the SupplyChainBatch event.
+type SupplyChainBatchEvent struct {
+	TxId      string             `json:"txId"`
+	Timestamp string             `json:"timestamp"`
+	Events    []SupplyChainEvent `json:"events"`
+}
+
+func newEvent(ctx contractapi.TransactionContextInterface, eventType string, assetType string, assetId string, oldStatus string, newStatus string, actor Actor, timestamp string) SupplyChainEvent {
+	return SupplyChainEvent{
+		EventType: eventType,
+		AssetType: assetType,
+		AssetId:   assetId,
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		Actor:     actor,
+		Timestamp: timestamp,
+		TxId:      ctx.GetStub().GetTxID(),
+	}
+}
+
+// emitEvents sets the event of the transaction: the event itself when there is
+// one, a SupplyChainBatch event when there are several. It must be called at
+// most once per transaction.
+func emitEvents(ctx contractapi.TransactionContextInterface, events ...SupplyChainEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if len(events) == 1 {
+		eventAsBytes, _ := json.Marshal(events[0])
+		err := ctx.GetStub().SetEvent(events[0].EventType, eventAsBytes)
+		if err != nil {
+			return fmt.Errorf("failed to set event: %s", err.Error())
+		}
+		return nil
+	}
+
+	batch := SupplyChainBatchEvent{
+		TxId:      ctx.GetStub().GetTxID(),
+		Timestamp: events[0].Timestamp,
+		Events:    events,
+	}
+	batchAsBytes, _ := json.Marshal(batch)
+	err := ctx.GetStub().SetEvent(batchEventName, batchAsBytes)
+	if err != nil {
+		return fmt.Errorf("failed to set event: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/dates"
+	"supplychain/internal/ledger"
+)
+
+// maxSweepSize bounds the products SweepExpiredProducts marks in one
+// transaction, to keep its write set small.
+const maxSweepSize = 100
+
+// ExpirySweep is the result of one SweepExpiredProducts transaction. While
+// Remaining is true, more expired products are left for another sweep.
+type ExpirySweep struct {
+	ProductIds           []string `json:"productIds"`
+	ProductCommercialIds []string `json:"productCommercialIds"`
+	Remaining            bool     `json:"remaining"`
+}
+
+// parseExpiry normalizes an expiry date, given as RFC 3339 time or as a plain
+// date meaning its start in UTC, into RFC 3339 UTC. Expiry dates in this form
+// sort as strings, which the CouchDB sweep query relies on.
+func parseExpiry(value string) (string, error) {
+	t, err := dates.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid expiry date %s, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTime, err := ledger.TxTime(ctx.GetStub())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("transaction timeStamp error")
+	}
+	return txTime.UTC(), nil
+}
+
+// checkNotExpired fails when an asset expired before the transaction time.
+// Expiry dates recorded before they were parsed are not enforced.
+func checkNotExpired(ctx contractapi.TransactionContextInterface, assetId string, expired string) error {
+	if expired == "" {
+		return nil
+	}
+	expireTime, err := time.Parse(time.RFC3339, expired)
+	if err != nil {
+		return nil
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !now.Before(expireTime) {
+		return fmt.Errorf("%s expired at %s", assetId, expired)
+	}
+	return nil
+}
+
+// sweepExpired runs the sweep query for selector and passes each result to
+// expire, which tells whether it marked the asset, until limit are marked. It
+// returns how many were marked and whether more results are left.
+func sweepExpired(ctx contractapi.TransactionContextInterface, selector map[string]interface{}, limit int, expire func(valueAsBytes []byte) (bool, error)) (int, bool, error) {
+	// paginated queries are not allowed in update transactions, so the sweep
+	// reads at most one more asset than it marks to tell if any remain
+	queryString, err := buildQuery(selector, "", false, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resultsIterator.Close()
+
+	marked := 0
+	for resultsIterator.HasNext() {
+		if marked == limit {
+			return marked, true, nil
+		}
+
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return 0, false, err
+		}
+
+		ok, err := expire(response.Value)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok {
+			marked++
+		}
+	}
+	return marked, false, nil
+}
+
+// SweepExpiredProducts marks as EXPIRED the products and the retailing
+// commercial products whose expiry date is before the transaction time, at
+// most maxSweepSize per transaction. Commercial products still moving through
+// an order or reserved by a consumer are left to those flows, which refuse
+// expired goods. It needs CouchDB as state database.
+func (s *ProductContract) SweepExpiredProducts(ctx contractapi.TransactionContextInterface) (*ExpirySweep, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+	date := ProductDate{
+		Status: "EXPIRED",
+		Time:   txTimeAsPtr,
+		Actor:  actor,
+	}
+
+	sweep := ExpirySweep{ProductIds: []string{}, ProductCommercialIds: []string{}}
+	var events []SupplyChainEvent
+	selector := map[string]interface{}{
+		"supplier":   map[string]interface{}{"$exists": true},
+		"expireTime": map[string]interface{}{"$gt": "", "$lt": now.Format(time.RFC3339)},
+		"status":     map[string]interface{}{"$nin": []string{"EXPIRED", "RECALLED"}},
+	}
+	marked, remaining, err := sweepExpired(ctx, selector, maxSweepSize, func(productAsBytes []byte) (bool, error) {
+		product := new(Product)
+		err := json.Unmarshal(productAsBytes, product)
+		if err != nil {
+			return false, err
+		}
+
+		// skip expiry dates recorded before they were parsed
+		if _, err := time.Parse(time.RFC3339, product.Expired); err != nil {
+			return false, nil
+		}
+
+		oldStatus := product.Status
+		product.Dates = append(product.Dates, date)
+		product.Status = "EXPIRED"
+
+		productAsBytes, err = json.Marshal(product)
+		if err != nil {
+			return false, err
+		}
+		ctx.GetStub().PutState(product.ProductId, productAsBytes)
+
+		sweep.ProductIds = append(sweep.ProductIds, product.ProductId)
+		events = append(events, newEvent(ctx, "ProductExpired", "Product", product.ProductId, oldStatus, product.Status, actor, txTimeAsPtr))
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sweep.Remaining = remaining
+
+	if !sweep.Remaining {
+		selector = map[string]interface{}{
+			"productCommercialId": map[string]interface{}{"$exists": true},
+			"expireTime":          map[string]interface{}{"$gt": "", "$lt": now.Format(time.RFC3339)},
+			"status":              "RETAILING",
+		}
+		_, sweep.Remaining, err = sweepExpired(ctx, selector, maxSweepSize-marked, func(productCommercialAsBytes []byte) (bool, error) {
+			productCommercial := new(ProductCommercial)
+			err := json.Unmarshal(productCommercialAsBytes, productCommercial)
+			if err != nil {
+				return false, err
+			}
+
+			if _, err := time.Parse(time.RFC3339, productCommercial.Expired); err != nil {
+				return false, nil
+			}
+			err = checkProductCommercialTransition(productCommercial, "EXPIRED")
+			if err != nil {
+				return false, err
+			}
+
+			oldStatus := productCommercial.Status
+			productCommercial.Dates = append(productCommercial.Dates, date)
+			productCommercial.Status = "EXPIRED"
+
+			productCommercialAsBytes, err = json.Marshal(productCommercial)
+			if err != nil {
+				return false, err
+			}
+			ctx.GetStub().PutState(productCommercial.ProductCommercialId, productCommercialAsBytes)
+
+			sweep.ProductCommercialIds = append(sweep.ProductCommercialIds, productCommercial.ProductCommercialId)
+			events = append(events, newEvent(ctx, "ProductExpired", "ProductCommercial", productCommercial.ProductCommercialId, oldStatus, productCommercial.Status, actor, txTimeAsPtr))
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sweep, nil
+}
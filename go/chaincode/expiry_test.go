@@ -0,0 +1,89 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   string
+	}{
+		{"2030-01-01", "2030-01-01T00:00:00Z", ""},
+		{"2030-01-01T08:00:00+08:00", "2030-01-01T00:00:00Z", ""},
+		{"01/01/2030", "", "invalid expiry date"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			expired, err := parseExpiry(test.value)
+			if test.err != "" {
+				expectError(t, err, test.err)
+				return
+			}
+			expectNoError(t, err)
+			if expired != test.want {
+				t.Fatalf("expected %q, got %q", test.want, expired)
+			}
+		})
+	}
+}
+
+func TestExpiredGoodsAreRefusedAndSwept(t *testing.T) {
+	n := newTestNetwork(t)
+	soon := n.manufacture("manufacturer1", n.harvest("supplier1", "ST25", "100").ProductId, "2023-06-01")
+	later := n.manufacture("manufacturer1", n.harvest("supplier1", "ST25", "100").ProductId, "2024-06-01")
+	legacy := n.harvest("supplier2", "ST24", "100")
+	// expiry dates were free text before they were parsed
+	legacy.Expired = "1 June"
+	legacyAsBytes, _ := json.Marshal(legacy)
+	n.ledger.PutState(legacy.ProductId, legacyAsBytes)
+
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: soon.ProductId, Quantity: "10"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+	n.mustOrderAction("distributor1", "FinishOrder", order.OrderId)
+
+	n.ledger.Clock = time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := n.createOrder("retailer1", ProductIdQRCodeItem{ProductId: soon.ProductId, Quantity: "10"})
+	expectError(t, err, "expired at 2023-06-01T00:00:00Z")
+	_, err = invoke(n, "retailer1", "SellProduct", func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+		return n.contract.SellProduct(ctx, ProductCommercial{ProductCommercialId: order.ProductItemList[0].Product.ProductCommercialId})
+	})
+	expectError(t, err, "expired at")
+	n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: later.ProductId, Quantity: "10"})
+
+	_, err = invoke(n, "supplier1", "SweepExpiredProducts", func(ctx contractapi.TransactionContextInterface) (*ExpirySweep, error) {
+		return n.contract.SweepExpiredProducts(ctx)
+	})
+	expectError(t, err, "is not allowed to invoke SweepExpiredProducts")
+
+	sweep := mustInvoke(n, "admin", "SweepExpiredProducts", func(ctx contractapi.TransactionContextInterface) (*ExpirySweep, error) {
+		return n.contract.SweepExpiredProducts(ctx)
+	})
+	n.expectEventTypes("ProductExpired", "ProductExpired")
+	retailed := order.ProductItemList[0].Product.ProductCommercialId
+	if len(sweep.ProductIds) != 1 || sweep.ProductIds[0] != soon.ProductId || len(sweep.ProductCommercialIds) != 1 || sweep.ProductCommercialIds[0] != retailed || sweep.Remaining {
+		t.Fatalf("unexpected sweep %+v", sweep)
+	}
+	if n.getProduct(soon.ProductId).Status != "EXPIRED" || n.getProduct(later.ProductId).Status != "MANUFACTURED" {
+		t.Fatal("only the expired product must be swept")
+	}
+	if n.getProductCommercial(retailed).Status != "EXPIRED" {
+		t.Fatal("expired retailing products must be swept")
+	}
+
+	sweep = mustInvoke(n, "admin", "SweepExpiredProducts", func(ctx contractapi.TransactionContextInterface) (*ExpirySweep, error) {
+		return n.contract.SweepExpiredProducts(ctx)
+	})
+	if len(sweep.ProductIds) != 0 || len(sweep.ProductCommercialIds) != 0 {
+		t.Fatalf("a second sweep marked %+v", sweep)
+	}
+}
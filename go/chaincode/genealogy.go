@@ -0,0 +1,318 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+	"supplychain/internal/units"
+)
+
+// ProductGenealogy is the lot graph around a product: the lots it was split
+// or merged from, up to the cultivated lots, and the lots split or merged
+// from it. Ancestors and Descendants are in breadth-first order.
+type ProductGenealogy struct {
+	Product     *Product   `json:"product"`
+	Ancestors   []*Product `json:"ancestors"`
+	Descendants []*Product `json:"descendants"`
+}
+
+// productHolder returns the actor who holds a product: whoever last moved it.
+func productHolder(product *Product) Actor {
+	if len(product.Dates) > 0 {
+		return product.Dates[len(product.Dates)-1].Actor
+	}
+	return product.Supplier
+}
+
+// lotStatuses are the statuses in which a product can be split or merged: it
+// has a harvested stock to divide and has not left the manufacturer yet.
+var lotStatuses = []string{"HARVESTED", "IMPORTED", "MANUFACTURED"}
+
+// checkLotStatus refuses to split or merge a product outside lotStatuses.
+func checkLotStatus(product *Product) error {
+	if !containsString(lotStatuses, product.Status) {
+		return fmt.Errorf("product %s cannot be split or merged while %s", product.ProductId, product.Status)
+	}
+	return nil
+}
+
+// newLot returns a lot derived from product, holding value of its unit. The
+// lot keeps the provenance, certificates and documents of product but none of
+// its price, QR code or lot links: a QR code identifies one lot only.
+func newLot(product *Product, lotId string, value units.Amount) Product {
+	lot := *product
+	lot.ProductId = lotId
+	lot.Dates = append([]ProductDate{}, product.Dates...)
+	lot.Image = append([]string{}, product.Image...)
+	lot.CertificateIds = append([]string(nil), product.CertificateIds...)
+	lot.Documents = append([]Document(nil), product.Documents...)
+	lot.Price = ""
+	lot.PriceHash = ""
+	lot.QRCode = ""
+	lot.Amount = units.FormatQuantity(value)
+	lot.Stock = &StockBalance{Unit: product.Unit, Available: value}
+	lot.ParentIds = []string{product.ProductId}
+	lot.ChildIds = nil
+	return lot
+}
+
+// SplitProduct divides the available stock of a product into new lots, one
+// per amount. The amounts are taken from the product, which keeps the rest.
+func (s *ProductContract) SplitProduct(ctx contractapi.TransactionContextInterface, productId string, amounts []string) ([]*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := getProduct(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sameActor(productHolder(product), actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	if product.Status == "RECALLED" {
+		return nil, fmt.Errorf("product %s is recalled", productId)
+	}
+	err = checkLotStatus(product)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotExpired(ctx, productId, product.Expired)
+	if err != nil {
+		return nil, err
+	}
+	if len(amounts) == 0 {
+		return nil, fmt.Errorf("no amounts to split %s into", productId)
+	}
+
+	stock, err := productStock(product)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []units.Amount
+	var total units.Amount
+	for _, amount := range amounts {
+		value, err := units.ParseQuantity(amount, product.Unit)
+		if err != nil {
+			return nil, err
+		}
+		if value <= 0 {
+			return nil, fmt.Errorf("split amounts must be positive")
+		}
+		values = append(values, value)
+		total += value
+	}
+	if total > stock.Available {
+		return nil, fmt.Errorf("product %s has %v %s available, %v requested", productId, stock.Available, stock.Unit, total)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	lotPrefix := ledger.NewAssetId(ctx.GetStub(), "Product")
+	var lots []*Product
+	var events []SupplyChainEvent
+	for i, value := range values {
+		lot := newLot(product, lotPrefix+"-"+strconv.Itoa(i), value)
+
+		lotAsBytes, _ := json.Marshal(lot)
+		ctx.GetStub().PutState(lot.ProductId, lotAsBytes)
+		err = ledger.PutIndex(ctx.GetStub(), productIndex, lot.ProductId)
+		if err != nil {
+			return nil, err
+		}
+
+		product.ChildIds = append(product.ChildIds, lot.ProductId)
+		lots = append(lots, &lot)
+		events = append(events, newEvent(ctx, "ProductLotCreated", "Product", lot.ProductId, "", lot.Status, actor, txTimeAsPtr))
+	}
+
+	stock.Available -= total
+	productAsBytes, _ := json.Marshal(product)
+	ctx.GetStub().PutState(product.ProductId, productAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "ProductSplit", "Product", product.ProductId, product.Status, product.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+// earliestExpiry returns the expiry date of the lot that expires first, so a
+// merged lot never outlives any of its sources.
+func earliestExpiry(products []*Product) string {
+	earliest := products[0].Expired
+	var earliestTime time.Time
+	for _, product := range products {
+		expireTime, err := time.Parse(time.RFC3339, product.Expired)
+		if err != nil {
+			continue
+		}
+		if earliestTime.IsZero() || expireTime.Before(earliestTime) {
+			earliest, earliestTime = product.Expired, expireTime
+		}
+	}
+	return earliest
+}
+
+// MergeProducts combines the available stock of several lots of the same
+// product code, unit and status into one new lot. The new lot carries the
+// provenance of every merged lot and the earliest expiry date among them.
+func (s *ProductContract) MergeProducts(ctx contractapi.TransactionContextInterface, productIds []string) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(productIds) < 2 {
+		return nil, fmt.Errorf("at least two products are needed to merge")
+	}
+
+	var products []*Product
+	var total units.Amount
+	for _, productId := range productIds {
+		for _, merged := range products {
+			if merged.ProductId == productId {
+				return nil, fmt.Errorf("product %s is listed twice", productId)
+			}
+		}
+
+		product, err := getProduct(ctx, productId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !sameActor(productHolder(product), actor) {
+			return nil, fmt.Errorf("Permission denied!")
+		}
+		if product.Status == "RECALLED" {
+			return nil, fmt.Errorf("product %s is recalled", productId)
+		}
+		err = checkLotStatus(product)
+		if err != nil {
+			return nil, err
+		}
+		err = checkNotExpired(ctx, productId, product.Expired)
+		if err != nil {
+			return nil, err
+		}
+		if len(products) > 0 {
+			first := products[0]
+			if product.ProductCode != first.ProductCode || product.Unit != first.Unit || product.Status != first.Status {
+				return nil, fmt.Errorf("product %s does not match the product code, unit and status of %s", productId, first.ProductId)
+			}
+		}
+
+		stock, err := productStock(product)
+		if err != nil {
+			return nil, err
+		}
+		if stock.Available <= 0 {
+			return nil, fmt.Errorf("product %s has no stock available", productId)
+		}
+
+		total += stock.Available
+		products = append(products, product)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	lot := newLot(products[0], ledger.NewAssetId(ctx.GetStub(), "Product"), total)
+	lot.ParentIds = append([]string{}, productIds...)
+	lot.CertificateIds = commonCertificates(products)
+	lot.Expired = earliestExpiry(products)
+	for _, product := range products[1:] {
+		lot.Dates = append(lot.Dates, product.Dates...)
+	}
+
+	lotAsBytes, _ := json.Marshal(lot)
+	ctx.GetStub().PutState(lot.ProductId, lotAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), productIndex, lot.ProductId)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []SupplyChainEvent{newEvent(ctx, "ProductLotCreated", "Product", lot.ProductId, "", lot.Status, actor, txTimeAsPtr)}
+	for _, product := range products {
+		product.Stock.Available = 0
+		product.ChildIds = append(product.ChildIds, lot.ProductId)
+
+		productAsBytes, _ := json.Marshal(product)
+		ctx.GetStub().PutState(product.ProductId, productAsBytes)
+		events = append(events, newEvent(ctx, "ProductMerged", "Product", product.ProductId, product.Status, product.Status, actor, txTimeAsPtr))
+	}
+
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lot, nil
+}
+
+// GetProductGenealogy walks the lot links of a product in both directions.
+func (s *ProductContract) GetProductGenealogy(ctx contractapi.TransactionContextInterface, productId string) (*ProductGenealogy, error) {
+	product, err := getProduct(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors, err := walkProducts(ctx, product, func(p *Product) []string { return p.ParentIds })
+	if err != nil {
+		return nil, err
+	}
+	descendants, err := walkProducts(ctx, product, func(p *Product) []string { return p.ChildIds })
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductGenealogy{
+		Product:     product,
+		Ancestors:   ancestors,
+		Descendants: descendants,
+	}, nil
+}
+
+// walkProducts returns, breadth first, the products reached from start by
+// following next. Each product is returned once even if reached twice.
+func walkProducts(ctx contractapi.TransactionContextInterface, start *Product, next func(*Product) []string) ([]*Product, error) {
+	visited := map[string]bool{start.ProductId: true}
+	queue := []*Product{start}
+	products := []*Product{}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, productId := range next(current) {
+			if visited[productId] {
+				continue
+			}
+			visited[productId] = true
+
+			product, err := getProduct(ctx, productId)
+			if err != nil {
+				return nil, err
+			}
+			products = append(products, product)
+			queue = append(queue, product)
+		}
+	}
+
+	return products, nil
+}
@@ -0,0 +1,155 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/units"
+)
+
+func TestSplitAndMergeLots(t *testing.T) {
+	n := newTestNetwork(t)
+	parent := n.harvest("supplier1", "ST25", "100")
+	other := n.harvest("supplier1", "ST25", "20")
+
+	_, err := invoke(n, "supplier2", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, parent.ProductId, []string{"10"})
+	})
+	expectError(t, err, "Permission denied")
+	_, err = invoke(n, "supplier1", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, parent.ProductId, []string{"60", "50"})
+	})
+	expectError(t, err, "has 100 kg available, 110 requested")
+	_, err = invoke(n, "supplier1", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, parent.ProductId, []string{"0"})
+	})
+	expectError(t, err, "must be positive")
+
+	lots := mustInvoke(n, "supplier1", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, parent.ProductId, []string{"30", "20 kg"})
+	})
+	n.expectEventTypes("ProductSplit", "ProductLotCreated", "ProductLotCreated")
+	if len(lots) != 2 || lots[0].Stock.Available != 30*units.One || lots[1].Amount != "20" || lots[1].ParentIds[0] != parent.ProductId {
+		t.Fatalf("unexpected lots %+v", lots)
+	}
+	parent = n.getProduct(parent.ProductId)
+	if parent.Stock.Available != 50*units.One || len(parent.ChildIds) != 2 {
+		t.Fatalf("unexpected parent %+v", parent)
+	}
+
+	tests := []struct {
+		name       string
+		productIds []string
+		want       string
+	}{
+		{"single product", []string{other.ProductId}, "at least two products"},
+		{"duplicate", []string{other.ProductId, other.ProductId}, "is listed twice"},
+		{"unknown product", []string{other.ProductId, "Product-missing"}, "does not exist"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(n, "supplier1", "MergeProducts", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+				return n.contract.MergeProducts(ctx, test.productIds)
+			})
+			expectError(t, err, test.want)
+		})
+	}
+
+	merged := mustInvoke(n, "supplier1", "MergeProducts", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.MergeProducts(ctx, []string{lots[1].ProductId, other.ProductId})
+	})
+	n.expectEventTypes("ProductLotCreated", "ProductMerged", "ProductMerged")
+	if merged.Stock.Available != 40*units.One || len(merged.ParentIds) != 2 {
+		t.Fatalf("unexpected merged lot %+v", merged)
+	}
+	if n.getProduct(other.ProductId).Stock.Available != 0 {
+		t.Fatal("merged products must have no stock left")
+	}
+
+	genealogy := mustInvoke(n, "consumer1", "GetProductGenealogy", func(ctx contractapi.TransactionContextInterface) (*ProductGenealogy, error) {
+		return n.contract.GetProductGenealogy(ctx, merged.ProductId)
+	})
+	if len(genealogy.Ancestors) != 3 || len(genealogy.Descendants) != 0 {
+		t.Fatalf("unexpected genealogy %+v", genealogy)
+	}
+	genealogy = mustInvoke(n, "consumer1", "GetProductGenealogy", func(ctx contractapi.TransactionContextInterface) (*ProductGenealogy, error) {
+		return n.contract.GetProductGenealogy(ctx, parent.ProductId)
+	})
+	if len(genealogy.Ancestors) != 0 || len(genealogy.Descendants) != 3 {
+		t.Fatalf("unexpected genealogy %+v", genealogy)
+	}
+}
+
+func TestLotsNeedAHarvest(t *testing.T) {
+	n := newTestNetwork(t)
+	cultivated, err := n.cultivate("supplier1", "ST25")
+	expectNoError(t, err)
+	harvested := n.harvest("supplier1", "ST25", "100")
+
+	_, err = invoke(n, "supplier1", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, cultivated.ProductId, []string{"10"})
+	})
+	expectError(t, err, "cannot be split or merged while CULTIVATED")
+	_, err = invoke(n, "supplier1", "MergeProducts", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.MergeProducts(ctx, []string{harvested.ProductId, cultivated.ProductId})
+	})
+	expectError(t, err, "cannot be split or merged while CULTIVATED")
+
+	// a lot split from a crop before the guard must not be harvested into new
+	// stock on top of its parent's
+	lot := *cultivated
+	lot.ProductId = "Product-lot"
+	lot.ParentIds = []string{cultivated.ProductId}
+	lotAsBytes, _ := json.Marshal(lot)
+	n.ledger.PutState(lot.ProductId, lotAsBytes)
+	_, err = invoke(n, "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.HarvestProduct(ctx, Product{ProductId: lot.ProductId})
+	})
+	expectError(t, err, "cannot be harvested")
+}
+
+func TestMergeKeepsEarliestExpiryAndRefusesExpiredLots(t *testing.T) {
+	n := newTestNetwork(t)
+	late := n.manufacture("manufacturer1", n.harvest("supplier1", "ST25", "10").ProductId, "2030-01-01")
+	early := n.manufacture("manufacturer1", n.harvest("supplier1", "ST25", "10").ProductId, "2029-06-01")
+	spare := n.manufacture("manufacturer1", n.harvest("supplier1", "ST25", "10").ProductId, "2030-01-01")
+
+	merged := mustInvoke(n, "manufacturer1", "MergeProducts", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.MergeProducts(ctx, []string{late.ProductId, early.ProductId})
+	})
+	if merged.Expired != "2029-06-01T00:00:00Z" {
+		t.Fatalf("a merged lot must expire with its earliest source, got %s", merged.Expired)
+	}
+
+	n.ledger.Clock = time.Date(2029, time.July, 1, 0, 0, 0, 0, time.UTC)
+	_, err := invoke(n, "manufacturer1", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, merged.ProductId, []string{"5"})
+	})
+	expectError(t, err, "expired at 2029-06-01T00:00:00Z")
+	_, err = invoke(n, "manufacturer1", "MergeProducts", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.MergeProducts(ctx, []string{spare.ProductId, merged.ProductId})
+	})
+	expectError(t, err, "expired at 2029-06-01T00:00:00Z")
+}
+
+func TestLotsDoNotCopyQRCode(t *testing.T) {
+	n := newTestNetwork(t)
+	product := mustInvoke(n, "manufacturer1", "InventoryProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.InventoryProduct(ctx, Product{ProductName: "Rice", ProductCode: "ST25", Amount: "10", Unit: "kg", Expired: "2030-01-01", QRCode: "QR-batch"})
+	})
+
+	lots := mustInvoke(n, "manufacturer1", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, product.ProductId, []string{"4", "3"})
+	})
+	for _, lot := range lots {
+		if lot.QRCode != "" {
+			t.Fatalf("lot %s must not share the QR code of its source", lot.ProductId)
+		}
+	}
+	if n.getProduct(product.ProductId).QRCode != "QR-batch" {
+		t.Fatal("the source keeps its QR code")
+	}
+}
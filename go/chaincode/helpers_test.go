@@ -0,0 +1,300 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/fabrictest"
+)
+
+// testUsers are the client identities of the test network, by enrollment ID.
+var testUsers = []struct {
+	mspId        string
+	enrollmentId string
+	role         string
+}{
+	{"SupplierMSP", "supplier1", "supplier"},
+	{"SupplierMSP", "supplier2", "supplier"},
+	{"ManufacturerMSP", "manufacturer1", "manufacturer"},
+	{"ManufacturerMSP", "manufacturer2", "manufacturer"},
+	{"DistributorMSP", "distributor1", "distributor"},
+	{"DistributorMSP", "distributor2", "distributor"},
+	{"RetailerMSP", "retailer1", "retailer"},
+	{"RetailerMSP", "retailer2", "retailer"},
+	{"ConsumerMSP", "consumer1", "consumer"},
+	{"ConsumerMSP", "regulator1", "regulator"},
+	{"ConsumerMSP", "certifier1", "certifier"},
+	{"SupplierMSP", "admin", "admin"},
+}
+
+// testNetwork runs the contract over an in-memory ledger on behalf of the
+// test users, every one of them registered.
+type testNetwork struct {
+	t          *testing.T
+	ledger     *fabrictest.Ledger
+	contract   *SmartContract
+	identities map[string]*fabrictest.Identity
+
+	// lastStub is the stub of the last transaction, committed or not.
+	lastStub *fabrictest.Stub
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	t.Helper()
+
+	n := &testNetwork{
+		t:          t,
+		ledger:     fabrictest.NewLedger(),
+		contract:   new(SmartContract),
+		identities: map[string]*fabrictest.Identity{},
+	}
+
+	for _, user := range testUsers {
+		identity, err := fabrictest.NewIdentity(user.mspId, user.enrollmentId, map[string]string{"role": user.role})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.identities[user.enrollmentId] = identity
+	}
+
+	mustInvoke(n, "admin", "InitLedger", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, n.contract.InitLedger(ctx)
+	})
+	for _, user := range testUsers {
+		profile := UserProfile{FullName: user.enrollmentId, Email: user.enrollmentId + "@example.com", PhoneNumber: "0900000000", Address: user.enrollmentId + " street"}
+		mustInvoke(n, user.enrollmentId, "RegisterUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+			return n.contract.RegisterUser(ctx, profile)
+		})
+	}
+
+	return n
+}
+
+// invokeWithTransient runs function as user the way the peer would: the
+// access policy hook, then the transaction function, then commit when both
+// succeed.
+func invokeWithTransient[T any](n *testNetwork, user string, function string, transient map[string]string, call func(ctx contractapi.TransactionContextInterface) (T, error)) (T, error) {
+	n.t.Helper()
+
+	var zero T
+	identity, ok := n.identities[user]
+	if !ok {
+		n.t.Fatalf("unknown test user %s", user)
+	}
+
+	stub := n.ledger.NewStub(identity.Creator, function)
+	for key, value := range transient {
+		stub.Transient[key] = []byte(value)
+	}
+	n.lastStub = stub
+
+	ctx, err := fabrictest.NewTransactionContext(stub)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+
+	err = EnforceAccessPolicy(ctx)
+	if err != nil {
+		return zero, err
+	}
+
+	result, err := call(ctx)
+	if err != nil {
+		return zero, err
+	}
+
+	stub.Commit()
+	return result, nil
+}
+
+func invoke[T any](n *testNetwork, user string, function string, call func(ctx contractapi.TransactionContextInterface) (T, error)) (T, error) {
+	n.t.Helper()
+	return invokeWithTransient(n, user, function, nil, call)
+}
+
+func mustInvoke[T any](n *testNetwork, user string, function string, call func(ctx contractapi.TransactionContextInterface) (T, error)) T {
+	n.t.Helper()
+	result, err := invoke(n, user, function, call)
+	if err != nil {
+		n.t.Fatalf("%s by %s: %s", function, user, err)
+	}
+	return result
+}
+
+// expectError fails the test unless err is an error containing want.
+func expectError(t *testing.T, err error, want string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected an error containing %q, got none", want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected an error containing %q, got %q", want, err)
+	}
+}
+
+func expectNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// lastEvents returns the events set by the last transaction, unpacking a
+// batch event.
+func (n *testNetwork) lastEvents() []SupplyChainEvent {
+	n.t.Helper()
+	if n.lastStub == nil || n.lastStub.EventName == "" {
+		return nil
+	}
+
+	if n.lastStub.EventName == batchEventName {
+		var batch SupplyChainBatchEvent
+		err := json.Unmarshal(n.lastStub.EventPayload, &batch)
+		if err != nil {
+			n.t.Fatal(err)
+		}
+		return batch.Events
+	}
+
+	var event SupplyChainEvent
+	err := json.Unmarshal(n.lastStub.EventPayload, &event)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return []SupplyChainEvent{event}
+}
+
+func (n *testNetwork) expectEventTypes(want ...string) {
+	n.t.Helper()
+	var got []string
+	for _, event := range n.lastEvents() {
+		got = append(got, event.EventType)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		n.t.Fatalf("expected events %v, got %v", want, got)
+	}
+}
+
+func (n *testNetwork) getProduct(productId string) *Product {
+	n.t.Helper()
+	return mustInvoke(n, "admin", "GetProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.GetProduct(ctx, productId)
+	})
+}
+
+func (n *testNetwork) getProductCommercial(productCommercialId string) *ProductCommercial {
+	n.t.Helper()
+	return mustInvoke(n, "admin", "GetProductCommercial", func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+		return n.contract.GetProductCommercial(ctx, productCommercialId)
+	})
+}
+
+func (n *testNetwork) getOrder(orderId string) *Order {
+	n.t.Helper()
+	return mustInvoke(n, "admin", "GetOrder", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.GetOrder(ctx, orderId)
+	})
+}
+
+// harvest cultivates and harvests a product of supplier, returning it
+// HARVESTED with amount of kg.
+func (n *testNetwork) harvest(supplier string, productCode string, amount string) *Product {
+	n.t.Helper()
+	product := mustInvoke(n, supplier, "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductName: "Rice " + productCode, ProductCode: productCode, Amount: amount, Unit: "kg", Image: []string{}})
+	})
+	return mustInvoke(n, supplier, "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.HarvestProduct(ctx, Product{ProductId: product.ProductId, Amount: amount})
+	})
+}
+
+// manufacture imports and manufactures a harvested product.
+func (n *testNetwork) manufacture(manufacturer string, productId string, expired string) *Product {
+	n.t.Helper()
+	mustInvoke(n, manufacturer, "ImportProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.ImportProduct(ctx, Product{ProductId: productId})
+	})
+	return mustInvoke(n, manufacturer, "ManufactureProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.ManufactureProduct(ctx, Product{ProductId: productId, QRCode: "QR-" + productId, Expired: expired})
+	})
+}
+
+// manufactured returns a MANUFACTURED product of supplier1 and manufacturer1.
+func (n *testNetwork) manufactured(amount string) *Product {
+	n.t.Helper()
+	product := n.harvest("supplier1", "ST25", amount)
+	return n.manufacture("manufacturer1", product.ProductId, "2030-01-01")
+}
+
+func (n *testNetwork) createOrder(retailer string, items ...ProductIdQRCodeItem) (*Order, error) {
+	n.t.Helper()
+	return invoke(n, retailer, "CreateOrder", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.CreateOrder(ctx, OrderForCreate{
+			ProductIdQRCodeItems: items,
+			DeliveryStatus:       DeliveryStatusCreateOrder{Address: retailer + " store"},
+			QRCode:               "QR-order",
+		})
+	})
+}
+
+func (n *testNetwork) mustCreateOrder(retailer string, items ...ProductIdQRCodeItem) *Order {
+	n.t.Helper()
+	order, err := n.createOrder(retailer, items...)
+	if err != nil {
+		n.t.Fatalf("CreateOrder by %s: %s", retailer, err)
+	}
+	return order
+}
+
+func (n *testNetwork) orderAction(user string, function string, orderId string) (*Order, error) {
+	n.t.Helper()
+	return invoke(n, user, function, func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		switch function {
+		case "ApproveOrder":
+			return n.contract.ApproveOrder(ctx, orderId)
+		case "RejectOrder":
+			return n.contract.RejectOrder(ctx, orderId)
+		case "UpdateOrder":
+			return n.contract.UpdateOrder(ctx, OrderForUpdateFinish{OrderId: orderId, DeliveryStatus: DeliveryStatusCreateOrder{Address: "hub"}})
+		case "FinishOrder":
+			return n.contract.FinishOrder(ctx, OrderForUpdateFinish{OrderId: orderId, DeliveryStatus: DeliveryStatusCreateOrder{Address: "store"}})
+		case "CancelOrder":
+			return n.contract.CancelOrder(ctx, orderId)
+		case "ConfirmOrderDelivery":
+			return n.contract.ConfirmOrderDelivery(ctx, orderId)
+		}
+		n.t.Fatalf("unknown order action %s", function)
+		return nil, nil
+	})
+}
+
+// assignDistributor has the manufacturer of an approved order assign it to
+// distributor.
+func (n *testNetwork) assignDistributor(orderId string, distributor string) (*Order, error) {
+	n.t.Helper()
+	manufacturer := n.getOrder(orderId).Manufacturer.UserId
+	return invoke(n, manufacturer, "AssignDistributor", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.AssignDistributor(ctx, orderId, "DistributorMSP", distributor)
+	})
+}
+
+func (n *testNetwork) mustAssignDistributor(orderId string, distributor string) *Order {
+	n.t.Helper()
+	order, err := n.assignDistributor(orderId, distributor)
+	if err != nil {
+		n.t.Fatalf("AssignDistributor of %s to %s: %s", orderId, distributor, err)
+	}
+	return order
+}
+
+func (n *testNetwork) mustOrderAction(user string, function string, orderId string) *Order {
+	n.t.Helper()
+	order, err := n.orderAction(user, function, orderId)
+	if err != nil {
+		n.t.Fatalf("%s by %s: %s", function, user, err)
+	}
+	return order
+}
@@ -0,0 +1,411 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// IdentityContract handles client identities, access policies and the setup
+// and upgrade of the ledger.
+type IdentityContract struct {
+	contractapi.Contract
+}
+
+// Identity is the registry record of a user, keyed by its certificate: the MSP
+// ID of its organization and its enrollment ID. It holds public profile data
+// only; Actor is what products and orders record about the user.
+type Identity struct {
+	IdentityId   string          `json:"identityId"`
+	MSPId        string          `json:"mspId"`
+	EnrollmentId string          `json:"enrollmentId"`
+	Actor        Actor           `json:"actor"`
+	UserName     string          `json:"userName" metadata:",optional"`
+	Email        string          `json:"email" metadata:",optional"`
+	Status       string          `json:"status" metadata:",optional"`
+	StatusReason string          `json:"statusReason" metadata:",optional"`
+	CreateDate   string          `json:"createDate" metadata:",optional"`
+	UpdateDate   string          `json:"updateDate" metadata:",optional"`
+	Cart         []ProductIdItem `json:"cart,omitempty" metadata:",optional"`
+	SigningKey   string          `json:"signingKey,omitempty" metadata:",optional"`
+}
+
+// PublicUser is what the registry shows of a user to other members: no
+// contact details, cart or signing key.
+type PublicUser struct {
+	MSPId        string      `json:"mspId"`
+	EnrollmentId string      `json:"enrollmentId"`
+	Actor        PublicActor `json:"actor"`
+	UserName     string      `json:"userName" metadata:",optional"`
+	Status       string      `json:"status"`
+	CreateDate   string      `json:"createDate" metadata:",optional"`
+}
+
+func publicUser(identity *Identity) *PublicUser {
+	actor := identity.Actor
+	actor.MSPId = identity.MSPId
+	return &PublicUser{
+		MSPId:        identity.MSPId,
+		EnrollmentId: identity.EnrollmentId,
+		Actor:        publicActor(actor),
+		UserName:     identity.UserName,
+		Status:       identity.Status,
+		CreateDate:   identity.CreateDate,
+	}
+}
+
+// UserProfile is the public profile a user registers. It carries no
+// credentials: users are identified by the certificate signing the proposal.
+type UserProfile struct {
+	UserCode    string `json:"userCode" metadata:",optional"`
+	UserName    string `json:"userName" metadata:",optional"`
+	FullName    string `json:"fullName"`
+	PhoneNumber string `json:"phoneNumber" metadata:",optional"`
+	Email       string `json:"email" metadata:",optional"`
+	Address     string `json:"address" metadata:",optional"`
+	Avatar      string `json:"avatar" metadata:",optional"`
+}
+
+// clientIdentity is what the contract trusts about the caller: everything in it
+// comes from the certificate that signed the proposal.
+type clientIdentity struct {
+	MSPId        string
+	EnrollmentId string
+	Role         string
+}
+
+func identityKey(mspId string, enrollmentId string) string {
+	return "Identity" + mspId + "-" + enrollmentId
+}
+
+// identityKeyRange returns the key range of the registry records of an
+// organization. It starts after the "-" separator, so that the records of an
+// MSP whose ID extends mspId, SupplierMSP2 for SupplierMSP, stay out of it.
+func identityKeyRange(mspId string) (string, string) {
+	prefix := identityKey(mspId, "")
+	return prefix, strings.TrimSuffix(prefix, "-") + "."
+}
+
+func getClientIdentity(ctx contractapi.TransactionContextInterface) (*clientIdentity, error) {
+	clientId, err := cid.New(ctx.GetStub())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identity: %s", err.Error())
+	}
+
+	mspId, err := clientId.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client MSP ID: %s", err.Error())
+	}
+
+	enrollmentId, found, err := clientId.GetAttributeValue("hf.EnrollmentID")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client enrollment ID: %s", err.Error())
+	}
+	if !found {
+		cert, err := clientId.GetX509Certificate()
+		if err != nil || cert == nil {
+			return nil, fmt.Errorf("client identity has no X.509 certificate")
+		}
+		enrollmentId = cert.Subject.CommonName
+	}
+	if enrollmentId == "" {
+		return nil, fmt.Errorf("client identity has no enrollment ID")
+	}
+
+	role, found, err := clientId.GetAttributeValue("role")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client role: %s", err.Error())
+	}
+	if !found || role == "" {
+		return nil, fmt.Errorf("client certificate has no role attribute")
+	}
+	if mspIds, pinned := roleMSPIds[role]; pinned && !containsString(mspIds, mspId) {
+		return nil, fmt.Errorf("role %s is not trusted from organization %s", role, mspId)
+	}
+
+	return &clientIdentity{MSPId: mspId, EnrollmentId: enrollmentId, Role: role}, nil
+}
+
+// getIdentity reads the registry record of a user. Records written before
+// users could be suspended are ACTIVE.
+func getIdentity(ctx contractapi.TransactionContextInterface, mspId string, enrollmentId string) (*Identity, error) {
+	identityAsBytes, err := ctx.GetStub().GetState(identityKey(mspId, enrollmentId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if identityAsBytes == nil {
+		return nil, fmt.Errorf("identity %s is not registered", enrollmentId)
+	}
+
+	identity := new(Identity)
+	_ = json.Unmarshal(identityAsBytes, identity)
+	if identity.Status == "" {
+		identity.Status = "ACTIVE"
+	}
+
+	return identity, nil
+}
+
+func putIdentity(ctx contractapi.TransactionContextInterface, identity *Identity) error {
+	identityAsBytes, _ := json.Marshal(identity)
+	err := ctx.GetStub().PutState(identity.IdentityId, identityAsBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %s", err.Error())
+	}
+	return nil
+}
+
+// getCallerIdentity reads the registry record of the caller, refusing
+// suspended users.
+func getCallerIdentity(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := getIdentity(ctx, client.MSPId, client.EnrollmentId)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Status == "SUSPENDED" {
+		return nil, fmt.Errorf("identity %s is suspended", client.EnrollmentId)
+	}
+
+	return identity, nil
+}
+
+// getActor resolves the caller to the actor stored in its registry record,
+// refusing suspended users. The user id and role always come from the
+// certificate, never from the record.
+func getActor(ctx contractapi.TransactionContextInterface) (Actor, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return Actor{}, err
+	}
+
+	identity, err := getIdentity(ctx, client.MSPId, client.EnrollmentId)
+	if err != nil {
+		return Actor{}, err
+	}
+	if identity.Status == "SUSPENDED" {
+		return Actor{}, fmt.Errorf("identity %s is suspended", client.EnrollmentId)
+	}
+
+	actor := identity.Actor
+	actor.UserId = client.EnrollmentId
+	actor.Role = client.Role
+	actor.MSPId = identity.MSPId
+	return actor, nil
+}
+
+// actorMSPId returns the organization of an actor. Actors recorded before
+// their organization was belong to the organization their role is pinned to.
+func actorMSPId(actor Actor) string {
+	if actor.MSPId == "" && len(roleMSPIds[actor.Role]) == 1 {
+		return roleMSPIds[actor.Role][0]
+	}
+	return actor.MSPId
+}
+
+// sameActor tells whether a and b are the same user. Enrollment IDs are only
+// unique within an organization, so both the user id and the MSP must match.
+func sameActor(a Actor, b Actor) bool {
+	return a.UserId != "" && a.UserId == b.UserId && actorMSPId(a) == actorMSPId(b)
+}
+
+// setProfile copies a profile into a registry record.
+func setProfile(identity *Identity, client *clientIdentity, profile UserProfile) {
+	identity.Actor = Actor{
+		UserId:      client.EnrollmentId,
+		UserCode:    profile.UserCode,
+		PhoneNumber: profile.PhoneNumber,
+		FullName:    profile.FullName,
+		Address:     profile.Address,
+		Avatar:      profile.Avatar,
+		Role:        client.Role,
+		MSPId:       client.MSPId,
+	}
+	identity.UserName = profile.UserName
+	identity.Email = profile.Email
+}
+
+// RegisterUser adds the caller to the registry with its public profile. The
+// user id and role come from the certificate.
+func (s *IdentityContract) RegisterUser(ctx contractapi.TransactionContextInterface, profile UserProfile) (*Identity, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identityAsBytes, err := ctx.GetStub().GetState(identityKey(client.MSPId, client.EnrollmentId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if identityAsBytes != nil {
+		return nil, fmt.Errorf("identity %s is already registered", client.EnrollmentId)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	identity := Identity{
+		IdentityId:   identityKey(client.MSPId, client.EnrollmentId),
+		MSPId:        client.MSPId,
+		EnrollmentId: client.EnrollmentId,
+		Status:       "ACTIVE",
+		CreateDate:   txTimeAsPtr,
+		UpdateDate:   txTimeAsPtr,
+	}
+	setProfile(&identity, client, profile)
+
+	err = putIdentity(ctx, &identity)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvents(ctx, newEvent(ctx, "UserRegistered", "User", identity.IdentityId, "", identity.Status, identity.Actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// UpdateUser replaces the public profile of the caller.
+func (s *IdentityContract) UpdateUser(ctx contractapi.TransactionContextInterface, profile UserProfile) (*Identity, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	setProfile(identity, client, profile)
+	identity.UpdateDate = txTimeAsPtr
+
+	err = putIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvents(ctx, newEvent(ctx, "UserUpdated", "User", identity.IdentityId, identity.Status, identity.Status, identity.Actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// SuspendUser refuses every further transaction of a user until it is
+// reinstated.
+func (s *IdentityContract) SuspendUser(ctx contractapi.TransactionContextInterface, mspId string, enrollmentId string, reason string) (*Identity, error) {
+	return s.setUserStatus(ctx, mspId, enrollmentId, "SUSPENDED", reason, "UserSuspended")
+}
+
+// ReinstateUser lifts the suspension of a user.
+func (s *IdentityContract) ReinstateUser(ctx contractapi.TransactionContextInterface, mspId string, enrollmentId string, reason string) (*Identity, error) {
+	return s.setUserStatus(ctx, mspId, enrollmentId, "ACTIVE", reason, "UserReinstated")
+}
+
+func (s *IdentityContract) setUserStatus(ctx contractapi.TransactionContextInterface, mspId string, enrollmentId string, status string, reason string, eventType string) (*Identity, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := getIdentity(ctx, mspId, enrollmentId)
+	if err != nil {
+		return nil, err
+	}
+	err = checkUserTransition(identity, status)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	oldStatus := identity.Status
+	identity.Status = status
+	identity.StatusReason = reason
+	identity.UpdateDate = txTimeAsPtr
+
+	err = putIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvents(ctx, newEvent(ctx, eventType, "User", identity.IdentityId, oldStatus, identity.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// GetIdentity returns the registry record of the caller.
+func (s *IdentityContract) GetIdentity(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return getIdentity(ctx, client.MSPId, client.EnrollmentId)
+}
+
+// GetUser returns the public registry record of a user.
+func (s *IdentityContract) GetUser(ctx contractapi.TransactionContextInterface, mspId string, enrollmentId string) (*PublicUser, error) {
+	identity, err := getIdentity(ctx, mspId, enrollmentId)
+	if err != nil {
+		return nil, err
+	}
+	return publicUser(identity), nil
+}
+
+// GetUsersOfOrganization returns the public registry records of the users of
+// an organization.
+func (s *IdentityContract) GetUsersOfOrganization(ctx contractapi.TransactionContextInterface, mspId string) ([]*PublicUser, error) {
+	startKey, endKey := identityKeyRange(mspId)
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	users := []*PublicUser{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		identity := new(Identity)
+		err = json.Unmarshal(response.Value, identity)
+		if err != nil {
+			return nil, err
+		}
+		if identity.Status == "" {
+			identity.Status = "ACTIVE"
+		}
+
+		users = append(users, publicUser(identity))
+	}
+
+	return users, nil
+}
@@ -0,0 +1,168 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/fabrictest"
+)
+
+func TestRegisterUserTakesIdAndRoleFromCertificate(t *testing.T) {
+	n := newTestNetwork(t)
+	newcomer, err := fabrictest.NewIdentity("SupplierMSP", "supplier3", map[string]string{"role": "supplier"})
+	expectNoError(t, err)
+	n.identities["supplier3"] = newcomer
+
+	identity := mustInvoke(n, "supplier3", "RegisterUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.RegisterUser(ctx, UserProfile{FullName: "Supplier Three", UserName: "s3", Email: "s3@example.com"})
+	})
+	n.expectEventTypes("UserRegistered")
+
+	if identity.MSPId != "SupplierMSP" || identity.EnrollmentId != "supplier3" || identity.Status != "ACTIVE" || identity.Email != "s3@example.com" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if identity.Actor.UserId != "supplier3" || identity.Actor.Role != "supplier" || identity.Actor.FullName != "Supplier Three" {
+		t.Fatalf("unexpected actor %+v", identity.Actor)
+	}
+
+	_, err = invoke(n, "supplier3", "RegisterUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.RegisterUser(ctx, UserProfile{FullName: "Someone Else"})
+	})
+	expectError(t, err, "identity supplier3 is already registered")
+
+	updated := mustInvoke(n, "supplier3", "UpdateUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.UpdateUser(ctx, UserProfile{FullName: "Supplier Three", Address: "Hue"})
+	})
+	n.expectEventTypes("UserUpdated")
+	if updated.Actor.Address != "Hue" || updated.Email != "" || updated.CreateDate != identity.CreateDate || updated.UpdateDate == identity.UpdateDate {
+		t.Fatalf("unexpected update %+v", updated)
+	}
+
+	product := mustInvoke(n, "supplier3", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "ST25", Amount: "1", Unit: "kg"})
+	})
+	if product.Supplier.Address != "Hue" || product.Supplier.UserId != "supplier3" {
+		t.Fatalf("the actor was not built from the registry: %+v", product.Supplier)
+	}
+
+	// a member of an organization whose MSP ID extends SupplierMSP
+	other := Identity{IdentityId: identityKey("SupplierMSP2", "grower"), MSPId: "SupplierMSP2", EnrollmentId: "grower", Actor: Actor{UserId: "grower", Role: "supplier"}}
+	otherAsBytes, _ := json.Marshal(other)
+	n.ledger.PutState(other.IdentityId, otherAsBytes)
+
+	users := mustInvoke(n, "consumer1", "GetUsersOfOrganization", func(ctx contractapi.TransactionContextInterface) ([]*PublicUser, error) {
+		return n.contract.GetUsersOfOrganization(ctx, "SupplierMSP")
+	})
+	// supplier1, supplier2, admin and supplier3
+	if len(users) != 4 {
+		t.Fatalf("expected 4 SupplierMSP users, got %d", len(users))
+	}
+	for _, user := range users {
+		if user.MSPId != "SupplierMSP" {
+			t.Fatalf("unexpected user %+v", user)
+		}
+	}
+
+	user := mustInvoke(n, "consumer1", "GetUser", func(ctx contractapi.TransactionContextInterface) (*PublicUser, error) {
+		return n.contract.GetUser(ctx, "SupplierMSP", "supplier3")
+	})
+	userAsBytes, _ := json.Marshal(user)
+	if user.Actor.FullName != "Supplier Three" || user.Actor.Organization != "SupplierMSP" || user.Status != "ACTIVE" || strings.Contains(string(userAsBytes), "Hue") {
+		t.Fatalf("unexpected public user %s", userAsBytes)
+	}
+}
+
+func TestSuspendedUsersAreRefused(t *testing.T) {
+	n := newTestNetwork(t)
+
+	_, err := invoke(n, "manufacturer1", "SuspendUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.SuspendUser(ctx, "SupplierMSP", "supplier1", "fraud")
+	})
+	expectError(t, err, "is not allowed to invoke SuspendUser")
+	_, err = invoke(n, "admin", "SuspendUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.SuspendUser(ctx, "SupplierMSP", "nobody", "fraud")
+	})
+	expectError(t, err, "identity nobody is not registered")
+
+	suspended := mustInvoke(n, "admin", "SuspendUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.SuspendUser(ctx, "SupplierMSP", "supplier1", "fraud")
+	})
+	n.expectEventTypes("UserSuspended")
+	if suspended.Status != "SUSPENDED" || suspended.StatusReason != "fraud" {
+		t.Fatalf("unexpected identity %+v", suspended)
+	}
+
+	_, err = invoke(n, "supplier1", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "ST25", Amount: "1", Unit: "kg"})
+	})
+	expectError(t, err, "identity supplier1 is suspended")
+	_, err = invoke(n, "supplier1", "UpdateUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.UpdateUser(ctx, UserProfile{FullName: "Still Here"})
+	})
+	expectError(t, err, "identity supplier1 is suspended")
+	_, err = invoke(n, "admin", "SuspendUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.SuspendUser(ctx, "SupplierMSP", "supplier1", "again")
+	})
+	expectError(t, err, "cannot move from SUSPENDED to SUSPENDED")
+
+	own := mustInvoke(n, "supplier1", "GetIdentity", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.GetIdentity(ctx)
+	})
+	if own.Status != "SUSPENDED" {
+		t.Fatalf("unexpected identity %+v", own)
+	}
+
+	mustInvoke(n, "admin", "ReinstateUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.ReinstateUser(ctx, "SupplierMSP", "supplier1", "cleared")
+	})
+	n.expectEventTypes("UserReinstated")
+	n.harvest("supplier1", "ST25", "1")
+}
+
+func TestUnregisteredAndRolelessIdentitiesAreRefused(t *testing.T) {
+	n := newTestNetwork(t)
+
+	stranger, err := fabrictest.NewIdentity("SupplierMSP", "stranger", map[string]string{"role": "supplier"})
+	expectNoError(t, err)
+	n.identities["stranger"] = stranger
+	roleless, err := fabrictest.NewIdentity("SupplierMSP", "roleless", nil)
+	expectNoError(t, err)
+	n.identities["roleless"] = roleless
+
+	_, err = invoke(n, "stranger", "GetIdentity", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.GetIdentity(ctx)
+	})
+	expectError(t, err, "is not registered")
+
+	_, err = invoke(n, "stranger", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "ST25", Amount: "1", Unit: "kg"})
+	})
+	expectError(t, err, "is not registered")
+
+	_, err = invoke(n, "roleless", "RegisterUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.RegisterUser(ctx, UserProfile{})
+	})
+	expectError(t, err, "is not allowed to invoke RegisterUser")
+	_, err = invoke(n, "roleless", "GetIdentity", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.GetIdentity(ctx)
+	})
+	expectError(t, err, "no role attribute")
+}
+
+func TestRegisterUserRefusesCredentials(t *testing.T) {
+	n := newTestNetwork(t)
+	chaincode, err := NewChaincode()
+	expectNoError(t, err)
+	newcomer, err := fabrictest.NewIdentity("RetailerMSP", "retailer3", map[string]string{"role": "retailer"})
+	expectNoError(t, err)
+
+	stub := n.ledger.NewStub(newcomer.Creator, "IdentityContract:RegisterUser", `{"fullName":"Retailer Three","password":"secret"}`)
+	response := chaincode.Invoke(stub)
+	if response.Status == http.StatusOK || !strings.Contains(response.Message, "password") {
+		t.Fatalf("expected the password to be refused, got %d %s", response.Status, response.Message)
+	}
+}
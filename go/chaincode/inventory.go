@@ -0,0 +1,169 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/units"
+)
+
+// Quantity is an amount of goods in a unit, in thousandths of the unit.
+type Quantity struct {
+	Value units.Amount `json:"value"`
+	Unit  string       `json:"unit"`
+}
+
+// StockBalance is the stock of a product batch. Available can still be
+// ordered, Reserved is held by approved orders and Shipped has left with an
+// order. Their sum is the harvested or inventoried amount. All three are in
+// thousandths of Unit, so the balance never drifts.
+type StockBalance struct {
+	Unit      string       `json:"unit"`
+	Available units.Amount `json:"available"`
+	Reserved  units.Amount `json:"reserved"`
+	Shipped   units.Amount `json:"shipped"`
+}
+
+// newStockBalance opens the stock of a batch with its whole amount available.
+func newStockBalance(amount string, unit string) (*StockBalance, error) {
+	value, err := units.ParseQuantity(amount, unit)
+	if err != nil {
+		return nil, err
+	}
+	return &StockBalance{Unit: unit, Available: value}, nil
+}
+
+// productStock returns the stock of a product, opening it from Amount for
+// products recorded before stock was tracked.
+func productStock(product *Product) (*StockBalance, error) {
+	if product.Stock == nil {
+		stock, err := newStockBalance(product.Amount, product.Unit)
+		if err != nil {
+			return nil, fmt.Errorf("product %s has no stock: %s", product.ProductId, err.Error())
+		}
+		product.Stock = stock
+	}
+	return product.Stock, nil
+}
+
+// itemQuantity returns the ordered quantity of an order item.
+func itemQuantity(item ProductCommercialItem) (units.Amount, error) {
+	if item.OrderedQuantity != nil {
+		return item.OrderedQuantity.Value, nil
+	}
+	return units.ParseQuantity(item.Quantity, item.Product.Unit)
+}
+
+// movedByOrder explains why a commercial product cannot move on its own: the
+// order that created it moves it with function, together with the stock the
+// order reserves and ships.
+func movedByOrder(ctx contractapi.TransactionContextInterface, productCommercialId string, function string) error {
+	productCommercial, err := getProductCommercial(ctx, productCommercialId)
+	if err != nil {
+		return err
+	}
+	order, err := productCommercialOrder(ctx, productCommercial)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return fmt.Errorf("product commercial %s is not indexed with its order, run IndexLegacyAssets", productCommercialId)
+	}
+	return fmt.Errorf("product commercial %s moves with order %s, use %s", productCommercialId, order.OrderId, function)
+}
+
+// stockLedger holds the products whose stock a transaction changes. Fabric
+// does not let a transaction read its own writes, so several items of the same
+// product must be applied to one copy and written once.
+type stockLedger struct {
+	ctx      contractapi.TransactionContextInterface
+	products map[string]*Product
+}
+
+func newStockLedger(ctx contractapi.TransactionContextInterface) *stockLedger {
+	return &stockLedger{ctx: ctx, products: map[string]*Product{}}
+}
+
+func (l *stockLedger) stock(productId string) (*StockBalance, error) {
+	product, ok := l.products[productId]
+	if !ok {
+		productAsBytes, err := l.ctx.GetStub().GetState(productId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+		}
+		if productAsBytes == nil {
+			return nil, fmt.Errorf("%s does not exist", productId)
+		}
+
+		product = new(Product)
+		_ = json.Unmarshal(productAsBytes, product)
+		l.products[productId] = product
+	}
+	return productStock(product)
+}
+
+// reserve moves quantity from available to reserved.
+func (l *stockLedger) reserve(productId string, quantity units.Amount) error {
+	stock, err := l.stock(productId)
+	if err != nil {
+		return err
+	}
+	if quantity > stock.Available {
+		return fmt.Errorf("product %s has %v %s available, %v requested", productId, stock.Available, stock.Unit, quantity)
+	}
+	stock.Available -= quantity
+	stock.Reserved += quantity
+	return nil
+}
+
+// release moves quantity from reserved back to available.
+func (l *stockLedger) release(productId string, quantity units.Amount) error {
+	stock, err := l.stock(productId)
+	if err != nil {
+		return err
+	}
+	if quantity > stock.Reserved {
+		return fmt.Errorf("product %s has only %v %s reserved", productId, stock.Reserved, stock.Unit)
+	}
+	stock.Reserved -= quantity
+	stock.Available += quantity
+	return nil
+}
+
+// ship moves quantity from reserved to shipped.
+func (l *stockLedger) ship(productId string, quantity units.Amount) error {
+	stock, err := l.stock(productId)
+	if err != nil {
+		return err
+	}
+	if quantity > stock.Reserved {
+		return fmt.Errorf("product %s has only %v %s reserved", productId, stock.Reserved, stock.Unit)
+	}
+	stock.Reserved -= quantity
+	stock.Shipped += quantity
+	return nil
+}
+
+// save writes the changed products back, in a deterministic order.
+func (l *stockLedger) save() error {
+	var productIds []string
+	for productId := range l.products {
+		productIds = append(productIds, productId)
+	}
+	sort.Strings(productIds)
+
+	for _, productId := range productIds {
+		productAsBytes, err := json.Marshal(l.products[productId])
+		if err != nil {
+			return err
+		}
+		err = l.ctx.GetStub().PutState(productId, productAsBytes)
+		if err != nil {
+			return fmt.Errorf("failed to put to world state. %s", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/units"
+)
+
+func TestStockFollowsOrders(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+
+	expectStock := func(available units.Amount, reserved units.Amount, shipped units.Amount) {
+		t.Helper()
+		stock := n.getProduct(product.ProductId).Stock
+		if stock.Available != available*units.One || stock.Reserved != reserved*units.One || stock.Shipped != shipped*units.One {
+			t.Fatalf("expected %v/%v/%v available/reserved/shipped, got %+v", available, reserved, shipped, stock)
+		}
+	}
+
+	_, err := n.createOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "60"}, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "50 kg"})
+	expectError(t, err, "has 100 kg available, 110 requested")
+
+	shipped := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "30"}, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	cancelled := n.mustCreateOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "50"})
+	expectStock(100, 0, 0)
+
+	n.mustOrderAction("manufacturer1", "ApproveOrder", shipped.OrderId)
+	expectStock(60, 40, 0)
+	n.mustOrderAction("manufacturer1", "ApproveOrder", cancelled.OrderId)
+	expectStock(10, 90, 0)
+
+	late := n.mustCreateOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	n.mustCreateOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", late.OrderId)
+	expectStock(0, 100, 0)
+
+	_, err = n.createOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	expectError(t, err, "has 0 kg available")
+
+	n.mustAssignDistributor(shipped.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", shipped.OrderId)
+	expectStock(0, 60, 40)
+
+	n.mustOrderAction("retailer2", "CancelOrder", cancelled.OrderId)
+	expectStock(50, 10, 40)
+
+	renamed := *n.getProduct(product.ProductId)
+	renamed.Unit = "t"
+	_, err = invoke(n, "manufacturer1", "UpdateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.UpdateProduct(ctx, renamed)
+	})
+	expectError(t, err, "cannot change once it has stock")
+}
+
+func TestUnitChangesOnlyBeforeStock(t *testing.T) {
+	n := newTestNetwork(t)
+	cultivated, err := n.cultivate("supplier1", "ST25")
+	expectNoError(t, err)
+	harvested := n.harvest("supplier1", "ST25", "100")
+
+	updateUnit := func(product *Product, unit string) (*Product, error) {
+		updated := *product
+		updated.Unit = unit
+		return invoke(n, "supplier1", "UpdateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.UpdateProduct(ctx, updated)
+		})
+	}
+
+	// 100 kg relabelled as 100 t would be a thousand times the harvest
+	_, err = updateUnit(harvested, "t")
+	expectError(t, err, "cannot change once it has stock")
+
+	product, err := updateUnit(cultivated, "t")
+	expectNoError(t, err)
+	if product.Unit != "t" || product.Stock != nil {
+		t.Fatalf("unexpected product %+v", product)
+	}
+	product, err = updateUnit(product, "")
+	expectNoError(t, err)
+	if product.Unit != "t" {
+		t.Fatalf("an empty unit must keep the unit, got %+v", product)
+	}
+}
+
+func TestFractionalStockIsExact(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("0.3")
+
+	for _, quantity := range []string{"0.1", "0.2"} {
+		order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: quantity})
+		n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	}
+	if stock := n.getProduct(product.ProductId).Stock; stock.Available != 0 || stock.Reserved != 300 {
+		t.Fatalf("0.1 and 0.2 of 0.3 kg must leave nothing available, got %+v", stock)
+	}
+
+	_, err := n.createOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "NaN"})
+	expectError(t, err, "invalid quantity")
+}
@@ -0,0 +1,101 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// Object types of the composite keys indexing every asset of one type. Range
+// scans over an index return exactly the assets of that type, whatever their
+// number or key format.
+const (
+	productIndex           = "Product"
+	productCommercialIndex = "ProductCommercial"
+	orderIndex             = "Order"
+)
+
+// IndexLegacyAssets adds index entries for assets created before the indexes
+// existed: counter keys up to the frozen counters and transaction ID keys.
+// Orders also get the product source entries recalls follow and the QR code
+// entries TraceByQRCode follows. It returns the number of asset entries added
+// and can be run again safely.
+func (s *IdentityContract) IndexLegacyAssets(ctx contractapi.TransactionContextInterface) (int, error) {
+	legacyTypes := []struct {
+		objectType string
+		counter    string
+	}{
+		{productIndex, "ProductCounterNO"},
+		{productCommercialIndex, "ProductCommercialCounterNO"},
+		{orderIndex, "OrderCounterNO"},
+	}
+
+	indexed := 0
+	for _, legacyType := range legacyTypes {
+		var assetIds []string
+
+		counter, _ := getCounter(ctx, legacyType.counter)
+		for i := 1; i <= counter; i++ {
+			assetIds = append(assetIds, legacyType.objectType+strconv.Itoa(i))
+		}
+
+		keyRange := ledger.AssetIdRange(legacyType.objectType)
+		resultsIterator, err := ctx.GetStub().GetStateByRange(keyRange[0], keyRange[1])
+		if err != nil {
+			return indexed, err
+		}
+		for resultsIterator.HasNext() {
+			response, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return indexed, err
+			}
+			assetIds = append(assetIds, response.Key)
+		}
+		resultsIterator.Close()
+
+		for _, assetId := range assetIds {
+			assetAsBytes, _ := ctx.GetStub().GetState(assetId)
+			if assetAsBytes == nil {
+				continue
+			}
+
+			indexKey, err := ctx.GetStub().CreateCompositeKey(legacyType.objectType, []string{assetId})
+			if err != nil {
+				return indexed, err
+			}
+			indexAsBytes, _ := ctx.GetStub().GetState(indexKey)
+			if indexAsBytes != nil {
+				continue
+			}
+
+			if legacyType.objectType == orderIndex {
+				var order Order
+				_ = json.Unmarshal(assetAsBytes, &order)
+				for _, item := range order.ProductItemList {
+					err = putProductSourceIndex(ctx, item.Product.ProductId, item.Product.ProductCommercialId, order.OrderId)
+					if err != nil {
+						return indexed, err
+					}
+					if item.Product.QRCode != "" {
+						err = putQRCodeIndex(ctx, item.Product.QRCode, item.Product.ProductCommercialId, order.OrderId)
+						if err != nil {
+							return indexed, err
+						}
+					}
+				}
+			}
+
+			err = ledger.PutIndex(ctx.GetStub(), legacyType.objectType, assetId)
+			if err != nil {
+				return indexed, err
+			}
+			indexed++
+		}
+	}
+
+	return indexed, nil
+}
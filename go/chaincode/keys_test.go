@@ -0,0 +1,63 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestIndexLegacyAssets(t *testing.T) {
+	n := newTestNetwork(t)
+
+	seed := func(key string, value interface{}) {
+		valueAsBytes, _ := json.Marshal(value)
+		n.ledger.PutState(key, valueAsBytes)
+	}
+	seed("ProductCounterNO", CounterNO{Counter: 3})
+	seed("OrderCounterNO", CounterNO{Counter: 1})
+	seed("Product1", Product{ProductId: "Product1", ProductCode: "ST25", Status: "HARVESTED", Supplier: Actor{UserId: "supplier1"}})
+	seed("Product3", Product{ProductId: "Product3", ProductCode: "ST25", Status: "HARVESTED", Supplier: Actor{UserId: "supplier1"}})
+	seed("ProductCommercial-legacy", ProductCommercial{ProductCommercialId: "ProductCommercial-legacy", Status: "MANUFACTURED"})
+	seed("Order1", Order{OrderId: "Order1", Status: "PENDING", ProductItemList: []ProductCommercialItem{
+		{Product: ProductCommercial{ProductId: "Product1", ProductCommercialId: "ProductCommercial-legacy", QRCode: "QR-legacy"}},
+	}})
+
+	indexed := mustInvoke(n, "admin", "IndexLegacyAssets", func(ctx contractapi.TransactionContextInterface) (int, error) {
+		return n.contract.IndexLegacyAssets(ctx)
+	})
+	if indexed != 4 {
+		t.Fatalf("expected 4 indexed assets, got %d", indexed)
+	}
+
+	products := mustInvoke(n, "consumer1", "GetAllProducts", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.GetAllProducts(ctx)
+	})
+	orders := mustInvoke(n, "consumer1", "GetAllOrders", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+		return n.contract.GetAllOrders(ctx, "")
+	})
+	if len(products) != 2 || len(orders) != 1 {
+		t.Fatalf("expected 2 products and 1 order, got %d and %d", len(products), len(orders))
+	}
+
+	sources := mustInvoke(n, "consumer1", "GetProduct", func(ctx contractapi.TransactionContextInterface) ([][2]string, error) {
+		return productSources(ctx, "Product1")
+	})
+	if len(sources) != 1 || sources[0] != [2]string{"ProductCommercial-legacy", "Order1"} {
+		t.Fatalf("unexpected product sources %v", sources)
+	}
+	qrSource := mustInvoke(n, "consumer1", "TraceByQRCode", func(ctx contractapi.TransactionContextInterface) ([2]string, error) {
+		source, _, err := qrCodeSource(ctx, "QR-legacy")
+		return source, err
+	})
+	if qrSource != [2]string{"ProductCommercial-legacy", "Order1"} {
+		t.Fatalf("unexpected QR code source %v", qrSource)
+	}
+
+	indexed = mustInvoke(n, "admin", "IndexLegacyAssets", func(ctx contractapi.TransactionContextInterface) (int, error) {
+		return n.contract.IndexLegacyAssets(ctx)
+	})
+	if indexed != 0 {
+		t.Fatalf("a second run indexed %d assets", indexed)
+	}
+}
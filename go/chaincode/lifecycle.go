@@ -0,0 +1,112 @@
+package chaincode
+
+import (
+	"supplychain/internal/lifecycle"
+)
+
+// productTransitions lists, for each Product status, the statuses it may move
+// to. A Product ends at MANUFACTURED; orders carry it on as ProductCommercial.
+// RECALLED, set by InitiateRecall from any status, and EXPIRED, set by
+// SweepExpiredProducts, lead nowhere.
+var productTransitions = lifecycle.Transitions{
+	"CULTIVATED":   {"HARVESTED"},
+	"HARVESTED":    {"IMPORTED"},
+	"IMPORTED":     {"MANUFACTURED"},
+	"MANUFACTURED": {},
+	"RECALLED":     {},
+	"EXPIRED":      {},
+}
+
+// productCommercialTransitions lists, for each ProductCommercial status, the
+// statuses it may move to. A ProductCommercial starts as a MANUFACTURED copy.
+// RESERVED products are held by a consumer order, which sells them or
+// releases them back to RETAILING. COLD_CHAIN_BREACH, set when shipment
+// readings exceed the threshold of the product, and EXPIRED, set on retailing
+// products by SweepExpiredProducts, lead nowhere. Products of a rejected or
+// cancelled order are CANCELLED for good.
+var productCommercialTransitions = lifecycle.Transitions{
+	"MANUFACTURED":      {"EXPORTED", "CANCELLED"},
+	"EXPORTED":          {"DISTRIBUTING", "CANCELLED"},
+	"DISTRIBUTING":      {"RETAILING", "COLD_CHAIN_BREACH"},
+	"RETAILING":         {"SOLD", "RESERVED", "EXPIRED"},
+	"RESERVED":          {"SOLD", "RETAILING"},
+	"SOLD":              {},
+	"RECALLED":          {},
+	"COLD_CHAIN_BREACH": {},
+	"EXPIRED":           {},
+	"CANCELLED":         {},
+}
+
+// orderTransitions lists, for each Order status, the statuses it may move to.
+// A shipping order whose readings exceed a threshold moves to
+// COLD_CHAIN_BREACH and is not delivered.
+var orderTransitions = lifecycle.Transitions{
+	"PENDING":           {"APPROVED", "REJECTED", "CANCELLED"},
+	"APPROVED":          {"SHIPPING", "CANCELLED"},
+	"SHIPPING":          {"SHIPPED", "COLD_CHAIN_BREACH"},
+	"SHIPPED":           {"DELIVERED"},
+	"REJECTED":          {},
+	"CANCELLED":         {},
+	"DELIVERED":         {},
+	"RECALLED":          {},
+	"COLD_CHAIN_BREACH": {},
+}
+
+// consumerOrderTransitions lists, for each ConsumerOrder status, the statuses
+// it may move to.
+var consumerOrderTransitions = lifecycle.Transitions{
+	"PLACED":    {"PICKED_UP", "RECEIVED", "CANCELLED"},
+	"PICKED_UP": {},
+	"RECEIVED":  {},
+	"CANCELLED": {},
+}
+
+// userTransitions lists, for each status of a registered user, the statuses
+// it may move to.
+var userTransitions = lifecycle.Transitions{
+	"ACTIVE":    {"SUSPENDED"},
+	"SUSPENDED": {"ACTIVE"},
+}
+
+// TransitionError is returned when an asset is asked to move to a status its
+// current status does not lead to.
+type TransitionError = lifecycle.TransitionError
+
+func checkProductTransition(product *Product, status string) error {
+	return productTransitions.Check("product", product.ProductId, product.Status, status)
+}
+
+func checkProductCommercialTransition(productCommercial *ProductCommercial, status string) error {
+	return productCommercialTransitions.Check("product commercial", productCommercial.ProductCommercialId, productCommercial.Status, status)
+}
+
+func checkOrderTransition(order *Order, status string) error {
+	return orderTransitions.Check("order", order.OrderId, order.Status, status)
+}
+
+func checkConsumerOrderTransition(consumerOrder *ConsumerOrder, status string) error {
+	return consumerOrderTransitions.Check("consumer order", consumerOrder.ConsumerOrderId, consumerOrder.Status, status)
+}
+
+func checkUserTransition(identity *Identity, status string) error {
+	return userTransitions.Check("user", identity.EnrollmentId, identity.Status, status)
+}
+
+// dateActor returns the actor who last moved an asset into status.
+func dateActor(dates []ProductDate, status string) (Actor, bool) {
+	for i := len(dates) - 1; i >= 0; i-- {
+		if dates[i].Status == status {
+			return dates[i].Actor, true
+		}
+	}
+	return Actor{}, false
+}
+
+// productManufacturer returns the manufacturer of a product: the actor who
+// manufactured it, or for inventoried products the actor who recorded it.
+func productManufacturer(product *Product) Actor {
+	if manufacturer, ok := dateActor(product.Dates, "MANUFACTURED"); ok {
+		return manufacturer
+	}
+	return product.Supplier
+}
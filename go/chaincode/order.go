@@ -0,0 +1,1139 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+	"supplychain/internal/units"
+)
+
+// OrderContract handles orders of retailers from creation to delivery.
+type OrderContract struct {
+	contractapi.Contract
+}
+
+type OrderHistory struct {
+	Record    		*Order    `json:"record"`
+	TransactionId   string    `json:"transactionId"`
+	Timestamp 		time.Time `json:"timestamp"`
+	IsDelete  		bool      `json:"isDelete"`
+}
+
+type ProductItem struct {
+	Product  Product `json:"product"`
+	Quantity string  `json:"quantity"`
+}
+
+type ProductCommercialItem struct {
+	Product  		ProductCommercial 	`json:"product"`
+	Quantity 		string  			`json:"quantity"`
+	OrderedQuantity *Quantity 			`json:"orderedQuantity,omitempty" metadata:",optional"`
+}
+
+type ProductIdItem struct {
+	ProductId  	string 	`json:"productId"`
+	Quantity 	string  `json:"quantity"`
+}
+
+type ProductIdQRCodeItem struct {
+	ProductId  	string 	`json:"productId"`
+	Quantity 	string  `json:"quantity"`
+	QRCode 		string  `json:"qrCode"`
+}
+
+type ProductItemPayload struct {
+	ProductId  	string 	`json:"productId"`
+	Quantity 	string  `json:"quantity"`
+}
+
+type DeliveryStatus struct {
+	Status       	string    	`json:"status"`
+	DeliveryDate 	string		`json:"deliveryDate"`
+	Address			string    	`json:"address"`
+	Actor 			Actor 		`json:"actor"`
+}
+
+type DeliveryStatusCreateOrder struct {
+	Address			string    	`json:"address"`
+}
+
+type Order struct {
+	OrderId 		string      	 		`json:"orderId"`
+	ProductItemList []ProductCommercialItem	`json:"productItemList" metadata:",optional"`
+	DeliveryStatuses[]DeliveryStatus 		`json:"deliveryStatuses" metadata:",optional"`
+	Checkpoints 	[]RouteCheckpoint 		`json:"checkpoints,omitempty" metadata:",optional"`
+	Signatures 		[]OrderSignature 		`json:"signatures" metadata:",optional"`
+	Documents 		[]Document 				`json:"documents,omitempty" metadata:",optional"`
+	Status          string     	 	 		`json:"status"`
+	CreateDate 		string 			 		`json:"createDate"`
+	UpdateDate 		string 			 		`json:"updateDate"`
+	FinishDate   	string      	 		`json:"finishDate"`
+	QRCode		   	string		 	 		`json:"qrCode"`
+	TermsHash	   	string		 	 		`json:"termsHash" metadata:",optional"`
+	Retailer     	Actor 			 		`json:"retailer"`
+	Manufacturer  	Actor 			 		`json:"manufacturer"`
+	Distributor  	Actor 			 		`json:"distributor"`
+}
+
+type OrderForCreate struct {
+	ProductIdQRCodeItems 	[]ProductIdQRCodeItem 		`json:"productIdQRCodeItems" metadata:",optional"`
+	DeliveryStatus 			DeliveryStatusCreateOrder 	`json:"deliveryStatus"`
+	Signatures 				[]string 					`json:"signatures" metadata:",optional"`
+	QRCode		   			string		 				`json:"qrCode"`
+}
+
+type OrderForUpdateFinish struct {
+	OrderId 		string      	 			`json:"orderId"`
+	DeliveryStatus 	DeliveryStatusCreateOrder 	`json:"deliveryStatus"`
+	Signature 		string 						`json:"signature" metadata:",optional"`
+}
+
+func getOrder(ctx contractapi.TransactionContextInterface, OrderId string) (*Order, error) {
+	orderAsBytes, err := ctx.GetStub().GetState(OrderId)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if orderAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", OrderId)
+	}
+
+	order := new(Order)
+	_ = json.Unmarshal(orderAsBytes, order)
+
+	return order, nil
+}
+
+func (s *OrderContract) GetOrder(ctx contractapi.TransactionContextInterface, orderId string) (*Order, error) {
+	return getOrder(ctx, orderId)
+}
+
+func (s *OrderContract) GetAllOrders(ctx contractapi.TransactionContextInterface, status string) ([]*Order, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orderIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	
+	var orders []*Order
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		orderAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		_ = json.Unmarshal(orderAsBytes, &order)
+
+		if status == "" || order.Status == status {
+			orders = append(orders, &order)
+		}
+	}
+
+	if len(orders) == 0 {
+		return []*Order{}, nil
+	}
+
+	return orders, nil
+}
+
+func (s *OrderContract) GetAllOrdersOfManufacturer(ctx contractapi.TransactionContextInterface, userId string, status string) ([]*Order, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orderIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var orders []*Order
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		orderAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		_ = json.Unmarshal(orderAsBytes, &order)
+
+		if order.Manufacturer.UserId == userId && (status == "" || order.Status == status) {
+			orders = append(orders, &order)
+		}
+	}
+
+	if len(orders) == 0 {
+		return []*Order{}, nil
+	}
+
+	return orders, nil
+}
+
+func (s *OrderContract) GetAllOrdersOfDistributor(ctx contractapi.TransactionContextInterface, userId string, status string) ([]*Order, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orderIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var orders []*Order
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		orderAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		_ = json.Unmarshal(orderAsBytes, &order)
+
+		if order.Distributor.UserId == userId && (status == "" || order.Status == status) {
+			orders = append(orders, &order)
+		}
+	}
+
+	if len(orders) == 0 {
+		return []*Order{}, nil
+	}
+
+	return orders, nil
+}
+
+func (s *OrderContract) GetAllOrdersOfRetailer(ctx contractapi.TransactionContextInterface, userId string, status string) ([]*Order, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orderIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var orders []*Order
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		orderAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		_ = json.Unmarshal(orderAsBytes, &order)
+
+		if order.Retailer.UserId == userId && (status == "" || order.Status == status) {
+			orders = append(orders, &order)
+		}
+	}
+
+	if len(orders) == 0 {
+		return []*Order{}, nil
+	}
+
+	return orders, nil
+}
+
+func (s *OrderContract) CreateOrder(ctx contractapi.TransactionContextInterface, orderObj OrderForCreate) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// an order without items has no manufacturer to approve it
+	if len(orderObj.ProductIdQRCodeItems) == 0 {
+		return nil, fmt.Errorf("an order needs at least one product")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	emptyActor := Actor{}
+	manufacturer := emptyActor
+
+	delivery := DeliveryStatus{
+		Status:        	"PENDING",
+		DeliveryDate:  	txTimeAsPtr,
+		Address: 		orderObj.DeliveryStatus.Address,
+		Actor: 			actor,
+	}
+	var deliveryStatuses []DeliveryStatus
+	deliveryStatuses = append(deliveryStatuses, delivery)
+
+	orderId := ledger.NewAssetId(ctx.GetStub(), "Order")
+	var productItemList []ProductCommercialItem
+	var events []SupplyChainEvent
+	requested := map[string]units.Amount{}
+	qrCodes := map[string]bool{}
+
+	for i, item := range orderObj.ProductIdQRCodeItems {
+		productAsBytes, err := ctx.GetStub().GetState(item.ProductId)
+		if err != nil || productAsBytes == nil {
+			return nil, fmt.Errorf("product not found")
+		}
+
+		product := new(Product)
+		_ = json.Unmarshal(productAsBytes, product)
+
+		if product.Status != "MANUFACTURED" {
+			return nil, fmt.Errorf("product %s is %s, only MANUFACTURED products can be ordered", item.ProductId, product.Status)
+		}
+		err = checkNotExpired(ctx, product.ProductId, product.Expired)
+		if err != nil {
+			return nil, err
+		}
+
+		itemManufacturer := productManufacturer(product)
+		if i > 0 && !sameActor(itemManufacturer, manufacturer) {
+			return nil, fmt.Errorf("products of one order must come from the same manufacturer")
+		}
+		manufacturer = itemManufacturer
+
+		quantity, err := units.ParseQuantity(item.Quantity, product.Unit)
+		if err != nil {
+			return nil, err
+		}
+		if quantity <= 0 {
+			return nil, fmt.Errorf("quantity of product %s must be positive", item.ProductId)
+		}
+		stock, err := productStock(product)
+		if err != nil {
+			return nil, err
+		}
+		requested[item.ProductId] += quantity
+		if requested[item.ProductId] > stock.Available {
+			return nil, fmt.Errorf("product %s has %v %s available, %v requested", item.ProductId, stock.Available, stock.Unit, requested[item.ProductId])
+		}
+
+		if item.QRCode != "" {
+			if qrCodes[item.QRCode] {
+				return nil, fmt.Errorf("QR code %s is given to several items", item.QRCode)
+			}
+			qrCodes[item.QRCode] = true
+			err = checkQRCodeUnused(ctx, item.QRCode)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		parsedProduct := parseProductToProductCommercial(*product)
+		parsedProduct.ProductCommercialId = ledger.NewAssetId(ctx.GetStub(), "ProductCommercial") + "-" + strconv.Itoa(i)
+		parsedProduct.QRCode = item.QRCode
+		productCommercialAsBytes, err := json.Marshal(parsedProduct)
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetStub().PutState(parsedProduct.ProductCommercialId, productCommercialAsBytes)
+		err = ledger.PutIndex(ctx.GetStub(), productCommercialIndex, parsedProduct.ProductCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		err = putProductSourceIndex(ctx, product.ProductId, parsedProduct.ProductCommercialId, orderId)
+		if err != nil {
+			return nil, err
+		}
+		if item.QRCode != "" {
+			err = putQRCodeIndex(ctx, item.QRCode, parsedProduct.ProductCommercialId, orderId)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		productItem := ProductCommercialItem{ 
+			Product: parsedProduct, 
+			Quantity: item.Quantity, 
+			OrderedQuantity: &Quantity{Value: quantity, Unit: product.Unit},
+		}
+		productItemList = append(productItemList, productItem)
+		events = append(events, newEvent(ctx, "ProductCommercialCreated", "ProductCommercial", parsedProduct.ProductCommercialId, "", parsedProduct.Status, actor, txTimeAsPtr))
+	}
+
+	var order = Order{
+		OrderId:   			orderId,
+		ProductItemList: 	productItemList,
+		Signatures:       	[]OrderSignature{},
+		DeliveryStatuses:   deliveryStatuses,
+		Status:     		"PENDING",
+		Manufacturer:		manufacturer,
+		Distributor: 		emptyActor,
+		Retailer: 			actor,
+		QRCode:				orderObj.QRCode,
+		CreateDate: 		txTimeAsPtr,
+		UpdateDate: 		"",
+		FinishDate: 		"",
+	}
+
+	order.Signatures, err = signNewOrder(ctx, &order, orderObj.Signatures, txTimeAsPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	termsHash, found, err := putPrivateOrderTerms(ctx, order.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		order.TermsHash = termsHash
+	}
+
+	orderAsBytes, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(order.OrderId, orderAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), orderIndex, order.OrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "OrderCreated", "Order", order.OrderId, "", order.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+func (s *OrderContract) ApproveOrder(ctx contractapi.TransactionContextInterface, orderId string) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orderAsBytes, err := ctx.GetStub().GetState(orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if orderAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", orderId)
+	}
+
+	order := new(Order)
+	_ = json.Unmarshal(orderAsBytes, order)
+
+	err = checkOrderTransition(order, "APPROVED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := order.Status
+
+	if !sameActor(order.Manufacturer, actor) {
+		return nil, fmt.Errorf("This manufacturer is not allowed to approve this order!")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	priceHashes, err := putPrivateItemPrices(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	// export products in order, reserving their quantities
+	var productItemList []ProductCommercialItem
+	var events []SupplyChainEvent
+	stock := newStockLedger(ctx)
+	for _, item := range order.ProductItemList {
+		// check and write the stored commercial product, not the order's copy
+		productCommercial, err := getProductCommercial(ctx, item.Product.ProductCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		item.Product = *productCommercial
+		err = checkProductCommercialTransition(&item.Product, "EXPORTED")
+		if err != nil {
+			return nil, err
+		}
+		err = checkRequiredCertificates(ctx, item.Product.ProductCommercialId, item.Product.ProductCode, item.Product.CertificateIds)
+		if err != nil {
+			return nil, err
+		}
+
+		quantity, err := itemQuantity(item)
+		if err != nil {
+			return nil, err
+		}
+		err = stock.reserve(item.Product.ProductId, quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		oldItemStatus := item.Product.Status
+		date := ProductDate{
+			Status: "EXPORTED",
+			Time: txTimeAsPtr,
+			Actor: actor,
+		}
+		dates := append(item.Product.Dates, date)
+
+		// update product in chaincode
+		item.Product.Dates = dates
+		item.Product.Status = "EXPORTED"
+		if priceHash, ok := priceHashes[item.Product.ProductCommercialId]; ok {
+			item.Product.Price = ""
+			item.Product.PriceHash = priceHash
+		}
+
+		updatedProductAsBytes, err := json.Marshal(item.Product)
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetStub().PutState(item.Product.ProductCommercialId, updatedProductAsBytes)
+
+		// update updated products into order
+		productItem := ProductCommercialItem{
+			Product: item.Product,
+			Quantity: item.Quantity,
+			OrderedQuantity: item.OrderedQuantity,
+		}
+		productItemList = append(productItemList, productItem)
+		events = append(events, newEvent(ctx, "ProductExported", "ProductCommercial", item.Product.ProductCommercialId, oldItemStatus, item.Product.Status, actor, txTimeAsPtr))
+	}
+
+	err = stock.save()
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := DeliveryStatus{
+		Status:        	"APPROVED",
+		DeliveryDate:  	txTimeAsPtr,
+		Address: 		actor.Address,
+		Actor: 			actor,
+	}
+	deliveryStatuses := append(order.DeliveryStatuses, delivery)
+
+	order.ProductItemList = productItemList
+	order.DeliveryStatuses = deliveryStatuses
+	order.Manufacturer = actor
+	order.UpdateDate = txTimeAsPtr
+	order.Status = "APPROVED"
+
+	// the manufacturer may sign the approved order, passing the signature in
+	// the transient map
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient map: %s", err.Error())
+	}
+	if signatureAsBytes, ok := transientMap[transientSignature]; ok {
+		signature, err := signOrder(ctx, order, "APPROVED", string(signatureAsBytes), txTimeAsPtr)
+		if err != nil {
+			return nil, err
+		}
+		order.Signatures = append(order.Signatures, *signature)
+	}
+
+	updateOrderAsBytes, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(order.OrderId, updateOrderAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "OrderApproved", "Order", order.OrderId, oldStatus, order.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// cancelOrderItems moves the commercial products of an order that will not
+// ship to CANCELLED, so they cannot move on without it.
+func cancelOrderItems(ctx contractapi.TransactionContextInterface, order *Order, actor Actor, txTime string) ([]ProductCommercialItem, []SupplyChainEvent, error) {
+	var productItemList []ProductCommercialItem
+	var events []SupplyChainEvent
+	for _, item := range order.ProductItemList {
+		productCommercial, err := getProductCommercial(ctx, item.Product.ProductCommercialId)
+		if err != nil {
+			return nil, nil, err
+		}
+		item.Product = *productCommercial
+		err = checkProductCommercialTransition(&item.Product, "CANCELLED")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		oldItemStatus := item.Product.Status
+		item.Product.Dates = append(item.Product.Dates, ProductDate{
+			Status: "CANCELLED",
+			Time:   txTime,
+			Actor:  actor,
+		})
+		item.Product.Status = "CANCELLED"
+
+		updatedProductAsBytes, err := json.Marshal(item.Product)
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx.GetStub().PutState(item.Product.ProductCommercialId, updatedProductAsBytes)
+
+		productItemList = append(productItemList, item)
+		events = append(events, newEvent(ctx, "ProductCancelled", "ProductCommercial", item.Product.ProductCommercialId, oldItemStatus, item.Product.Status, actor, txTime))
+	}
+	return productItemList, events, nil
+}
+
+func (s *OrderContract) RejectOrder(ctx contractapi.TransactionContextInterface, orderId string) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orderAsBytes, err := ctx.GetStub().GetState(orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if orderAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", orderId)
+	}
+
+	order := new(Order)
+	_ = json.Unmarshal(orderAsBytes, order)
+
+	err = checkOrderTransition(order, "REJECTED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := order.Status
+
+	if !sameActor(order.Manufacturer, actor) {
+		return nil, fmt.Errorf("This manufacturer is not allowed to reject this order!")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	delivery := DeliveryStatus{
+		Status:        	"REJECTED",
+		DeliveryDate:  	txTimeAsPtr,
+		Address: 		actor.Address,
+		Actor: 			actor,
+	}
+	deliveryStatuses := append(order.DeliveryStatuses, delivery)
+
+	productItemList, events, err := cancelOrderItems(ctx, order, actor, txTimeAsPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	order.ProductItemList = productItemList
+	order.DeliveryStatuses = deliveryStatuses
+	order.Manufacturer = actor
+	order.UpdateDate = txTimeAsPtr
+	order.Status = "REJECTED"
+
+	updateOrderAsBytes, _ := json.Marshal(order)
+	ctx.GetStub().PutState(order.OrderId, updateOrderAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "OrderRejected", "Order", order.OrderId, oldStatus, order.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// AssignDistributor lets the manufacturer of an approved order choose the
+// registered distributor who ships it. Only that distributor can then update
+// and finish the order.
+func (s *OrderContract) AssignDistributor(ctx contractapi.TransactionContextInterface, orderId string, mspId string, distributorId string) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != "APPROVED" {
+		return nil, fmt.Errorf("order %s is %s, a distributor is assigned to APPROVED orders", orderId, order.Status)
+	}
+	if !sameActor(order.Manufacturer, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+
+	identity, err := getIdentity(ctx, mspId, distributorId)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Actor.Role != "distributor" || identity.Status == "SUSPENDED" {
+		return nil, fmt.Errorf("%s is not an active distributor", distributorId)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	distributor := identity.Actor
+	distributor.UserId = identity.EnrollmentId
+	distributor.MSPId = identity.MSPId
+	order.Distributor = distributor
+	order.UpdateDate = txTimeAsPtr
+
+	orderAsBytes, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(order.OrderId, orderAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "OrderDistributorAssigned", "Order", order.OrderId, order.Status, order.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (s *OrderContract) UpdateOrder(ctx contractapi.TransactionContextInterface, orderObj OrderForUpdateFinish) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	orderBytes, _ := ctx.GetStub().GetState(orderObj.OrderId)
+	if orderBytes == nil {
+		return nil, fmt.Errorf("cannot find this order")
+	}
+
+	order := new(Order)
+	_ = json.Unmarshal(orderBytes, order)
+
+	err = checkOrderTransition(order, "SHIPPING")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := order.Status
+
+	if !sameActor(order.Distributor, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+
+	signatures := order.Signatures
+	if orderObj.Signature != "" {
+		signature, err := signOrder(ctx, order, "SHIPPING", orderObj.Signature, txTimeAsPtr)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, *signature)
+	}
+
+	// distribute products in order, shipping their reserved quantities
+	var productItemList []ProductCommercialItem
+	var events []SupplyChainEvent
+	stock := newStockLedger(ctx)
+	for _, item := range order.ProductItemList {
+		productCommercial, err := getProductCommercial(ctx, item.Product.ProductCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		item.Product = *productCommercial
+		err = checkProductCommercialTransition(&item.Product, "DISTRIBUTING")
+		if err != nil {
+			return nil, err
+		}
+
+		quantity, err := itemQuantity(item)
+		if err != nil {
+			return nil, err
+		}
+		err = stock.ship(item.Product.ProductId, quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		oldItemStatus := item.Product.Status
+		date := ProductDate{
+			Status: "DISTRIBUTING",
+			Time: txTimeAsPtr,
+			Actor: actor,
+		}
+		dates := append(item.Product.Dates, date)
+
+		// update product in chaincode
+		item.Product.Dates = dates
+		item.Product.Status = "DISTRIBUTING"
+
+		updatedProductAsBytes, err := json.Marshal(item.Product)
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetStub().PutState(item.Product.ProductCommercialId, updatedProductAsBytes)
+
+		// update updated products into order
+		productItem := ProductCommercialItem{
+			Product: item.Product,
+			Quantity: item.Quantity,
+			OrderedQuantity: item.OrderedQuantity,
+		}
+		productItemList = append(productItemList, productItem)
+		events = append(events, newEvent(ctx, "ProductDistributing", "ProductCommercial", item.Product.ProductCommercialId, oldItemStatus, item.Product.Status, actor, txTimeAsPtr))
+	}
+
+	err = stock.save()
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := DeliveryStatus{
+		Status:        	"SHIPPING",
+		DeliveryDate:  	txTimeAsPtr,
+		Address: 		orderObj.DeliveryStatus.Address,
+		Actor: 			actor,
+	}
+	deliveryStatuses := append(order.DeliveryStatuses, delivery)
+
+	order.Signatures = signatures
+	order.ProductItemList = productItemList
+	order.DeliveryStatuses = deliveryStatuses
+	order.Distributor = actor
+	order.UpdateDate = txTimeAsPtr
+	order.Status = "SHIPPING"
+
+	updateOrderAsBytes, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(order.OrderId, updateOrderAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "OrderShipping", "Order", order.OrderId, oldStatus, order.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (s *OrderContract) FinishOrder(ctx contractapi.TransactionContextInterface, orderObj OrderForUpdateFinish) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	orderBytes, _ := ctx.GetStub().GetState(orderObj.OrderId)
+	if orderBytes == nil {
+		return nil, fmt.Errorf("cannot find this order")
+	}
+
+	order := new(Order)
+	_ = json.Unmarshal(orderBytes, order)
+
+	err = checkOrderTransition(order, "SHIPPED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := order.Status
+
+	if !sameActor(order.Distributor, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+
+	signatures := order.Signatures
+	if orderObj.Signature != "" {
+		signature, err := signOrder(ctx, order, "SHIPPED", orderObj.Signature, txTimeAsPtr)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, *signature)
+	}
+
+	// retailing products in order
+	var productItemList []ProductCommercialItem
+	var events []SupplyChainEvent
+	for _, item := range order.ProductItemList {
+		productCommercial, err := getProductCommercial(ctx, item.Product.ProductCommercialId)
+		if err != nil {
+			return nil, err
+		}
+		item.Product = *productCommercial
+		err = checkProductCommercialTransition(&item.Product, "RETAILING")
+		if err != nil {
+			return nil, err
+		}
+
+		oldItemStatus := item.Product.Status
+		date := ProductDate{
+			Status: "RETAILING",
+			Time: txTimeAsPtr,
+			Actor: actor,
+		}
+		dates := append(item.Product.Dates, date)
+
+		// update product in chaincode
+		item.Product.Dates = dates
+		item.Product.Status = "RETAILING"
+
+		updatedProductAsBytes, err := json.Marshal(item.Product)
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetStub().PutState(item.Product.ProductCommercialId, updatedProductAsBytes)
+
+		// update updated products into order
+		productItem := ProductCommercialItem{
+			Product: item.Product,
+			Quantity: item.Quantity,
+			OrderedQuantity: item.OrderedQuantity,
+		}
+		productItemList = append(productItemList, productItem)
+		events = append(events, newEvent(ctx, "ProductRetailing", "ProductCommercial", item.Product.ProductCommercialId, oldItemStatus, item.Product.Status, actor, txTimeAsPtr))
+	}
+	
+	delivery := DeliveryStatus{
+		Status:        	"SHIPPED",
+		DeliveryDate:  	txTimeAsPtr,
+		Address: 		orderObj.DeliveryStatus.Address,
+		Actor: 			actor,
+	}
+	deliveryStatuses := append(order.DeliveryStatuses, delivery)
+
+	order.Status = "SHIPPED"
+	order.FinishDate = txTimeAsPtr
+	order.ProductItemList = productItemList
+	order.DeliveryStatuses = deliveryStatuses
+	order.Signatures = signatures
+
+	finishOrderAsBytes, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(order.OrderId, finishOrderAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "OrderShipped", "Order", order.OrderId, oldStatus, order.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (s *OrderContract) CancelOrder(ctx contractapi.TransactionContextInterface, orderId string) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orderAsBytes, err := ctx.GetStub().GetState(orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if orderAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", orderId)
+	}
+
+	order := new(Order)
+	_ = json.Unmarshal(orderAsBytes, order)
+
+	err = checkOrderTransition(order, "CANCELLED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := order.Status
+
+	if !sameActor(order.Retailer, actor) {
+		return nil, fmt.Errorf("This retailer is not allowed to cancel this order!")
+	}
+
+	// an approved order holds reserved stock, give it back
+	if oldStatus == "APPROVED" {
+		stock := newStockLedger(ctx)
+		for _, item := range order.ProductItemList {
+			quantity, err := itemQuantity(item)
+			if err != nil {
+				return nil, err
+			}
+			err = stock.release(item.Product.ProductId, quantity)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = stock.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	delivery := DeliveryStatus{
+		Status:        	"CANCELLED",
+		DeliveryDate:  	txTimeAsPtr,
+		Address: 		actor.Address,
+		Actor: 			actor,
+	}
+	deliveryStatuses := append(order.DeliveryStatuses, delivery)
+
+	productItemList, events, err := cancelOrderItems(ctx, order, actor, txTimeAsPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	order.ProductItemList = productItemList
+	order.DeliveryStatuses = deliveryStatuses
+	order.UpdateDate = txTimeAsPtr
+	order.Status = "CANCELLED"
+
+	updateOrderAsBytes, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(order.OrderId, updateOrderAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "OrderCancelled", "Order", order.OrderId, oldStatus, order.Status, actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (s *OrderContract) ConfirmOrderDelivery(ctx contractapi.TransactionContextInterface, orderId string) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orderAsBytes, err := ctx.GetStub().GetState(orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if orderAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", orderId)
+	}
+
+	order := new(Order)
+	_ = json.Unmarshal(orderAsBytes, order)
+
+	err = checkOrderTransition(order, "DELIVERED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := order.Status
+
+	if !sameActor(order.Retailer, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	delivery := DeliveryStatus{
+		Status:        	"DELIVERED",
+		DeliveryDate:  	txTimeAsPtr,
+		Address: 		actor.Address,
+		Actor: 			actor,
+	}
+	deliveryStatuses := append(order.DeliveryStatuses, delivery)
+
+	order.DeliveryStatuses = deliveryStatuses
+	order.UpdateDate = txTimeAsPtr
+	order.Status = "DELIVERED"
+
+	updateOrderAsBytes, _ := json.Marshal(order)
+	ctx.GetStub().PutState(order.OrderId, updateOrderAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "OrderDelivered", "Order", order.OrderId, oldStatus, order.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (s *OrderContract) GetOrderTransactionHistory(ctx contractapi.TransactionContextInterface, orderId string) ([]OrderHistory, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(orderId)
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
+
+	defer resultsIterator.Close()
+	var histories []OrderHistory
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		if len(response.Value) > 0 {
+			err = json.Unmarshal(response.Value, &order)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			order = Order{
+				OrderId: orderId,
+			}
+		}
+
+		timestamp, err := ptypes.Timestamp(response.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		orderHistory := OrderHistory{
+			Record: &order,
+			TransactionId: response.TxId,
+			Timestamp: timestamp,
+			IsDelete: response.IsDelete,
+		}
+		histories = append(histories, orderHistory)
+	}
+
+	if len(histories) == 0 {
+		return []OrderHistory{}, nil
+	}
+
+	return histories, nil
+}
@@ -0,0 +1,174 @@
+package chaincode
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// ProductPage is one page of products. Bookmark is passed back to fetch the
+// next page and is empty once the last page has been returned.
+type ProductPage struct {
+	Records             []*Product `json:"records"`
+	FetchedRecordsCount int32      `json:"fetchedRecordsCount"`
+	Bookmark            string     `json:"bookmark"`
+}
+
+type ProductCommercialPage struct {
+	Records             []*ProductCommercial `json:"records"`
+	FetchedRecordsCount int32                `json:"fetchedRecordsCount"`
+	Bookmark            string               `json:"bookmark"`
+}
+
+type OrderPage struct {
+	Records             []*Order `json:"records"`
+	FetchedRecordsCount int32    `json:"fetchedRecordsCount"`
+	Bookmark            string   `json:"bookmark"`
+}
+
+func (s *ProductContract) GetProductsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ProductPage, error) {
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(productIndex, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	products := []*Product{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		productAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		err = json.Unmarshal(productAsBytes, &product)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, &product)
+	}
+
+	return &ProductPage{
+		Records:             products,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+func (s *ProductContract) GetProductsCommercialWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ProductCommercialPage, error) {
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(productCommercialIndex, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	productCommercials := []*ProductCommercial{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		productCommercialAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var productCommercial ProductCommercial
+		err = json.Unmarshal(productCommercialAsBytes, &productCommercial)
+		if err != nil {
+			return nil, err
+		}
+
+		productCommercials = append(productCommercials, &productCommercial)
+	}
+
+	return &ProductCommercialPage{
+		Records:             productCommercials,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// GetOrdersWithPagination pages through all orders, or through the orders in
+// the given status when status is not empty.
+func (s *OrderContract) GetOrdersWithPagination(ctx contractapi.TransactionContextInterface, status string, pageSize int32, bookmark string) (*OrderPage, error) {
+	if status != "" {
+		return getOrdersByQueryWithPagination(ctx, "", "", status, pageSize, bookmark)
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(orderIndex, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	orders := []*Order{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		orderAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		err = json.Unmarshal(orderAsBytes, &order)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, &order)
+	}
+
+	return &OrderPage{
+		Records:             orders,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+func (s *OrderContract) GetOrdersOfManufacturerWithPagination(ctx contractapi.TransactionContextInterface, userId string, status string, pageSize int32, bookmark string) (*OrderPage, error) {
+	return getOrdersByQueryWithPagination(ctx, "manufacturer", userId, status, pageSize, bookmark)
+}
+
+func (s *OrderContract) GetOrdersOfDistributorWithPagination(ctx contractapi.TransactionContextInterface, userId string, status string, pageSize int32, bookmark string) (*OrderPage, error) {
+	return getOrdersByQueryWithPagination(ctx, "distributor", userId, status, pageSize, bookmark)
+}
+
+func (s *OrderContract) GetOrdersOfRetailerWithPagination(ctx contractapi.TransactionContextInterface, userId string, status string, pageSize int32, bookmark string) (*OrderPage, error) {
+	return getOrdersByQueryWithPagination(ctx, "retailer", userId, status, pageSize, bookmark)
+}
+
+// getOrdersByQueryWithPagination runs a CouchDB query over orders, optionally
+// restricted to the orders of one party (actorField is the JSON name of the
+// Actor field on Order) and to one status.
+func getOrdersByQueryWithPagination(ctx contractapi.TransactionContextInterface, actorField string, userId string, status string, pageSize int32, bookmark string) (*OrderPage, error) {
+	selector := map[string]interface{}{
+		"orderId": map[string]interface{}{"$exists": true},
+	}
+	if actorField != "" {
+		selector[actorField+".userId"] = userId
+	}
+	if status != "" {
+		selector["status"] = status
+	}
+
+	queryString, err := buildQuery(selector, "", false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeOrderQuery(ctx, queryString, pageSize, bookmark)
+}
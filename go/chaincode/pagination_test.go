@@ -0,0 +1,75 @@
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestPagination(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("1000")
+	for i := 0; i < 4; i++ {
+		n.harvest("supplier2", "ST24", "10")
+	}
+	for _, retailer := range []string{"retailer1", "retailer1", "retailer2"} {
+		n.mustCreateOrder(retailer, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	}
+
+	seen := map[string]bool{}
+	bookmark := ""
+	pages := 0
+	for {
+		page := mustInvoke(n, "consumer1", "GetProductsWithPagination", func(ctx contractapi.TransactionContextInterface) (*ProductPage, error) {
+			return n.contract.GetProductsWithPagination(ctx, 2, bookmark)
+		})
+		pages++
+		for _, record := range page.Records {
+			seen[record.ProductId] = true
+		}
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	if pages != 3 || len(seen) != 5 {
+		t.Fatalf("expected 5 products over 3 pages, got %d over %d", len(seen), pages)
+	}
+
+	commercialPage := mustInvoke(n, "consumer1", "GetProductsCommercialWithPagination", func(ctx contractapi.TransactionContextInterface) (*ProductCommercialPage, error) {
+		return n.contract.GetProductsCommercialWithPagination(ctx, 10, "")
+	})
+	if commercialPage.FetchedRecordsCount != 3 || commercialPage.Bookmark != "" {
+		t.Fatalf("unexpected page %+v", commercialPage)
+	}
+
+	tests := []struct {
+		name string
+		call func(ctx contractapi.TransactionContextInterface) (*OrderPage, error)
+		want int32
+	}{
+		{"all orders", func(ctx contractapi.TransactionContextInterface) (*OrderPage, error) {
+			return n.contract.GetOrdersWithPagination(ctx, "", 10, "")
+		}, 3},
+		{"first page", func(ctx contractapi.TransactionContextInterface) (*OrderPage, error) {
+			return n.contract.GetOrdersWithPagination(ctx, "PENDING", 2, "")
+		}, 2},
+		{"of manufacturer", func(ctx contractapi.TransactionContextInterface) (*OrderPage, error) {
+			return n.contract.GetOrdersOfManufacturerWithPagination(ctx, "manufacturer1", "", 10, "")
+		}, 3},
+		{"of retailer", func(ctx contractapi.TransactionContextInterface) (*OrderPage, error) {
+			return n.contract.GetOrdersOfRetailerWithPagination(ctx, "retailer1", "PENDING", 10, "")
+		}, 2},
+		{"of distributor", func(ctx contractapi.TransactionContextInterface) (*OrderPage, error) {
+			return n.contract.GetOrdersOfDistributorWithPagination(ctx, "distributor1", "", 10, "")
+		}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := mustInvoke(n, "consumer1", "GetOrdersWithPagination", test.call)
+			if int32(len(page.Records)) != test.want {
+				t.Fatalf("expected %d orders, got %d", test.want, len(page.Records))
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AccessPolicy lists who may invoke a contract function. The caller must belong
+// to one of MSPIds and carry, for every attribute named in Attributes, one of
+// the listed values. An empty MSPIds or Attributes places no restriction.
+type AccessPolicy struct {
+	Function   string              `json:"function"`
+	MSPIds     []string            `json:"mspIds" metadata:",optional"`
+	Attributes map[string][]string `json:"attributes" metadata:",optional"`
+}
+
+// roleMSPIds pins every role of the network to the organizations trusted to
+// enrol it. Any organization's CA can put a role attribute in a certificate,
+// so a role is refused from other MSPs.
+var roleMSPIds = map[string][]string{
+	"supplier":     {"SupplierMSP"},
+	"manufacturer": {"ManufacturerMSP"},
+	"distributor":  {"DistributorMSP"},
+	"retailer":     {"RetailerMSP"},
+	"consumer":     {"ConsumerMSP"},
+	"admin":        {"SupplierMSP"},
+	"regulator":    {"ConsumerMSP"},
+	"certifier":    {"ConsumerMSP"},
+}
+
+// registeredRoles are the roles a user of the registry can hold.
+var registeredRoles = []string{"supplier", "manufacturer", "distributor", "retailer", "consumer", "regulator", "certifier", "admin"}
+
+// rolePolicy lets the given roles invoke function, from the organizations
+// those roles are pinned to.
+func rolePolicy(function string, roles ...string) AccessPolicy {
+	policy := AccessPolicy{
+		Function:   function,
+		Attributes: map[string][]string{"role": roles},
+	}
+	for _, role := range roles {
+		for _, mspId := range roleMSPIds[role] {
+			if !containsString(policy.MSPIds, mspId) {
+				policy.MSPIds = append(policy.MSPIds, mspId)
+			}
+		}
+	}
+	return policy
+}
+
+// defaultAccessPolicies is used for any function that has no policy stored on
+// the ledger. Functions absent from both only read the ledger and are open to
+// every channel member.
+var defaultAccessPolicies = map[string]AccessPolicy{
+	"CultivateProduct":          rolePolicy("CultivateProduct", "supplier"),
+	"HarvestProduct":            rolePolicy("HarvestProduct", "supplier"),
+	"UpdateProduct":             rolePolicy("UpdateProduct", "supplier", "manufacturer"),
+	"InventoryProduct":          rolePolicy("InventoryProduct", "manufacturer"),
+	"ImportProduct":             rolePolicy("ImportProduct", "manufacturer"),
+	"ManufactureProduct":        rolePolicy("ManufactureProduct", "manufacturer"),
+	"SplitProduct":              rolePolicy("SplitProduct", "supplier", "manufacturer"),
+	"MergeProducts":             rolePolicy("MergeProducts", "supplier", "manufacturer"),
+	"ExportProduct":             rolePolicy("ExportProduct", "manufacturer"),
+	"DistributeProduct":         rolePolicy("DistributeProduct", "distributor"),
+	"ImportRetailerProduct":     rolePolicy("ImportRetailerProduct", "retailer"),
+	"SellProduct":               rolePolicy("SellProduct", "retailer"),
+	"CreateOrder":               rolePolicy("CreateOrder", "retailer"),
+	"ApproveOrder":              rolePolicy("ApproveOrder", "manufacturer"),
+	"RejectOrder":               rolePolicy("RejectOrder", "manufacturer"),
+	"AssignDistributor":         rolePolicy("AssignDistributor", "manufacturer"),
+	"UpdateOrder":               rolePolicy("UpdateOrder", "distributor"),
+	"FinishOrder":               rolePolicy("FinishOrder", "distributor"),
+	"CancelOrder":               rolePolicy("CancelOrder", "retailer"),
+	"AddToCart":                 rolePolicy("AddToCart", "retailer"),
+	"UpdateCartItem":            rolePolicy("UpdateCartItem", "retailer"),
+	"RemoveFromCart":            rolePolicy("RemoveFromCart", "retailer"),
+	"CheckoutCart":              rolePolicy("CheckoutCart", "retailer"),
+	"RegisterDevice":            rolePolicy("RegisterDevice", "distributor"),
+	"AddOrderCheckpoint":        rolePolicy("AddOrderCheckpoint", "distributor"),
+	"RecordShipmentReading":     rolePolicy("RecordShipmentReading", "distributor"),
+	"SetColdChainThreshold":     rolePolicy("SetColdChainThreshold", "regulator", "admin"),
+	"ConfirmOrderDelivery":      rolePolicy("ConfirmOrderDelivery", "retailer"),
+	"PlaceConsumerOrder":        rolePolicy("PlaceConsumerOrder", "consumer"),
+	"ConfirmPickup":             rolePolicy("ConfirmPickup", "retailer"),
+	"ConfirmReceipt":            rolePolicy("ConfirmReceipt", "consumer"),
+	"CancelConsumerOrder":       rolePolicy("CancelConsumerOrder", "consumer", "retailer"),
+	"InitiateRecall":            rolePolicy("InitiateRecall", "supplier", "manufacturer", "regulator"),
+	"IssueCertificate":          rolePolicy("IssueCertificate", "certifier", "regulator"),
+	"RevokeCertificate":         rolePolicy("RevokeCertificate", "certifier", "regulator"),
+	"LinkCertificate":           rolePolicy("LinkCertificate", "supplier", "manufacturer"),
+	"AttachDocument":            rolePolicy("AttachDocument", "supplier", "manufacturer", "distributor", "retailer", "certifier", "regulator"),
+	"RegisterSigningKey":        rolePolicy("RegisterSigningKey", "retailer", "manufacturer", "distributor"),
+	"RegisterUser":              rolePolicy("RegisterUser", registeredRoles...),
+	"UpdateUser":                rolePolicy("UpdateUser", registeredRoles...),
+	"SetCertificateRequirement": rolePolicy("SetCertificateRequirement", "regulator", "admin"),
+	"SweepExpiredProducts":      rolePolicy("SweepExpiredProducts", "admin"),
+	"IndexLegacyAssets":         rolePolicy("IndexLegacyAssets", "admin"),
+	"SuspendUser":               rolePolicy("SuspendUser", "admin"),
+	"ReinstateUser":             rolePolicy("ReinstateUser", "admin"),
+	"InitLedger":                rolePolicy("InitLedger", "admin"),
+	"SetAccessPolicy":           rolePolicy("SetAccessPolicy", "admin"),
+	"DeleteAccessPolicy":        rolePolicy("DeleteAccessPolicy", "admin"),
+}
+
+func accessPolicyKey(function string) string {
+	return "AccessPolicy" + function
+}
+
+func getAccessPolicy(ctx contractapi.TransactionContextInterface, function string) (*AccessPolicy, error) {
+	policyAsBytes, err := ctx.GetStub().GetState(accessPolicyKey(function))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+
+	if policyAsBytes == nil {
+		policy, ok := defaultAccessPolicies[function]
+		if !ok {
+			return nil, nil
+		}
+		return &policy, nil
+	}
+
+	policy := new(AccessPolicy)
+	_ = json.Unmarshal(policyAsBytes, policy)
+
+	return policy, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EnforceAccessPolicy is the BeforeTransaction hook of the contract. It looks up
+// the policy of the invoked function and rejects callers that do not satisfy it.
+func EnforceAccessPolicy(ctx contractapi.TransactionContextInterface) error {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		function = function[i+1:]
+	}
+
+	policy, err := getAccessPolicy(ctx, function)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+
+	if len(policy.MSPIds) > 0 {
+		mspId, err := cid.GetMSPID(ctx.GetStub())
+		if err != nil {
+			return fmt.Errorf("failed to read client MSP ID: %s", err.Error())
+		}
+		if !containsString(policy.MSPIds, mspId) {
+			return fmt.Errorf("organization %s is not allowed to invoke %s", mspId, function)
+		}
+	}
+
+	for name, allowed := range policy.Attributes {
+		value, found, err := cid.GetAttributeValue(ctx.GetStub(), name)
+		if err != nil {
+			return fmt.Errorf("failed to read client attribute %s: %s", name, err.Error())
+		}
+		if !found || !containsString(allowed, value) {
+			return fmt.Errorf("%s %s is not allowed to invoke %s", name, value, function)
+		}
+	}
+
+	return nil
+}
+
+// SetAccessPolicy stores the policy of a function, replacing the default one.
+func (s *IdentityContract) SetAccessPolicy(ctx contractapi.TransactionContextInterface, policy AccessPolicy) (*AccessPolicy, error) {
+	if policy.Function == "" {
+		return nil, fmt.Errorf("policy function is required")
+	}
+
+	policyAsBytes, _ := json.Marshal(policy)
+	err := ctx.GetStub().PutState(accessPolicyKey(policy.Function), policyAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %s", err.Error())
+	}
+
+	return &policy, nil
+}
+
+// DeleteAccessPolicy removes the stored policy of a function, so that the
+// default one applies again.
+func (s *IdentityContract) DeleteAccessPolicy(ctx contractapi.TransactionContextInterface, function string) error {
+	err := ctx.GetStub().DelState(accessPolicyKey(function))
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state. %s", err.Error())
+	}
+	return nil
+}
+
+// GetAccessPolicy returns the policy in effect for a function.
+func (s *IdentityContract) GetAccessPolicy(ctx contractapi.TransactionContextInterface, function string) (*AccessPolicy, error) {
+	policy, err := getAccessPolicy(ctx, function)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return &AccessPolicy{Function: function}, nil
+	}
+	return policy, nil
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, value := range a {
+		if !containsString(b, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDefaultCopy reports whether a stored policy is a copy of the default one
+// written by an earlier InitLedger: the same attributes, with either the same
+// MSP IDs or none at all, as stored before roles were pinned to organizations.
+func isDefaultCopy(stored AccessPolicy, policy AccessPolicy) bool {
+	if len(stored.Attributes) != len(policy.Attributes) {
+		return false
+	}
+	for name, values := range policy.Attributes {
+		if !sameStrings(stored.Attributes[name], values) {
+			return false
+		}
+	}
+	return len(stored.MSPIds) == 0 || sameStrings(stored.MSPIds, policy.MSPIds)
+}
+
+// initAccessPolicies removes the stored copies of the default policies, so that
+// the defaults of the installed chaincode apply. Policies set with
+// SetAccessPolicy are kept.
+func initAccessPolicies(ctx contractapi.TransactionContextInterface) error {
+	var functions []string
+	for function := range defaultAccessPolicies {
+		functions = append(functions, function)
+	}
+	sort.Strings(functions)
+
+	for _, function := range functions {
+		policyAsBytes, _ := ctx.GetStub().GetState(accessPolicyKey(function))
+		if policyAsBytes == nil {
+			continue
+		}
+
+		var stored AccessPolicy
+		_ = json.Unmarshal(policyAsBytes, &stored)
+		if !isDefaultCopy(stored, defaultAccessPolicies[function]) {
+			continue
+		}
+		err := ctx.GetStub().DelState(accessPolicyKey(function))
+		if err != nil {
+			return fmt.Errorf("failed to upgrade Access Policy: %s", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/fabrictest"
+)
+
+func TestEnforceAccessPolicyDefaults(t *testing.T) {
+	n := newTestNetwork(t)
+
+	tests := []struct {
+		function string
+		user     string
+		allowed  bool
+	}{
+		{"CultivateProduct", "supplier1", true},
+		{"CultivateProduct", "manufacturer1", false},
+		{"HarvestProduct", "retailer1", false},
+		{"ImportProduct", "manufacturer1", true},
+		{"ImportProduct", "supplier1", false},
+		{"ManufactureProduct", "distributor1", false},
+		{"ExportProduct", "retailer1", false},
+		{"DistributeProduct", "distributor1", true},
+		{"DistributeProduct", "manufacturer1", false},
+		{"ImportRetailerProduct", "distributor1", false},
+		{"SellProduct", "retailer1", true},
+		{"CreateOrder", "retailer1", true},
+		{"CreateOrder", "consumer1", false},
+		{"ApproveOrder", "retailer1", false},
+		{"RejectOrder", "distributor1", false},
+		{"UpdateOrder", "retailer1", false},
+		{"FinishOrder", "manufacturer1", false},
+		{"CancelOrder", "manufacturer1", false},
+		{"ConfirmOrderDelivery", "distributor1", false},
+		{"SplitProduct", "supplier1", true},
+		{"MergeProducts", "retailer1", false},
+		{"InitiateRecall", "regulator1", true},
+		{"InitiateRecall", "retailer1", false},
+		{"SweepExpiredProducts", "supplier1", false},
+		{"IndexLegacyAssets", "supplier1", false},
+		{"SetAccessPolicy", "supplier1", false},
+		{"SetAccessPolicy", "admin", true},
+		{"DeleteAccessPolicy", "manufacturer1", false},
+		{"UpdateProduct", "retailer1", false},
+		{"AttachDocument", "consumer1", false},
+		{"RegisterSigningKey", "consumer1", false},
+		{"RegisterUser", "consumer1", true},
+		{"InitLedger", "supplier1", false},
+		{"InitLedger", "regulator1", false},
+		{"InitLedger", "admin", true},
+		{"IssueCertificate", "certifier1", true},
+		{"GetProduct", "consumer1", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.function+"/"+test.user, func(t *testing.T) {
+			_, err := invoke(n, test.user, test.function, func(ctx contractapi.TransactionContextInterface) (bool, error) {
+				return true, nil
+			})
+			if test.allowed {
+				expectNoError(t, err)
+			} else {
+				expectError(t, err, "is not allowed to invoke "+test.function)
+			}
+		})
+	}
+}
+
+func TestPinnedRolesNeedTheirOrganization(t *testing.T) {
+	n := newTestNetwork(t)
+	rogues := []struct {
+		user  string
+		mspId string
+		role  string
+	}{
+		{"rogueAdmin", "RetailerMSP", "admin"},
+		{"rogueCertifier", "RetailerMSP", "certifier"},
+		{"rogueRegulator", "ManufacturerMSP", "regulator"},
+		// an enrollment ID of another organization, with the role it has there
+		{"manufacturer1", "RetailerMSP", "manufacturer"},
+	}
+	for _, rogue := range rogues {
+		identity, err := fabrictest.NewIdentity(rogue.mspId, rogue.user, map[string]string{"role": rogue.role})
+		expectNoError(t, err)
+		n.identities[rogue.user+"@"+rogue.mspId] = identity
+	}
+
+	_, err := invoke(n, "rogueAdmin@RetailerMSP", "SetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, nil
+	})
+	expectError(t, err, "organization RetailerMSP is not allowed to invoke SetAccessPolicy")
+	_, err = invoke(n, "rogueCertifier@RetailerMSP", "IssueCertificate", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, nil
+	})
+	expectError(t, err, "organization RetailerMSP is not allowed to invoke IssueCertificate")
+
+	// InitiateRecall is open to manufacturers too, but only a regulator of the
+	// pinned organization may recall any product
+	product := n.harvest("supplier1", "ST25", "10")
+	_, err = invoke(n, "rogueRegulator@ManufacturerMSP", "InitiateRecall", func(ctx contractapi.TransactionContextInterface) (*Recall, error) {
+		return n.contract.InitiateRecall(ctx, product.ProductId, "mould")
+	})
+	expectError(t, err, "role regulator is not trusted from organization ManufacturerMSP")
+
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: n.manufactured("100").ProductId, Quantity: "10"})
+	_, err = invoke(n, "manufacturer1@RetailerMSP", "RegisterUser", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+		return n.contract.RegisterUser(ctx, UserProfile{FullName: "manufacturer1"})
+	})
+	expectError(t, err, "role manufacturer is not trusted from organization RetailerMSP")
+	_, err = n.orderAction("manufacturer1@RetailerMSP", "ApproveOrder", order.OrderId)
+	expectError(t, err, "organization RetailerMSP is not allowed to invoke ApproveOrder")
+}
+
+func TestSameActorComparesOrganizations(t *testing.T) {
+	manufacturer := Actor{UserId: "manufacturer1", Role: "manufacturer", MSPId: "ManufacturerMSP"}
+	tests := []struct {
+		name  string
+		other Actor
+		same  bool
+	}{
+		{"same user", Actor{UserId: "manufacturer1", Role: "manufacturer", MSPId: "ManufacturerMSP"}, true},
+		{"recorded before organizations", Actor{UserId: "manufacturer1", Role: "manufacturer"}, true},
+		{"other organization", Actor{UserId: "manufacturer1", Role: "retailer", MSPId: "RetailerMSP"}, false},
+		{"other organization recorded before", Actor{UserId: "manufacturer1", Role: "retailer"}, false},
+		{"other user", Actor{UserId: "manufacturer2", Role: "manufacturer", MSPId: "ManufacturerMSP"}, false},
+	}
+	for _, test := range tests {
+		if sameActor(manufacturer, test.other) != test.same || sameActor(test.other, manufacturer) != test.same {
+			t.Fatalf("%s: expected sameActor to be %v", test.name, test.same)
+		}
+	}
+	if sameActor(Actor{}, Actor{}) {
+		t.Fatal("no one is the same as no one")
+	}
+}
+
+func TestEnforceAccessPolicyStripsContractName(t *testing.T) {
+	n := newTestNetwork(t)
+
+	_, err := invoke(n, "retailer1", "SupplyContract:CultivateProduct", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, nil
+	})
+	expectError(t, err, "is not allowed to invoke CultivateProduct")
+}
+
+func TestSetGetAndDeleteAccessPolicy(t *testing.T) {
+	n := newTestNetwork(t)
+
+	_, err := invoke(n, "admin", "SetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.SetAccessPolicy(ctx, AccessPolicy{})
+	})
+	expectError(t, err, "policy function is required")
+
+	mustInvoke(n, "admin", "SetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.SetAccessPolicy(ctx, AccessPolicy{Function: "CultivateProduct", MSPIds: []string{"SupplierMSP"}})
+	})
+
+	policy := mustInvoke(n, "consumer1", "GetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.GetAccessPolicy(ctx, "CultivateProduct")
+	})
+	if len(policy.MSPIds) != 1 || len(policy.Attributes) != 0 {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+
+	// the stored policy no longer asks for the supplier role, only the MSP
+	_, err = invoke(n, "admin", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, nil
+	})
+	expectNoError(t, err)
+	_, err = invoke(n, "retailer1", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, nil
+	})
+	expectError(t, err, "organization RetailerMSP is not allowed to invoke CultivateProduct")
+
+	mustInvoke(n, "admin", "DeleteAccessPolicy", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, n.contract.DeleteAccessPolicy(ctx, "CultivateProduct")
+	})
+	policy = mustInvoke(n, "consumer1", "GetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.GetAccessPolicy(ctx, "CultivateProduct")
+	})
+	if policy.Attributes["role"][0] != "supplier" {
+		t.Fatalf("expected the default policy back, got %+v", policy)
+	}
+
+	open := mustInvoke(n, "consumer1", "GetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.GetAccessPolicy(ctx, "GetProduct")
+	})
+	if len(open.MSPIds) != 0 || len(open.Attributes) != 0 {
+		t.Fatalf("expected an open policy, got %+v", open)
+	}
+}
+
+func TestInitLedgerUpgradesStoredDefaultPolicies(t *testing.T) {
+	n := newTestNetwork(t)
+	store := func(policy AccessPolicy) {
+		policyAsBytes, _ := json.Marshal(policy)
+		n.ledger.PutState(accessPolicyKey(policy.Function), policyAsBytes)
+	}
+
+	// copies written by an InitLedger from before roles were pinned
+	store(AccessPolicy{Function: "CreateOrder", Attributes: map[string][]string{"role": {"retailer"}}})
+	store(rolePolicy("ApproveOrder", "manufacturer"))
+	// a policy set by an admin
+	store(AccessPolicy{Function: "CultivateProduct", MSPIds: []string{"SupplierMSP"}})
+
+	// a retailer certificate issued by the CA of another organization
+	identity, err := fabrictest.NewIdentity("ManufacturerMSP", "retailer1", map[string]string{"role": "retailer"})
+	expectNoError(t, err)
+	n.identities["retailer1@ManufacturerMSP"] = identity
+	_, err = invoke(n, "retailer1@ManufacturerMSP", "CreateOrder", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, nil
+	})
+	expectNoError(t, err)
+
+	mustInvoke(n, "admin", "InitLedger", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, n.contract.InitLedger(ctx)
+	})
+
+	for _, function := range []string{"CreateOrder", "ApproveOrder"} {
+		if policyAsBytes := n.ledger.GetState(accessPolicyKey(function)); policyAsBytes != nil {
+			t.Fatalf("InitLedger kept the stored copy of the default policy of %s", function)
+		}
+	}
+	_, err = invoke(n, "retailer1@ManufacturerMSP", "CreateOrder", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, nil
+	})
+	expectError(t, err, "organization ManufacturerMSP is not allowed to invoke CreateOrder")
+
+	policy := mustInvoke(n, "admin", "GetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.GetAccessPolicy(ctx, "CultivateProduct")
+	})
+	if len(policy.Attributes) != 0 || len(policy.MSPIds) != 1 {
+		t.Fatalf("InitLedger removed a policy set by an admin: %+v", policy)
+	}
+}
@@ -0,0 +1,266 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// Private data collections, as defined in collections_config.json. Prices of
+// products are shared by suppliers and manufacturers, prices of commercial
+// products and the terms of orders by manufacturers and retailers.
+const (
+	supplierManufacturerCollection = "SupplierManufacturerCollection"
+	manufacturerRetailerCollection = "ManufacturerRetailerCollection"
+)
+
+// collectionMembers mirrors the member policies of collections_config.json and
+// decides who may read a private price through this contract.
+var collectionMembers = map[string][]string{
+	supplierManufacturerCollection: {"SupplierMSP", "ManufacturerMSP"},
+	manufacturerRetailerCollection: {"ManufacturerMSP", "RetailerMSP"},
+}
+
+// Keys of the transient map. A salt must come with every private record and is
+// stored with it, so that its hash on the public record cannot be brute
+// forced; it also hides the pseudonym of consumers. A
+// signature signs an order approved with ApproveOrder, which also reads the
+// prices of the commercial products it exports from itemPrices.
+const (
+	transientPrice      = "price"
+	transientItemPrices = "itemPrices"
+	transientOrderTerms = "orderTerms"
+	transientSalt       = "salt"
+	transientSignature  = "signature"
+)
+
+// minSaltLength is the shortest salt accepted in the transient map, in bytes.
+const minSaltLength = 16
+
+// transientSaltOf returns the salt of the transient map, refusing short ones.
+func transientSaltOf(transientMap map[string][]byte) (string, error) {
+	salt := transientMap[transientSalt]
+	if len(salt) < minSaltLength {
+		return "", fmt.Errorf("a salt of at least %d bytes must be passed in the transient map", minSaltLength)
+	}
+	return string(salt), nil
+}
+
+// AssetPrice is the private record holding the price of a product or of a
+// commercial product at one stage, the status it moved to. The public record
+// keeps the SHA-256 hash of its latest price as PriceHash.
+type AssetPrice struct {
+	AssetId string `json:"assetId"`
+	Status  string `json:"status,omitempty" metadata:",optional"`
+	Price   string `json:"price"`
+	Salt    string `json:"salt,omitempty" metadata:",optional"`
+}
+
+// privatePriceKey is the private data key of the price of an asset at a stage,
+// so that a later stage never overwrites an earlier price. Prices recorded
+// before stages had their own key are read with an empty status.
+func privatePriceKey(assetId string, status string) string {
+	if status == "" {
+		return assetId
+	}
+	return assetId + "~" + status
+}
+
+// OrderTerms is the private record holding the negotiated terms of an order.
+// ItemPrices maps product ids of the order to their agreed price.
+type OrderTerms struct {
+	OrderId      string            `json:"orderId"`
+	ItemPrices   map[string]string `json:"itemPrices" metadata:",optional"`
+	PaymentTerms string            `json:"paymentTerms" metadata:",optional"`
+	Salt         string            `json:"salt,omitempty" metadata:",optional"`
+}
+
+// putPrivatePrice stores the price passed in the transient map for an asset
+// moving to status and returns the hash to keep on the public record. found is
+// false when the transaction carries no price, in which case nothing is
+// written.
+func putPrivatePrice(ctx contractapi.TransactionContextInterface, collection string, assetId string, status string) (priceHash string, found bool, err error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read transient map: %s", err.Error())
+	}
+
+	priceAsBytes, ok := transientMap[transientPrice]
+	if !ok {
+		return "", false, nil
+	}
+
+	salt, err := transientSaltOf(transientMap)
+	if err != nil {
+		return "", false, err
+	}
+
+	assetPrice := AssetPrice{
+		AssetId: assetId,
+		Status:  status,
+		Price:   string(priceAsBytes),
+		Salt:    salt,
+	}
+	assetPriceAsBytes, _ := json.Marshal(assetPrice)
+
+	err = ctx.GetStub().PutPrivateData(collection, privatePriceKey(assetId, status), assetPriceAsBytes)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to put private data: %s", err.Error())
+	}
+
+	return ledger.HashPrivateData(assetPriceAsBytes), true, nil
+}
+
+// putPrivateItemPrices stores the prices of the commercial products of an
+// order passed in the transient map, a JSON object of product commercial ids
+// to prices, and returns their hashes by product commercial id.
+func putPrivateItemPrices(ctx contractapi.TransactionContextInterface, order *Order) (map[string]string, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient map: %s", err.Error())
+	}
+
+	pricesAsBytes, ok := transientMap[transientItemPrices]
+	if !ok {
+		return nil, nil
+	}
+
+	var prices map[string]string
+	err = json.Unmarshal(pricesAsBytes, &prices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid item prices: %s", err.Error())
+	}
+	salt, err := transientSaltOf(transientMap)
+	if err != nil {
+		return nil, err
+	}
+
+	priceHashes := map[string]string{}
+	for _, item := range order.ProductItemList {
+		productCommercialId := item.Product.ProductCommercialId
+		price, ok := prices[productCommercialId]
+		if !ok {
+			continue
+		}
+		delete(prices, productCommercialId)
+
+		assetPrice := AssetPrice{
+			AssetId: productCommercialId,
+			Status:  "EXPORTED",
+			Price:   price,
+			Salt:    salt,
+		}
+		assetPriceAsBytes, _ := json.Marshal(assetPrice)
+
+		err = ctx.GetStub().PutPrivateData(manufacturerRetailerCollection, privatePriceKey(productCommercialId, "EXPORTED"), assetPriceAsBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put private data: %s", err.Error())
+		}
+		priceHashes[productCommercialId] = ledger.HashPrivateData(assetPriceAsBytes)
+	}
+	for productCommercialId := range prices {
+		return nil, fmt.Errorf("order %s has no product commercial %s", order.OrderId, productCommercialId)
+	}
+
+	return priceHashes, nil
+}
+
+// putPrivateOrderTerms stores the order terms passed in the transient map and
+// returns the hash to keep on the order.
+func putPrivateOrderTerms(ctx contractapi.TransactionContextInterface, orderId string) (termsHash string, found bool, err error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read transient map: %s", err.Error())
+	}
+
+	termsAsBytes, ok := transientMap[transientOrderTerms]
+	if !ok {
+		return "", false, nil
+	}
+
+	var orderTerms OrderTerms
+	err = json.Unmarshal(termsAsBytes, &orderTerms)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid order terms: %s", err.Error())
+	}
+	orderTerms.OrderId = orderId
+	orderTerms.Salt, err = transientSaltOf(transientMap)
+	if err != nil {
+		return "", false, err
+	}
+	orderTermsAsBytes, _ := json.Marshal(orderTerms)
+
+	err = ctx.GetStub().PutPrivateData(manufacturerRetailerCollection, orderId, orderTermsAsBytes)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to put private data: %s", err.Error())
+	}
+
+	return ledger.HashPrivateData(orderTermsAsBytes), true, nil
+}
+
+// getPrivateData reads a private record for a caller whose organization is a
+// member of the collection.
+func getPrivateData(ctx contractapi.TransactionContextInterface, collection string, key string) ([]byte, error) {
+	mspId, err := cid.GetMSPID(ctx.GetStub())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client MSP ID: %s", err.Error())
+	}
+	if !containsString(collectionMembers[collection], mspId) {
+		return nil, fmt.Errorf("organization %s cannot read %s", mspId, collection)
+	}
+
+	dataAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private data: %s", err.Error())
+	}
+	if dataAsBytes == nil {
+		return nil, fmt.Errorf("%s has no private data in %s", key, collection)
+	}
+	return dataAsBytes, nil
+}
+
+// GetProductPrice returns the private price of a product at a stage, the
+// status it moved to, to suppliers and manufacturers.
+func (s *ProductContract) GetProductPrice(ctx contractapi.TransactionContextInterface, productId string, status string) (*AssetPrice, error) {
+	priceAsBytes, err := getPrivateData(ctx, supplierManufacturerCollection, privatePriceKey(productId, status))
+	if err != nil {
+		return nil, err
+	}
+
+	assetPrice := new(AssetPrice)
+	_ = json.Unmarshal(priceAsBytes, assetPrice)
+
+	return assetPrice, nil
+}
+
+// GetProductCommercialPrice returns the private price of a commercial product
+// at a stage, the status it moved to, to manufacturers and retailers.
+func (s *ProductContract) GetProductCommercialPrice(ctx contractapi.TransactionContextInterface, productCommercialId string, status string) (*AssetPrice, error) {
+	priceAsBytes, err := getPrivateData(ctx, manufacturerRetailerCollection, privatePriceKey(productCommercialId, status))
+	if err != nil {
+		return nil, err
+	}
+
+	assetPrice := new(AssetPrice)
+	_ = json.Unmarshal(priceAsBytes, assetPrice)
+
+	return assetPrice, nil
+}
+
+// GetOrderTerms returns the private terms of an order to manufacturers and
+// retailers.
+func (s *OrderContract) GetOrderTerms(ctx contractapi.TransactionContextInterface, orderId string) (*OrderTerms, error) {
+	termsAsBytes, err := getPrivateData(ctx, manufacturerRetailerCollection, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	orderTerms := new(OrderTerms)
+	_ = json.Unmarshal(termsAsBytes, orderTerms)
+
+	return orderTerms, nil
+}
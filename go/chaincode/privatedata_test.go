@@ -0,0 +1,159 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// testSalt is a salt long enough for private records.
+const testSalt = "a long enough salt"
+
+func TestPricesAreKeptPrivate(t *testing.T) {
+	n := newTestNetwork(t)
+
+	product, err := invokeWithTransient(n, "supplier1", "CultivateProduct", map[string]string{"price": "12.5", "salt": "pepper and some salt"}, func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "ST25", Amount: "100", Unit: "kg"})
+	})
+	expectNoError(t, err)
+	if product.Price != "" || product.PriceHash == "" {
+		t.Fatalf("the public record must only carry the price hash, got %+v", product)
+	}
+
+	privateAsBytes := n.ledger.GetPrivateData(supplierManufacturerCollection, privatePriceKey(product.ProductId, "CULTIVATED"))
+	if ledger.HashPrivateData(privateAsBytes) != product.PriceHash {
+		t.Fatal("the price hash does not match the private record")
+	}
+
+	tests := []struct {
+		user  string
+		price string
+		err   string
+	}{
+		{"supplier1", "12.5", ""},
+		{"manufacturer2", "12.5", ""},
+		{"retailer1", "", "organization RetailerMSP cannot read SupplierManufacturerCollection"},
+		{"distributor1", "", "organization DistributorMSP cannot read SupplierManufacturerCollection"},
+	}
+	for _, test := range tests {
+		t.Run(test.user, func(t *testing.T) {
+			price, err := invoke(n, test.user, "GetProductPrice", func(ctx contractapi.TransactionContextInterface) (*AssetPrice, error) {
+				return n.contract.GetProductPrice(ctx, product.ProductId, "CULTIVATED")
+			})
+			if test.err != "" {
+				expectError(t, err, test.err)
+				return
+			}
+			expectNoError(t, err)
+			if price.Price != test.price || price.Salt != "pepper and some salt" {
+				t.Fatalf("unexpected price %+v", price)
+			}
+		})
+	}
+
+	_, err = invoke(n, "supplier1", "GetProductPrice", func(ctx contractapi.TransactionContextInterface) (*AssetPrice, error) {
+		return n.contract.GetProductPrice(ctx, "Product-missing", "CULTIVATED")
+	})
+	expectError(t, err, "has no private data")
+
+	_, err = invokeWithTransient(n, "supplier1", "CultivateProduct", map[string]string{"price": "12.5", "salt": "pepper"}, func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "ST25", Amount: "100", Unit: "kg"})
+	})
+	expectError(t, err, "a salt of at least 16 bytes")
+
+	// the manufacturer's purchase price does not overwrite the grower's
+	mustInvoke(n, "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.HarvestProduct(ctx, Product{ProductId: product.ProductId})
+	})
+	_, err = invokeWithTransient(n, "manufacturer1", "ImportProduct", map[string]string{"price": "14", "salt": testSalt}, func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.ImportProduct(ctx, Product{ProductId: product.ProductId})
+	})
+	expectNoError(t, err)
+	for status, want := range map[string]string{"CULTIVATED": "12.5", "IMPORTED": "14"} {
+		price := mustInvoke(n, "manufacturer1", "GetProductPrice", func(ctx contractapi.TransactionContextInterface) (*AssetPrice, error) {
+			return n.contract.GetProductPrice(ctx, product.ProductId, status)
+		})
+		if price.Price != want || price.Status != status {
+			t.Fatalf("unexpected %s price %+v", status, price)
+		}
+	}
+}
+
+func TestOrderTermsAndCommercialPrices(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+
+	terms := `{"itemPrices":{"` + product.ProductId + `":"30"},"paymentTerms":"net 30"}`
+	order, err := invokeWithTransient(n, "retailer1", "CreateOrder", map[string]string{"orderTerms": terms, "salt": testSalt}, func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.CreateOrder(ctx, OrderForCreate{ProductIdQRCodeItems: []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "10"}}})
+	})
+	expectNoError(t, err)
+	if order.TermsHash == "" {
+		t.Fatalf("expected a terms hash on %+v", order)
+	}
+
+	orderTerms := mustInvoke(n, "manufacturer1", "GetOrderTerms", func(ctx contractapi.TransactionContextInterface) (*OrderTerms, error) {
+		return n.contract.GetOrderTerms(ctx, order.OrderId)
+	})
+	if orderTerms.OrderId != order.OrderId || orderTerms.PaymentTerms != "net 30" || orderTerms.ItemPrices[product.ProductId] != "30" {
+		t.Fatalf("unexpected terms %+v", orderTerms)
+	}
+	_, err = invoke(n, "supplier1", "GetOrderTerms", func(ctx contractapi.TransactionContextInterface) (*OrderTerms, error) {
+		return n.contract.GetOrderTerms(ctx, order.OrderId)
+	})
+	expectError(t, err, "cannot read ManufacturerRetailerCollection")
+
+	_, err = invokeWithTransient(n, "retailer1", "CreateOrder", map[string]string{"orderTerms": "{", "salt": testSalt}, func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.CreateOrder(ctx, OrderForCreate{ProductIdQRCodeItems: []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "10"}}})
+	})
+	expectError(t, err, "invalid order terms")
+	_, err = invokeWithTransient(n, "retailer1", "CreateOrder", map[string]string{"orderTerms": terms}, func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.CreateOrder(ctx, OrderForCreate{ProductIdQRCodeItems: []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "10"}}})
+	})
+	expectError(t, err, "a salt of at least 16 bytes")
+
+	productCommercialId := order.ProductItemList[0].Product.ProductCommercialId
+	approve := func(prices string) (*Order, error) {
+		return invokeWithTransient(n, "manufacturer1", "ApproveOrder", map[string]string{"itemPrices": prices, "salt": testSalt}, func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+			return n.contract.ApproveOrder(ctx, order.OrderId)
+		})
+	}
+	_, err = approve(`{"ProductCommercial-other":"30"}`)
+	expectError(t, err, "has no product commercial ProductCommercial-other")
+	_, err = approve("{")
+	expectError(t, err, "invalid item prices")
+
+	order, err = approve(`{"` + productCommercialId + `":"30"}`)
+	expectNoError(t, err)
+	productCommercial := n.getProductCommercial(productCommercialId)
+	if productCommercial.PriceHash == "" || order.ProductItemList[0].Product.PriceHash != productCommercial.PriceHash {
+		t.Fatalf("expected a price hash on %+v", productCommercial)
+	}
+
+	// the retail price of the sale is kept apart from the export price
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+	n.mustOrderAction("distributor1", "FinishOrder", order.OrderId)
+	_, err = invokeWithTransient(n, "retailer1", "SellProduct", map[string]string{"price": "45", "salt": testSalt}, func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+		return n.contract.SellProduct(ctx, ProductCommercial{ProductCommercialId: productCommercialId})
+	})
+	expectNoError(t, err)
+
+	for status, want := range map[string]string{"EXPORTED": "30", "SOLD": "45"} {
+		price := mustInvoke(n, "retailer2", "GetProductCommercialPrice", func(ctx contractapi.TransactionContextInterface) (*AssetPrice, error) {
+			return n.contract.GetProductCommercialPrice(ctx, productCommercialId, status)
+		})
+		if price.Price != want || price.Status != status {
+			t.Fatalf("unexpected %s price %+v", status, price)
+		}
+	}
+
+	var stored ProductCommercial
+	_ = json.Unmarshal(n.ledger.GetState(productCommercialId), &stored)
+	if stored.Price != "" {
+		t.Fatalf("the price leaked to the world state: %+v", stored)
+	}
+}
@@ -0,0 +1,871 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+	"supplychain/internal/units"
+)
+
+// ProductContract handles products from cultivation to manufacturing, the
+// commercial products orders carry on to retailers, and the lots, recalls and
+// expiry of products.
+type ProductContract struct {
+	contractapi.Contract
+}
+
+type Product struct {
+	ProductId      string         `json:"productId"`
+	ProductCode    string 		  `json:"productCode"`
+	ProductName    string         `json:"productName"`
+	Supplier 	   Actor          `json:"supplier"`
+	Dates          []ProductDate  `json:"dates" metadata:",optional"`
+	Image          []string       `json:"image,omitempty" metadata:",optional"`
+	Expired        string         `json:"expireTime"`
+	Price          string         `json:"price" metadata:",optional"`
+	PriceHash      string         `json:"priceHash" metadata:",optional"`
+	Amount         string         `json:"amount"`
+	Unit           string         `json:"unit"`
+	Stock          *StockBalance  `json:"stock,omitempty" metadata:",optional"`
+	ParentIds      []string       `json:"parentIds,omitempty" metadata:",optional"`
+	ChildIds       []string       `json:"childIds,omitempty" metadata:",optional"`
+	Status         string         `json:"status"`
+	Description    string         `json:"description"`
+	CertificateUrl string         `json:"certificateUrl"`
+	CertificateIds []string       `json:"certificateIds,omitempty" metadata:",optional"`
+	Documents      []Document     `json:"documents,omitempty" metadata:",optional"`
+	QRCode		   string		  `json:"qrCode"`
+}
+
+type ProductCommercial struct {
+	ProductCommercialId string         `json:"productCommercialId"`
+	ProductId      		string         `json:"productId"`
+	ProductCode    		string 		   `json:"productCode"`
+	ProductName    		string         `json:"productName"`
+	Dates          		[]ProductDate  `json:"dates" metadata:",optional"`
+	Image          		[]string       `json:"image,omitempty" metadata:",optional"`
+	Expired        		string         `json:"expireTime"`
+	Price          		string         `json:"price" metadata:",optional"`
+	PriceHash      		string         `json:"priceHash" metadata:",optional"`
+	Unit           		string         `json:"unit"`
+	Status         		string         `json:"status"`
+	Description    		string         `json:"description"`
+	CertificateUrl 		string         `json:"certificateUrl"`
+	CertificateIds 		[]string       `json:"certificateIds,omitempty" metadata:",optional"`
+	Documents      		[]Document     `json:"documents,omitempty" metadata:",optional"`
+	QRCode		   		string		   `json:"qrCode"`
+	SoldTo		   		string		   `json:"soldTo,omitempty" metadata:",optional"`
+}
+
+type ProductPayload struct {
+	ProductName    string        `json:"productName"`
+	ProductCode    string        `json:"productCode"`
+	Image          []string      `json:"image" metadata:",optional"`
+	Price          string        `json:"price" metadata:",optional"`
+	Amount         string        `json:"amount"`
+	Unit           string        `json:"unit"`
+	Description    string        `json:"description"`
+	CertificateUrl string        `json:"certificateUrl"`
+	CertificateIds []string      `json:"certificateIds" metadata:",optional"`
+}
+
+type ProductHistory struct {
+	Record    		*Product  			`json:"record"`
+	TransactionId   string    			`json:"transactionId"`
+	Timestamp 		time.Time 			`json:"timestamp"`
+	IsDelete  		bool      			`json:"isDelete"`
+}
+
+type ProductCommercialHistory struct {
+	Record    		*ProductCommercial  `json:"record"`
+	TransactionId   string    			`json:"transactionId"`
+	Timestamp 		time.Time 			`json:"timestamp"`
+	IsDelete  		bool      			`json:"isDelete"`
+}
+
+func parseProductToProductCommercial(product Product) ProductCommercial {
+	productCommercial := ProductCommercial{
+		ProductCommercialId: "",
+		ProductId: product.ProductId,
+		ProductCode: product.ProductCode,
+		ProductName: product.ProductName,
+		Dates: product.Dates,
+		Image: product.Image,
+		Expired: product.Expired,
+		Price: "",
+		Unit: product.Unit,
+		Status: product.Status,
+		Description: product.Description,
+		CertificateUrl: product.CertificateUrl,
+		CertificateIds: product.CertificateIds,
+		Documents: product.Documents,
+		QRCode: "",
+	}
+
+	return productCommercial
+}
+
+// rejectBareUrls refuses image and certificate URLs sent as transaction
+// arguments. Documents are attached with AttachDocument, which anchors their
+// digest, and fill in Image and CertificateUrl.
+func rejectBareUrls(image []string, certificateUrl string) error {
+	if len(image) > 0 || certificateUrl != "" {
+		return fmt.Errorf("images and certificates must be attached with AttachDocument")
+	}
+	return nil
+}
+
+// rejectPublicPrice refuses prices sent as transaction arguments, which would
+// end up in the public block; they must come in the transient map.
+func rejectPublicPrice(price string) error {
+	if price != "" {
+		return fmt.Errorf("price must be passed in the transient map")
+	}
+	return nil
+}
+
+func (s *ProductContract) CultivateProduct(ctx contractapi.TransactionContextInterface, productObj ProductPayload) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rejectPublicPrice(productObj.Price)
+	if err != nil {
+		return nil, err
+	}
+	err = rejectBareUrls(productObj.Image, productObj.CertificateUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cultivated amount bounds the harvest
+	_, err = units.ParseQuantity(productObj.Amount, productObj.Unit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkHeldCertificates(ctx, productObj.CertificateIds, productObj.ProductCode, actor)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequiredCertificates(ctx, productObj.ProductCode, productObj.ProductCode, productObj.CertificateIds)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	var datesArray []ProductDate
+	date := ProductDate{
+		Status: "CULTIVATED",
+		Time: txTimeAsPtr,
+		Actor: actor,
+	}
+	dates := append(datesArray, date)
+	
+	var product = Product{
+		ProductId:      ledger.NewAssetId(ctx.GetStub(), "Product"),
+		ProductCode:    productObj.ProductCode,
+		ProductName:    productObj.ProductName,
+		Image:          productObj.Image,
+		Dates:          dates,
+		Amount:         productObj.Amount,
+		Unit:         	productObj.Unit,
+		Status:         "CULTIVATED",
+		Description:    productObj.Description,
+		CertificateUrl: productObj.CertificateUrl,
+		CertificateIds: productObj.CertificateIds,
+		Supplier:  		actor,
+	}
+
+	priceHash, found, err := putPrivatePrice(ctx, supplierManufacturerCollection, product.ProductId, "CULTIVATED")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		product.Price = ""
+		product.PriceHash = priceHash
+	}
+
+	productAsBytes, _ := json.Marshal(product)
+
+	ctx.GetStub().PutState(product.ProductId, productAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), productIndex, product.ProductId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductCultivated", "Product", product.ProductId, "", product.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+func (s *ProductContract) InventoryProduct(ctx contractapi.TransactionContextInterface, productObj Product) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rejectPublicPrice(productObj.Price)
+	if err != nil {
+		return nil, err
+	}
+	err = rejectBareUrls(productObj.Image, productObj.CertificateUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkHeldCertificates(ctx, productObj.CertificateIds, productObj.ProductCode, actor)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequiredCertificates(ctx, productObj.ProductCode, productObj.ProductCode, productObj.CertificateIds)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	// the provenance is recorded here, never taken from the caller
+	dates := []ProductDate{{
+		Status: "MANUFACTURED",
+		Time: txTimeAsPtr,
+		Actor: actor,
+	}}
+
+	var product = Product{
+		ProductId:      ledger.NewAssetId(ctx.GetStub(), "Product"),
+		ProductCode:    productObj.ProductCode,
+		ProductName:    productObj.ProductName,
+		Image:          productObj.Image,
+		Dates:          dates,
+		Amount:         productObj.Amount,
+		Unit:         	productObj.Unit,
+		Status:         "MANUFACTURED",
+		Description:    productObj.Description,
+		CertificateUrl: productObj.CertificateUrl,
+		CertificateIds: productObj.CertificateIds,
+		QRCode:  		productObj.QRCode,
+		Supplier:  		actor,
+	}
+
+	product.Stock, err = newStockBalance(product.Amount, product.Unit)
+	if err != nil {
+		return nil, err
+	}
+	if productObj.Expired != "" {
+		product.Expired, err = parseExpiry(productObj.Expired)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	priceHash, found, err := putPrivatePrice(ctx, supplierManufacturerCollection, product.ProductId, "MANUFACTURED")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		product.Price = ""
+		product.PriceHash = priceHash
+	}
+
+	productAsBytes, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.GetStub().PutState(product.ProductId, productAsBytes)
+	err = ledger.PutIndex(ctx.GetStub(), productIndex, product.ProductId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductInventoried", "Product", product.ProductId, "", product.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+func (s *ProductContract) HarvestProduct(ctx contractapi.TransactionContextInterface, productObj Product) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// get product details
+	productBytes, _ := ctx.GetStub().GetState(productObj.ProductId)
+	if productBytes == nil {
+		return nil, fmt.Errorf("product not found")
+	}
+
+	product := new(Product)
+	_ = json.Unmarshal(productBytes, product)
+
+	err = checkProductTransition(product, "HARVESTED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := product.Status
+
+	if !sameActor(product.Supplier, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	if len(product.ParentIds) > 0 {
+		return nil, fmt.Errorf("lot %s was split or merged from other products and cannot be harvested", product.ProductId)
+	}
+
+	// a harvest cannot yield more than was cultivated; without an amount it
+	// yields the cultivated amount
+	cultivated, err := units.ParseQuantity(product.Amount, product.Unit)
+	if err != nil {
+		return nil, fmt.Errorf("product %s has no cultivated amount: %s", product.ProductId, err.Error())
+	}
+	amount := product.Amount
+	if productObj.Amount != "" {
+		amount = productObj.Amount
+	}
+	harvested, err := units.ParseQuantity(amount, product.Unit)
+	if err != nil {
+		return nil, err
+	}
+	if harvested > cultivated {
+		return nil, fmt.Errorf("harvested amount %s exceeds the cultivated amount %s of product %s", amount, product.Amount, product.ProductId)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	date := ProductDate{
+		Status: "HARVESTED",
+		Time: txTimeAsPtr,
+		Actor: actor,
+	}
+	dates := append(product.Dates, date)
+
+	// update product
+	product.Dates = dates
+	product.Status = "HARVESTED"
+	product.Amount = amount
+	product.Stock = &StockBalance{Unit: product.Unit, Available: harvested}
+
+	updatedProductAsBytes, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(product.ProductId, updatedProductAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductHarvested", "Product", product.ProductId, oldStatus, product.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (s *ProductContract) UpdateProduct(ctx contractapi.TransactionContextInterface, productObj Product) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// get product
+	productBytes, _ := ctx.GetStub().GetState(productObj.ProductId)
+	if productBytes == nil {
+		return nil, fmt.Errorf("product not found")
+	}
+
+	product := new(Product)
+	_ = json.Unmarshal(productBytes, product)
+
+	if !sameActor(productHolder(product), actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	if product.Status == "RECALLED" {
+		return nil, fmt.Errorf("product %s is recalled", product.ProductId)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	// update the descriptive fields only; status, dates, stock and documents
+	// change through their own transactions
+	// the stock is counted in the unit, relabelling it would change the
+	// quantity it stands for
+	if productObj.Unit != "" && productObj.Unit != product.Unit {
+		if product.Stock != nil {
+			return nil, fmt.Errorf("the unit of product %s cannot change once it has stock", product.ProductId)
+		}
+		product.Unit = productObj.Unit
+	}
+	product.ProductName = productObj.ProductName
+	product.Description = productObj.Description
+	updatedProductAsBytes, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(product.ProductId, updatedProductAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductUpdated", "Product", product.ProductId, product.Status, product.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (s *ProductContract) ImportProduct(ctx contractapi.TransactionContextInterface, productObj Product) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	productBytes, _ := ctx.GetStub().GetState(productObj.ProductId)
+	if productBytes == nil {
+		return nil, fmt.Errorf("product not found")
+	}
+
+	product := new(Product)
+	_ = json.Unmarshal(productBytes, product)
+
+	err = checkProductTransition(product, "IMPORTED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := product.Status
+
+	err = rejectPublicPrice(productObj.Price)
+	if err != nil {
+		return nil, err
+	}
+	err = rejectBareUrls(productObj.Image, productObj.CertificateUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	date := ProductDate{
+		Status: "IMPORTED",
+		Time: txTimeAsPtr,
+		Actor: actor,
+	}
+	dates := append(product.Dates, date)
+
+	// update product
+	product.Dates = dates
+	product.Status = "IMPORTED"
+
+	priceHash, found, err := putPrivatePrice(ctx, supplierManufacturerCollection, product.ProductId, "IMPORTED")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		product.Price = ""
+		product.PriceHash = priceHash
+	}
+
+	updatedProductAsBytes, _ := json.Marshal(product)
+	ctx.GetStub().PutState(product.ProductId, updatedProductAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductImported", "Product", product.ProductId, oldStatus, product.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (s *ProductContract) ManufactureProduct(ctx contractapi.TransactionContextInterface, productObj Product) (*Product, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	productBytes, _ := ctx.GetStub().GetState(productObj.ProductId)
+	if productBytes == nil {
+		return nil, fmt.Errorf("product not found")
+	}
+
+	product := new(Product)
+	_ = json.Unmarshal(productBytes, product)
+
+	err = checkProductTransition(product, "MANUFACTURED")
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := product.Status
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	importer, _ := dateActor(product.Dates, "IMPORTED")
+	if !sameActor(importer, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	err = rejectBareUrls(productObj.Image, productObj.CertificateUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	date := ProductDate{
+		Status: "MANUFACTURED",
+		Time: txTimeAsPtr,
+		Actor: actor,
+	}
+	dates := append(product.Dates, date)
+
+	// update product
+	product.Dates = dates
+	product.QRCode = productObj.QRCode
+	product.Expired = ""
+	if productObj.Expired != "" {
+		product.Expired, err = parseExpiry(productObj.Expired)
+		if err != nil {
+			return nil, err
+		}
+	}
+	product.Status = "MANUFACTURED"
+
+	updatedProductAsBytes, _ := json.Marshal(product)
+	ctx.GetStub().PutState(product.ProductId, updatedProductAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductManufactured", "Product", product.ProductId, oldStatus, product.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// ExportProduct is kept for clients of the per-product flow. Commercial
+// products move with their order now: ApproveOrder exports them.
+func (s *ProductContract) ExportProduct(ctx contractapi.TransactionContextInterface, productObj ProductCommercial) (*ProductCommercial, error) {
+	return nil, movedByOrder(ctx, productObj.ProductCommercialId, "ApproveOrder")
+}
+
+// DistributeProduct is kept for clients of the per-product flow. Commercial
+// products move with their order now: UpdateOrder distributes them.
+func (s *ProductContract) DistributeProduct(ctx contractapi.TransactionContextInterface, productObj ProductCommercial) (*ProductCommercial, error) {
+	return nil, movedByOrder(ctx, productObj.ProductCommercialId, "UpdateOrder")
+}
+
+// ImportRetailerProduct is kept for clients of the per-product flow.
+// Commercial products move with their order now: FinishOrder delivers them to
+// the retailer.
+func (s *ProductContract) ImportRetailerProduct(ctx contractapi.TransactionContextInterface, productObj ProductCommercial) (*ProductCommercial, error) {
+	return nil, movedByOrder(ctx, productObj.ProductCommercialId, "FinishOrder")
+}
+
+func (s *ProductContract) SellProduct(ctx contractapi.TransactionContextInterface, productObj ProductCommercial) (*ProductCommercial, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// get product
+	productBytes, _ := ctx.GetStub().GetState(productObj.ProductCommercialId)
+	if productBytes == nil {
+		return nil, fmt.Errorf("product not found")
+	}
+
+	productCommercial := new(ProductCommercial)
+	_ = json.Unmarshal(productBytes, productCommercial)
+
+	err = checkProductCommercialTransition(productCommercial, "SOLD")
+	if err != nil {
+		return nil, err
+	}
+	if productCommercial.Status == "RESERVED" {
+		return nil, fmt.Errorf("product commercial %s is reserved by a consumer order", productCommercial.ProductCommercialId)
+	}
+	oldStatus := productCommercial.Status
+
+	retailer, err := productCommercialRetailer(ctx, productCommercial)
+	if err != nil {
+		return nil, err
+	}
+	if !sameActor(retailer, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+
+	err = checkNotExpired(ctx, productCommercial.ProductCommercialId, productCommercial.Expired)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rejectPublicPrice(productObj.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	date := ProductDate{
+		Status: "SOLD",
+		Time: txTimeAsPtr,
+		Actor: actor,
+	}
+	dates := append(productCommercial.Dates, date)
+
+	// update product
+	productCommercial.Dates = dates
+	productCommercial.Status = "SOLD"
+
+	priceHash, found, err := putPrivatePrice(ctx, manufacturerRetailerCollection, productCommercial.ProductCommercialId, "SOLD")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		productCommercial.Price = ""
+		productCommercial.PriceHash = priceHash
+	}
+
+	updatedProductAsBytes, _ := json.Marshal(productCommercial)
+	ctx.GetStub().PutState(productCommercial.ProductCommercialId, updatedProductAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "ProductSold", "ProductCommercial", productCommercial.ProductCommercialId, oldStatus, productCommercial.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return productCommercial, nil
+}
+
+func getProduct(ctx contractapi.TransactionContextInterface, ProductId string) (*Product, error) {
+	productAsBytes, err := ctx.GetStub().GetState(ProductId)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if productAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", ProductId)
+	}
+
+	product := new(Product)
+	_ = json.Unmarshal(productAsBytes, product)
+
+	return product, nil
+}
+
+func (s *ProductContract) GetProduct(ctx contractapi.TransactionContextInterface, productId string) (*Product, error) {
+	return getProduct(ctx, productId)
+}
+
+func getProductCommercial(ctx contractapi.TransactionContextInterface, ProductId string) (*ProductCommercial, error) {
+	productAsBytes, err := ctx.GetStub().GetState(ProductId)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if productAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", ProductId)
+	}
+
+	productCommercial := new(ProductCommercial)
+	_ = json.Unmarshal(productAsBytes, productCommercial)
+
+	return productCommercial, nil
+}
+
+func (s *ProductContract) GetProductCommercial(ctx contractapi.TransactionContextInterface, productCommercialId string) (*ProductCommercial, error) {
+	return getProductCommercial(ctx, productCommercialId)
+}
+
+func (s *ProductContract) GetAllProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(productIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var products []*Product
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		productAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		err = json.Unmarshal(productAsBytes, &product)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, &product)
+	}
+
+	if len(products) == 0 {
+		return []*Product{}, nil
+	}
+
+	return products, nil
+}
+
+func (s *ProductContract) GetAllProductsCommercial(ctx contractapi.TransactionContextInterface) ([]*ProductCommercial, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(productCommercialIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var productCommercials []*ProductCommercial
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		productCommercialAsBytes, err := ledger.GetIndexedAsset(ctx.GetStub(), response.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var productCommercial ProductCommercial
+		err = json.Unmarshal(productCommercialAsBytes, &productCommercial)
+		if err != nil {
+			return nil, err
+		}
+
+		productCommercials = append(productCommercials, &productCommercial)
+	}
+
+	if len(productCommercials) == 0 {
+		return []*ProductCommercial{}, nil
+	}
+
+	return productCommercials, nil
+}
+
+func (s *ProductContract) GetProductTransactionHistory(ctx contractapi.TransactionContextInterface, productId string) ([]ProductHistory, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(productId)
+
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
+
+	defer resultsIterator.Close()
+	var histories []ProductHistory
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		if len(response.Value) > 0 {
+			err = json.Unmarshal(response.Value, &product)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			product = Product{
+				ProductId: productId,
+			}
+		}
+
+		timestamp, err := ptypes.Timestamp(response.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		productHistory := ProductHistory{
+			Record: &product,
+			TransactionId: response.TxId,
+			Timestamp: timestamp,
+			IsDelete: response.IsDelete,
+		}
+		histories = append(histories, productHistory)
+	}
+
+	if len(histories) == 0 {
+		return []ProductHistory{}, nil
+	}
+
+	return histories, nil
+}
+
+func (s *ProductContract) GetProductCommercialTransactionHistory(ctx contractapi.TransactionContextInterface, productCommercialId string) ([]ProductCommercialHistory, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(productCommercialId)
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
+
+	defer resultsIterator.Close()
+	var histories []ProductCommercialHistory
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var productCommercial ProductCommercial
+		if len(response.Value) > 0 {
+			err = json.Unmarshal(response.Value, &productCommercial)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			productCommercial = ProductCommercial{
+				ProductCommercialId: productCommercialId,
+			}
+		}
+
+		timestamp, err := ptypes.Timestamp(response.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		ProductCommercialHistory := ProductCommercialHistory{
+			Record: &productCommercial,
+			TransactionId: response.TxId,
+			Timestamp: timestamp,
+			IsDelete: response.IsDelete,
+		}
+		histories = append(histories, ProductCommercialHistory)
+	}
+
+	if len(histories) == 0 {
+		return []ProductCommercialHistory{}, nil
+	}
+
+	return histories, nil
+}
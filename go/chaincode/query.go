@@ -0,0 +1,255 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+
+	"supplychain/internal/dates"
+	"supplychain/internal/ledger"
+)
+
+// ProductQuery is the restricted selector accepted by QueryProducts. Empty
+// fields are ignored. FromDate and ToDate bound the cultivation date and take
+// RFC 3339 times or plain dates. SortBy is one of productCode or status.
+// PageSize is required, as the contract metadata needs one required field;
+// a PageSize of 0 returns every match in one response.
+type ProductQuery struct {
+	Status      string `json:"status" metadata:",optional"`
+	SupplierId  string `json:"supplierId" metadata:",optional"`
+	ProductCode string `json:"productCode" metadata:",optional"`
+	FromDate    string `json:"fromDate" metadata:",optional"`
+	ToDate      string `json:"toDate" metadata:",optional"`
+	SortBy      string `json:"sortBy" metadata:",optional"`
+	Descending  bool   `json:"descending" metadata:",optional"`
+	PageSize    int32  `json:"pageSize"`
+	Bookmark    string `json:"bookmark" metadata:",optional"`
+}
+
+// OrderQuery is the restricted selector accepted by QueryOrders. FromDate and
+// ToDate bound the creation date. SortBy is one of createDate or status.
+type OrderQuery struct {
+	Status         string `json:"status" metadata:",optional"`
+	RetailerId     string `json:"retailerId" metadata:",optional"`
+	ManufacturerId string `json:"manufacturerId" metadata:",optional"`
+	DistributorId  string `json:"distributorId" metadata:",optional"`
+	ProductCode    string `json:"productCode" metadata:",optional"`
+	FromDate       string `json:"fromDate" metadata:",optional"`
+	ToDate         string `json:"toDate" metadata:",optional"`
+	SortBy         string `json:"sortBy" metadata:",optional"`
+	Descending     bool   `json:"descending" metadata:",optional"`
+	PageSize       int32  `json:"pageSize"`
+	Bookmark       string `json:"bookmark" metadata:",optional"`
+}
+
+var productSortFields = []string{"productCode", "status"}
+var orderSortFields = []string{"createDate", "status"}
+
+// dateRangeSelector bounds a transaction date by client dates, converted into
+// the format transaction times are stored in so that CouchDB can compare them
+// as strings. A plain toDate includes the whole of that day.
+func dateRangeSelector(fromDate string, toDate string) (map[string]interface{}, error) {
+	dateRange := map[string]interface{}{}
+	if fromDate != "" {
+		from, err := dates.Parse(fromDate)
+		if err != nil {
+			return nil, err
+		}
+		dateRange["$gte"] = ledger.FormatTimestamp(from)
+	}
+	if toDate != "" {
+		to, err := dates.Parse(toDate)
+		if err != nil {
+			return nil, err
+		}
+		if dates.IsDay(toDate) {
+			dateRange["$lt"] = ledger.FormatTimestamp(to.AddDate(0, 0, 1))
+		} else {
+			dateRange["$lte"] = ledger.FormatTimestamp(to)
+		}
+	}
+	return dateRange, nil
+}
+
+// buildQuery wraps a selector into a CouchDB query, adding the sort clause.
+// CouchDB only sorts on fields present in the selector, so a sort field with
+// no condition is constrained to any non-null value.
+func buildQuery(selector map[string]interface{}, sortBy string, descending bool, sortFields []string) (string, error) {
+	query := map[string]interface{}{"selector": selector}
+
+	if sortBy != "" {
+		if !containsString(sortFields, sortBy) {
+			return "", fmt.Errorf("cannot sort by %s", sortBy)
+		}
+		if _, ok := selector[sortBy]; !ok {
+			selector[sortBy] = map[string]interface{}{"$gt": nil}
+		}
+		direction := "asc"
+		if descending {
+			direction = "desc"
+		}
+		query["sort"] = []map[string]string{{sortBy: direction}}
+	}
+
+	queryString, err := json.Marshal(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to build query: %s", err.Error())
+	}
+	return string(queryString), nil
+}
+
+// getQueryResult runs a query, paginated when pageSize is set.
+func getQueryResult(ctx contractapi.TransactionContextInterface, queryString string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if pageSize > 0 {
+		return ctx.GetStub().GetQueryResultWithPagination(queryString, pageSize, bookmark)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	return resultsIterator, &peer.QueryResponseMetadata{}, err
+}
+
+func executeProductQuery(ctx contractapi.TransactionContextInterface, queryString string, pageSize int32, bookmark string) (*ProductPage, error) {
+	resultsIterator, responseMetadata, err := getQueryResult(ctx, queryString, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	products := []*Product{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		err = json.Unmarshal(response.Value, &product)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, &product)
+	}
+
+	fetchedRecordsCount := responseMetadata.FetchedRecordsCount
+	if pageSize == 0 {
+		fetchedRecordsCount = int32(len(products))
+	}
+
+	return &ProductPage{
+		Records:             products,
+		FetchedRecordsCount: fetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+func executeOrderQuery(ctx contractapi.TransactionContextInterface, queryString string, pageSize int32, bookmark string) (*OrderPage, error) {
+	resultsIterator, responseMetadata, err := getQueryResult(ctx, queryString, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	orders := []*Order{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		err = json.Unmarshal(response.Value, &order)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, &order)
+	}
+
+	fetchedRecordsCount := responseMetadata.FetchedRecordsCount
+	if pageSize == 0 {
+		fetchedRecordsCount = int32(len(orders))
+	}
+
+	return &OrderPage{
+		Records:             orders,
+		FetchedRecordsCount: fetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// QueryProducts runs a rich query over products. It needs CouchDB as state
+// database; the indexes it relies on ship in META-INF/statedb/couchdb/indexes.
+func (s *ProductContract) QueryProducts(ctx contractapi.TransactionContextInterface, query ProductQuery) (*ProductPage, error) {
+	selector := map[string]interface{}{
+		"supplier": map[string]interface{}{"$exists": true},
+	}
+	if query.Status != "" {
+		selector["status"] = query.Status
+	}
+	if query.SupplierId != "" {
+		selector["supplier.userId"] = query.SupplierId
+	}
+	if query.ProductCode != "" {
+		selector["productCode"] = query.ProductCode
+	}
+	if query.FromDate != "" || query.ToDate != "" {
+		dateRange, err := dateRangeSelector(query.FromDate, query.ToDate)
+		if err != nil {
+			return nil, err
+		}
+		selector["dates"] = map[string]interface{}{"$elemMatch": map[string]interface{}{
+			"status": "CULTIVATED",
+			"time":   dateRange,
+		}}
+	}
+
+	queryString, err := buildQuery(selector, query.SortBy, query.Descending, productSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeProductQuery(ctx, queryString, query.PageSize, query.Bookmark)
+}
+
+// QueryOrders runs a rich query over orders. It needs CouchDB as state
+// database; the indexes it relies on ship in META-INF/statedb/couchdb/indexes.
+func (s *OrderContract) QueryOrders(ctx contractapi.TransactionContextInterface, query OrderQuery) (*OrderPage, error) {
+	selector := map[string]interface{}{
+		"orderId": map[string]interface{}{"$exists": true},
+	}
+	if query.Status != "" {
+		selector["status"] = query.Status
+	}
+	if query.RetailerId != "" {
+		selector["retailer.userId"] = query.RetailerId
+	}
+	if query.ManufacturerId != "" {
+		selector["manufacturer.userId"] = query.ManufacturerId
+	}
+	if query.DistributorId != "" {
+		selector["distributor.userId"] = query.DistributorId
+	}
+	if query.ProductCode != "" {
+		selector["productItemList"] = map[string]interface{}{"$elemMatch": map[string]interface{}{
+			"product.productCode": query.ProductCode,
+		}}
+	}
+	if query.FromDate != "" || query.ToDate != "" {
+		dateRange, err := dateRangeSelector(query.FromDate, query.ToDate)
+		if err != nil {
+			return nil, err
+		}
+		selector["createDate"] = dateRange
+	}
+
+	queryString, err := buildQuery(selector, query.SortBy, query.Descending, orderSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeOrderQuery(ctx, queryString, query.PageSize, query.Bookmark)
+}
@@ -0,0 +1,118 @@
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestQueryProducts(t *testing.T) {
+	n := newTestNetwork(t)
+	n.harvest("supplier1", "ST25", "10")
+	n.harvest("supplier1", "ST24", "10")
+	n.harvest("supplier2", "ST25", "10")
+	mustInvoke(n, "supplier2", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "NEP", Amount: "10", Unit: "kg"})
+	})
+
+	tests := []struct {
+		name  string
+		query ProductQuery
+		want  []string
+		err   string
+	}{
+		{"by status", ProductQuery{Status: "HARVESTED", SortBy: "productCode"}, []string{"ST24", "ST25", "ST25"}, ""},
+		{"by supplier", ProductQuery{SupplierId: "supplier2", SortBy: "productCode", Descending: true}, []string{"ST25", "NEP"}, ""},
+		{"by code", ProductQuery{ProductCode: "ST25", SupplierId: "supplier1"}, []string{"ST25"}, ""},
+		{"cultivated after", ProductQuery{FromDate: "2023-01-02", SortBy: "productCode"}, nil, ""},
+		{"cultivated before", ProductQuery{ToDate: "2023-01-02", SortBy: "productCode"}, []string{"NEP", "ST24", "ST25", "ST25"}, ""},
+		{"cultivated on the first day", ProductQuery{FromDate: "2023-01-01", ToDate: "2023-01-01", SortBy: "productCode"}, []string{"NEP", "ST24", "ST25", "ST25"}, ""},
+		{"bad sort", ProductQuery{SortBy: "amount"}, nil, "cannot sort by amount"},
+		{"bad date", ProductQuery{FromDate: "yesterday"}, nil, "invalid date yesterday"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := invoke(n, "consumer1", "QueryProducts", func(ctx contractapi.TransactionContextInterface) (*ProductPage, error) {
+				return n.contract.QueryProducts(ctx, test.query)
+			})
+			if test.err != "" {
+				expectError(t, err, test.err)
+				return
+			}
+			expectNoError(t, err)
+
+			var codes []string
+			for _, product := range page.Records {
+				codes = append(codes, product.ProductCode)
+			}
+			if len(codes) != len(test.want) || int(page.FetchedRecordsCount) != len(test.want) {
+				t.Fatalf("expected %v, got %v", test.want, codes)
+			}
+			for i := range codes {
+				if codes[i] != test.want[i] {
+					t.Fatalf("expected %v, got %v", test.want, codes)
+				}
+			}
+		})
+	}
+
+	page := mustInvoke(n, "consumer1", "QueryProducts", func(ctx contractapi.TransactionContextInterface) (*ProductPage, error) {
+		return n.contract.QueryProducts(ctx, ProductQuery{PageSize: 3})
+	})
+	if len(page.Records) != 3 || page.Bookmark == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page = mustInvoke(n, "consumer1", "QueryProducts", func(ctx contractapi.TransactionContextInterface) (*ProductPage, error) {
+		return n.contract.QueryProducts(ctx, ProductQuery{PageSize: 3, Bookmark: page.Bookmark})
+	})
+	if len(page.Records) != 1 || page.Bookmark != "" {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+}
+
+func TestQueryOrders(t *testing.T) {
+	// dates are recorded in UTC whatever the zone of the peer
+	local := time.Local
+	time.Local = time.FixedZone("ICT", 7*60*60)
+	defer func() { time.Local = local }()
+
+	n := newTestNetwork(t)
+	product := n.manufactured("1000")
+	first := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	n.mustCreateOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", first.OrderId)
+	n.mustAssignDistributor(first.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", first.OrderId)
+
+	tests := []struct {
+		name  string
+		query OrderQuery
+		want  int
+	}{
+		{"all", OrderQuery{SortBy: "createDate"}, 2},
+		{"by status", OrderQuery{Status: "SHIPPING"}, 1},
+		{"by retailer", OrderQuery{RetailerId: "retailer2"}, 1},
+		{"by manufacturer", OrderQuery{ManufacturerId: "manufacturer1", SortBy: "status"}, 2},
+		{"by distributor", OrderQuery{DistributorId: "distributor1"}, 1},
+		{"by product code", OrderQuery{ProductCode: "ST25"}, 2},
+		{"by other product code", OrderQuery{ProductCode: "ST24"}, 0},
+		{"created in 2022", OrderQuery{ToDate: "2022-12-31"}, 0},
+		{"created since 2023", OrderQuery{FromDate: "2023-01-01T00:00:00Z"}, 2},
+		{"created on the first day", OrderQuery{ToDate: "2023-01-01"}, 2},
+		{"created before the first hour", OrderQuery{ToDate: "2023-01-01T00:59:59+01:00"}, 0},
+		{"created in the first hour", OrderQuery{ToDate: "2023-01-01T01:00:00Z"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := mustInvoke(n, "consumer1", "QueryOrders", func(ctx contractapi.TransactionContextInterface) (*OrderPage, error) {
+				return n.contract.QueryOrders(ctx, test.query)
+			})
+			if len(page.Records) != test.want {
+				t.Fatalf("expected %d orders, got %d", test.want, len(page.Records))
+			}
+		})
+	}
+}
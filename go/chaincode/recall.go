@@ -0,0 +1,328 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// productSourceIndex links a product to the commercial products created from
+// it by CreateOrder and to their orders.
+const productSourceIndex = "productId~productCommercialId~orderId"
+
+// openOrderStatuses are the order statuses a recall stops. Rejected, cancelled
+// and delivered orders are reported but keep their status.
+var openOrderStatuses = []string{"PENDING", "APPROVED", "SHIPPING", "SHIPPED", "COLD_CHAIN_BREACH"}
+
+// Recall records a recall of a product and of everything derived from it.
+// OrderIds lists every order holding a recalled product, open or not.
+type Recall struct {
+	RecallId             string   `json:"recallId"`
+	ProductId            string   `json:"productId"`
+	Reason               string   `json:"reason"`
+	Actor                Actor    `json:"actor"`
+	Timestamp            string   `json:"timestamp"`
+	ProductIds           []string `json:"productIds"`
+	ProductCommercialIds []string `json:"productCommercialIds"`
+	OrderIds             []string `json:"orderIds"`
+}
+
+// RecallItem is one order item holding a recalled product.
+type RecallItem struct {
+	OrderId             string   `json:"orderId"`
+	OrderStatus         string   `json:"orderStatus"`
+	ProductId           string   `json:"productId"`
+	ProductCommercialId string   `json:"productCommercialId"`
+	Quantity            Quantity `json:"quantity"`
+}
+
+// RetailerRecallImpact is what a recall takes back from one retailer.
+// Quantities sums the items per unit.
+type RetailerRecallImpact struct {
+	Retailer   Actor        `json:"retailer"`
+	Items      []RecallItem `json:"items"`
+	Quantities []Quantity   `json:"quantities"`
+}
+
+type RecallImpact struct {
+	Recall    *Recall                `json:"recall"`
+	Retailers []RetailerRecallImpact `json:"retailers"`
+}
+
+func putProductSourceIndex(ctx contractapi.TransactionContextInterface, productId string, productCommercialId string, orderId string) error {
+	return ledger.PutIndex(ctx.GetStub(), productSourceIndex, productId, productCommercialId, orderId)
+}
+
+// productSources returns the commercial product ids and order ids derived
+// from a product.
+func productSources(ctx contractapi.TransactionContextInterface, productId string) ([][2]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(productSourceIndex, []string{productId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var sources [][2]string
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(response.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(attributes) != 3 {
+			return nil, fmt.Errorf("malformed index key %s", response.Key)
+		}
+		sources = append(sources, [2]string{attributes[1], attributes[2]})
+	}
+	return sources, nil
+}
+
+// canRecall tells whether actor may recall product: regulators always, others
+// only if they handled the product.
+func canRecall(product *Product, actor Actor) bool {
+	if actor.Role == "regulator" || sameActor(product.Supplier, actor) {
+		return true
+	}
+	for _, date := range product.Dates {
+		if sameActor(date.Actor, actor) {
+			return true
+		}
+	}
+	return false
+}
+
+// InitiateRecall marks a product, the lots split or merged from it, the
+// commercial products made from them and their open orders as RECALLED.
+// Recalled assets cannot move any further.
+func (s *ProductContract) InitiateRecall(ctx contractapi.TransactionContextInterface, productId string, reason string) (*Recall, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := getProduct(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canRecall(product, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+	if product.Status == "RECALLED" {
+		return nil, fmt.Errorf("product %s is already recalled", productId)
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	descendants, err := walkProducts(ctx, product, func(p *Product) []string { return p.ChildIds })
+	if err != nil {
+		return nil, err
+	}
+
+	recall := Recall{
+		RecallId:             ledger.NewAssetId(ctx.GetStub(), "Recall"),
+		ProductId:            productId,
+		Reason:               reason,
+		Actor:                actor,
+		Timestamp:            txTimeAsPtr,
+		ProductCommercialIds: []string{},
+		OrderIds:             []string{},
+	}
+	date := ProductDate{
+		Status: "RECALLED",
+		Time:   txTimeAsPtr,
+		Actor:  actor,
+	}
+
+	var events []SupplyChainEvent
+	recalledOrders := map[string]bool{}
+	for _, recalledProduct := range append([]*Product{product}, descendants...) {
+		recall.ProductIds = append(recall.ProductIds, recalledProduct.ProductId)
+
+		if recalledProduct.Status != "RECALLED" {
+			oldStatus := recalledProduct.Status
+			recalledProduct.Dates = append(recalledProduct.Dates, date)
+			recalledProduct.Status = "RECALLED"
+
+			productAsBytes, _ := json.Marshal(recalledProduct)
+			ctx.GetStub().PutState(recalledProduct.ProductId, productAsBytes)
+			events = append(events, newEvent(ctx, "ProductRecalled", "Product", recalledProduct.ProductId, oldStatus, recalledProduct.Status, actor, txTimeAsPtr))
+		}
+
+		sources, err := productSources(ctx, recalledProduct.ProductId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, source := range sources {
+			productCommercialId, orderId := source[0], source[1]
+			recall.ProductCommercialIds = append(recall.ProductCommercialIds, productCommercialId)
+			if !recalledOrders[orderId] {
+				recalledOrders[orderId] = true
+				recall.OrderIds = append(recall.OrderIds, orderId)
+			}
+
+			productCommercial, err := getProductCommercial(ctx, productCommercialId)
+			if err != nil {
+				return nil, err
+			}
+			if productCommercial.Status == "RECALLED" {
+				continue
+			}
+
+			oldStatus := productCommercial.Status
+			productCommercial.Dates = append(productCommercial.Dates, date)
+			productCommercial.Status = "RECALLED"
+
+			productCommercialAsBytes, _ := json.Marshal(productCommercial)
+			ctx.GetStub().PutState(productCommercial.ProductCommercialId, productCommercialAsBytes)
+			events = append(events, newEvent(ctx, "ProductRecalled", "ProductCommercial", productCommercial.ProductCommercialId, oldStatus, productCommercial.Status, actor, txTimeAsPtr))
+		}
+	}
+
+	recalledProducts := map[string]bool{}
+	for _, recalledProductId := range recall.ProductIds {
+		recalledProducts[recalledProductId] = true
+	}
+
+	for _, orderId := range recall.OrderIds {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(openOrderStatuses, order.Status) {
+			continue
+		}
+
+		for i, item := range order.ProductItemList {
+			if recalledProducts[item.Product.ProductId] {
+				order.ProductItemList[i].Product.Dates = append(item.Product.Dates, date)
+				order.ProductItemList[i].Product.Status = "RECALLED"
+			}
+		}
+
+		oldStatus := order.Status
+		order.DeliveryStatuses = append(order.DeliveryStatuses, DeliveryStatus{
+			Status:       "RECALLED",
+			DeliveryDate: txTimeAsPtr,
+			Address:      actor.Address,
+			Actor:        actor,
+		})
+		order.UpdateDate = txTimeAsPtr
+		order.Status = "RECALLED"
+
+		orderAsBytes, _ := json.Marshal(order)
+		ctx.GetStub().PutState(order.OrderId, orderAsBytes)
+		events = append(events, newEvent(ctx, "OrderRecalled", "Order", order.OrderId, oldStatus, order.Status, actor, txTimeAsPtr))
+	}
+
+	recallAsBytes, _ := json.Marshal(recall)
+	ctx.GetStub().PutState(recall.RecallId, recallAsBytes)
+
+	events = append([]SupplyChainEvent{newEvent(ctx, "RecallInitiated", "Recall", recall.RecallId, "", "RECALLED", actor, txTimeAsPtr)}, events...)
+	err = emitEvents(ctx, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recall, nil
+}
+
+func (s *ProductContract) GetRecall(ctx contractapi.TransactionContextInterface, recallId string) (*Recall, error) {
+	recallAsBytes, err := ctx.GetStub().GetState(recallId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if recallAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", recallId)
+	}
+
+	recall := new(Recall)
+	_ = json.Unmarshal(recallAsBytes, recall)
+
+	return recall, nil
+}
+
+// GetRecallImpact lists, per retailer, the order items holding a product of a
+// recall and the quantities to take back.
+func (s *ProductContract) GetRecallImpact(ctx contractapi.TransactionContextInterface, recallId string) (*RecallImpact, error) {
+	recall, err := s.GetRecall(ctx, recallId)
+	if err != nil {
+		return nil, err
+	}
+
+	recalledProducts := map[string]bool{}
+	for _, productId := range recall.ProductIds {
+		recalledProducts[productId] = true
+	}
+
+	retailers := map[string]*RetailerRecallImpact{}
+	for _, orderId := range recall.OrderIds {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+
+		impact, ok := retailers[order.Retailer.UserId]
+		if !ok {
+			impact = &RetailerRecallImpact{Retailer: order.Retailer}
+			retailers[order.Retailer.UserId] = impact
+		}
+
+		for _, item := range order.ProductItemList {
+			if !recalledProducts[item.Product.ProductId] {
+				continue
+			}
+
+			value, err := itemQuantity(item)
+			if err != nil {
+				return nil, err
+			}
+			quantity := Quantity{Value: value, Unit: item.Product.Unit}
+
+			impact.Items = append(impact.Items, RecallItem{
+				OrderId:             order.OrderId,
+				OrderStatus:         order.Status,
+				ProductId:           item.Product.ProductId,
+				ProductCommercialId: item.Product.ProductCommercialId,
+				Quantity:            quantity,
+			})
+			impact.Quantities = addQuantity(impact.Quantities, quantity)
+		}
+	}
+
+	var retailerIds []string
+	for retailerId := range retailers {
+		retailerIds = append(retailerIds, retailerId)
+	}
+	sort.Strings(retailerIds)
+
+	report := RecallImpact{Recall: recall, Retailers: []RetailerRecallImpact{}}
+	for _, retailerId := range retailerIds {
+		report.Retailers = append(report.Retailers, *retailers[retailerId])
+	}
+
+	return &report, nil
+}
+
+// addQuantity adds quantity to the total of its unit.
+func addQuantity(totals []Quantity, quantity Quantity) []Quantity {
+	for i := range totals {
+		if totals[i].Unit == quantity.Unit {
+			totals[i].Value += quantity.Value
+			return totals
+		}
+	}
+	return append(totals, quantity)
+}
@@ -0,0 +1,88 @@
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/units"
+)
+
+func TestRecallFollowsLotsAndOrders(t *testing.T) {
+	n := newTestNetwork(t)
+	harvested := n.harvest("supplier1", "ST25", "100")
+	lots := mustInvoke(n, "supplier1", "SplitProduct", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.SplitProduct(ctx, harvested.ProductId, []string{"40"})
+	})
+	product := n.manufacture("manufacturer1", lots[0].ProductId, "2030-01-01")
+
+	open := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"}, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "5"})
+	shipping := n.mustCreateOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "20"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", shipping.OrderId)
+	n.mustAssignDistributor(shipping.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", shipping.OrderId)
+	rejected := n.mustCreateOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	n.mustOrderAction("manufacturer1", "RejectOrder", rejected.OrderId)
+
+	_, err := invoke(n, "supplier2", "InitiateRecall", func(ctx contractapi.TransactionContextInterface) (*Recall, error) {
+		return n.contract.InitiateRecall(ctx, harvested.ProductId, "contamination")
+	})
+	expectError(t, err, "Permission denied")
+
+	recall := mustInvoke(n, "regulator1", "InitiateRecall", func(ctx contractapi.TransactionContextInterface) (*Recall, error) {
+		return n.contract.InitiateRecall(ctx, harvested.ProductId, "contamination")
+	})
+	events := n.lastEvents()
+	if events[0].EventType != "RecallInitiated" || len(events) != 9 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if len(recall.ProductIds) != 2 || len(recall.ProductCommercialIds) != 4 || len(recall.OrderIds) != 3 {
+		t.Fatalf("unexpected recall %+v", recall)
+	}
+
+	tests := []struct {
+		orderId string
+		status  string
+	}{
+		{open.OrderId, "RECALLED"},
+		{shipping.OrderId, "RECALLED"},
+		{rejected.OrderId, "REJECTED"},
+	}
+	for _, test := range tests {
+		order := n.getOrder(test.orderId)
+		if order.Status != test.status {
+			t.Fatalf("expected order %s to be %s, got %s", test.orderId, test.status, order.Status)
+		}
+		if n.getProductCommercial(order.ProductItemList[0].Product.ProductCommercialId).Status != "RECALLED" {
+			t.Fatalf("the commercial product of %s is not recalled", test.orderId)
+		}
+	}
+	if n.getProduct(product.ProductId).Status != "RECALLED" {
+		t.Fatal("the lot made from the recalled product is not recalled")
+	}
+
+	_, err = n.orderAction("distributor1", "FinishOrder", shipping.OrderId)
+	expectError(t, err, "cannot move from RECALLED")
+	_, err = invoke(n, "supplier1", "InitiateRecall", func(ctx contractapi.TransactionContextInterface) (*Recall, error) {
+		return n.contract.InitiateRecall(ctx, harvested.ProductId, "again")
+	})
+	expectError(t, err, "is already recalled")
+
+	impact := mustInvoke(n, "consumer1", "GetRecallImpact", func(ctx contractapi.TransactionContextInterface) (*RecallImpact, error) {
+		return n.contract.GetRecallImpact(ctx, recall.RecallId)
+	})
+	if len(impact.Retailers) != 2 || impact.Retailers[0].Retailer.UserId != "retailer1" {
+		t.Fatalf("unexpected impact %+v", impact)
+	}
+	if quantities := impact.Retailers[0].Quantities; len(quantities) != 1 || quantities[0].Value != 15*units.One || quantities[0].Unit != "kg" {
+		t.Fatalf("unexpected quantities %+v", quantities)
+	}
+	if items := impact.Retailers[1].Items; len(items) != 2 || impact.Retailers[1].Quantities[0].Value != 21*units.One {
+		t.Fatalf("unexpected items %+v", items)
+	}
+
+	_, err = invoke(n, "consumer1", "GetRecall", func(ctx contractapi.TransactionContextInterface) (*Recall, error) {
+		return n.contract.GetRecall(ctx, "Recall-missing")
+	})
+	expectError(t, err, "does not exist")
+}
@@ -0,0 +1,133 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// checkpointTypes are the kinds of place a shipment passes: where it is
+// picked up, a hub it goes through and where it is handed over.
+var checkpointTypes = []string{"PICKUP", "HUB", "HANDOVER"}
+
+// RouteCheckpoint is a place a shipping order passed, recorded by its
+// distributor. Latitude and Longitude are WGS 84 decimal degrees.
+type RouteCheckpoint struct {
+	Type       string  `json:"type"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	FacilityId string  `json:"facilityId,omitempty" metadata:",optional"`
+	Time       string  `json:"time"`
+	Actor      Actor   `json:"actor"`
+}
+
+type CheckpointForAdd struct {
+	OrderId    string  `json:"orderId"`
+	Type       string  `json:"type"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	FacilityId string  `json:"facilityId" metadata:",optional"`
+}
+
+// OrderRoute is the route an order took so far. LastCheckpoint is where it
+// was last seen.
+type OrderRoute struct {
+	OrderId        string            `json:"orderId"`
+	Status         string            `json:"status"`
+	Distributor    Actor             `json:"distributor"`
+	Checkpoints    []RouteCheckpoint `json:"checkpoints"`
+	LastCheckpoint *RouteCheckpoint  `json:"lastCheckpoint,omitempty" metadata:",optional"`
+}
+
+// checkCoordinates fails unless latitude and longitude are decimal degrees
+// within range.
+func checkCoordinates(latitude float64, longitude float64) error {
+	if math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		return fmt.Errorf("latitude must be from -90 to 90 degrees")
+	}
+	if math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		return fmt.Errorf("longitude must be from -180 to 180 degrees")
+	}
+	return nil
+}
+
+// AddOrderCheckpoint appends a place a shipping order passed to its route.
+// Only the distributor shipping the order may add one.
+func (s *OrderContract) AddOrderCheckpoint(ctx contractapi.TransactionContextInterface, checkpointObj CheckpointForAdd) (*Order, error) {
+	actor, err := getActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !containsString(checkpointTypes, checkpointObj.Type) {
+		return nil, fmt.Errorf("checkpoint type must be one of %v", checkpointTypes)
+	}
+	err = checkCoordinates(checkpointObj.Latitude, checkpointObj.Longitude)
+	if err != nil {
+		return nil, err
+	}
+	if checkpointObj.Type == "HUB" && checkpointObj.FacilityId == "" {
+		return nil, fmt.Errorf("hub checkpoints need a facility id")
+	}
+
+	order, err := getOrder(ctx, checkpointObj.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != "SHIPPING" {
+		return nil, fmt.Errorf("order %s is not shipping", order.OrderId)
+	}
+	if !sameActor(order.Distributor, actor) {
+		return nil, fmt.Errorf("Permission denied!")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	order.Checkpoints = append(order.Checkpoints, RouteCheckpoint{
+		Type:       checkpointObj.Type,
+		Latitude:   checkpointObj.Latitude,
+		Longitude:  checkpointObj.Longitude,
+		FacilityId: checkpointObj.FacilityId,
+		Time:       txTimeAsPtr,
+		Actor:      actor,
+	})
+	order.UpdateDate = txTimeAsPtr
+
+	orderAsBytes, _ := json.Marshal(order)
+	ctx.GetStub().PutState(order.OrderId, orderAsBytes)
+
+	err = emitEvents(ctx, newEvent(ctx, "OrderCheckpointAdded", "Order", order.OrderId, order.Status, order.Status, actor, txTimeAsPtr))
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// GetOrderRoute returns the checkpoints of an order in the order they were
+// recorded.
+func (s *OrderContract) GetOrderRoute(ctx contractapi.TransactionContextInterface, orderId string) (*OrderRoute, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	route := OrderRoute{
+		OrderId:     order.OrderId,
+		Status:      order.Status,
+		Distributor: order.Distributor,
+		Checkpoints: append([]RouteCheckpoint{}, order.Checkpoints...),
+	}
+	if len(route.Checkpoints) > 0 {
+		route.LastCheckpoint = &route.Checkpoints[len(route.Checkpoints)-1]
+	}
+
+	return &route, nil
+}
@@ -0,0 +1,63 @@
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestOrderRouteCheckpoints(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+
+	addCheckpoint := func(user string, checkpointObj CheckpointForAdd) (*Order, error) {
+		checkpointObj.OrderId = order.OrderId
+		return invoke(n, user, "AddOrderCheckpoint", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+			return n.contract.AddOrderCheckpoint(ctx, checkpointObj)
+		})
+	}
+	pickup := CheckpointForAdd{Type: "PICKUP", Latitude: 21.0285, Longitude: 105.8542, FacilityId: "MFG-HN-01"}
+
+	_, err := addCheckpoint("distributor1", pickup)
+	expectError(t, err, "is not shipping")
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+
+	_, err = addCheckpoint("retailer1", pickup)
+	expectError(t, err, "is not allowed to invoke AddOrderCheckpoint")
+	_, err = addCheckpoint("distributor2", pickup)
+	expectError(t, err, "Permission denied")
+	_, err = addCheckpoint("distributor1", CheckpointForAdd{Type: "DETOUR", Latitude: 21, Longitude: 105})
+	expectError(t, err, "checkpoint type must be one of")
+	_, err = addCheckpoint("distributor1", CheckpointForAdd{Type: "PICKUP", Latitude: 91, Longitude: 105})
+	expectError(t, err, "latitude must be from -90 to 90")
+	_, err = addCheckpoint("distributor1", CheckpointForAdd{Type: "PICKUP", Latitude: 21, Longitude: -180.5})
+	expectError(t, err, "longitude must be from -180 to 180")
+	_, err = addCheckpoint("distributor1", CheckpointForAdd{Type: "HUB", Latitude: 16.0544, Longitude: 108.2022})
+	expectError(t, err, "hub checkpoints need a facility id")
+
+	_, err = addCheckpoint("distributor1", pickup)
+	expectNoError(t, err)
+	n.expectEventTypes("OrderCheckpointAdded")
+	_, err = addCheckpoint("distributor1", CheckpointForAdd{Type: "HUB", Latitude: 16.0544, Longitude: 108.2022, FacilityId: "HUB-DN-02"})
+	expectNoError(t, err)
+
+	route := mustInvoke(n, "retailer1", "GetOrderRoute", func(ctx contractapi.TransactionContextInterface) (*OrderRoute, error) {
+		return n.contract.GetOrderRoute(ctx, order.OrderId)
+	})
+	if route.Status != "SHIPPING" || route.Distributor.UserId != "distributor1" || len(route.Checkpoints) != 2 || route.Checkpoints[0].Type != "PICKUP" {
+		t.Fatalf("unexpected route %+v", route)
+	}
+	if route.LastCheckpoint == nil || route.LastCheckpoint.FacilityId != "HUB-DN-02" || route.LastCheckpoint.Latitude != 16.0544 || route.LastCheckpoint.Actor.UserId != "distributor1" {
+		t.Fatalf("unexpected last checkpoint %+v", route.LastCheckpoint)
+	}
+
+	n.mustOrderAction("distributor1", "FinishOrder", order.OrderId)
+	_, err = addCheckpoint("distributor1", CheckpointForAdd{Type: "HANDOVER", Latitude: 10.7769, Longitude: 106.7009})
+	expectError(t, err, "is not shipping")
+	if len(n.getOrder(order.OrderId).Checkpoints) != 2 {
+		t.Fatal("finishing an order must keep its checkpoints")
+	}
+}
@@ -0,0 +1,351 @@
+package chaincode
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// OrderSignature is the signature of an actor over the state an order moved
+// to. Signature is the base64 ECDSA (ASN.1) or Ed25519 signature of the
+// SHA-256 digest whose hex form is StateHash, made with the key of PublicKey.
+type OrderSignature struct {
+	Status    string `json:"status"`
+	StateHash string `json:"stateHash" metadata:",optional"`
+	Signature string `json:"signature"`
+	Algorithm string `json:"algorithm" metadata:",optional"`
+	PublicKey string `json:"publicKey" metadata:",optional"`
+	Signer    string `json:"signer" metadata:",optional"`
+	MSPId     string `json:"mspId" metadata:",optional"`
+	Time      string `json:"time" metadata:",optional"`
+}
+
+// UnmarshalJSON also reads the plain strings orders held as signatures before
+// they were verified. Those keep only Signature.
+func (o *OrderSignature) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if json.Unmarshal(data, &legacy) == nil {
+		*o = OrderSignature{Signature: legacy}
+		return nil
+	}
+
+	type orderSignature OrderSignature
+	return json.Unmarshal(data, (*orderSignature)(o))
+}
+
+// SignatureVerification reports whether a signature of an order still
+// verifies against the order and the key it was made with.
+type SignatureVerification struct {
+	Status    string `json:"status"`
+	Signer    string `json:"signer"`
+	MSPId     string `json:"mspId"`
+	Time      string `json:"time"`
+	StateHash string `json:"stateHash"`
+	Valid     bool   `json:"valid"`
+	Reason    string `json:"reason,omitempty" metadata:",optional"`
+}
+
+// orderSigningState is what a signature of an order covers: the order, its
+// items and parties, and the status it is signed into. None of it changes
+// once an order is approved, so signatures can be verified at any time later.
+// It holds no time: ids derive from the transaction id, which a retailer knows
+// before submitting CreateOrder, but the transaction time is only set then.
+type orderSigningState struct {
+	OrderId      string             `json:"orderId"`
+	Status       string             `json:"status"`
+	Retailer     string             `json:"retailer"`
+	Manufacturer string             `json:"manufacturer"`
+	Items        []orderSigningItem `json:"items"`
+}
+
+type orderSigningItem struct {
+	ProductCommercialId string `json:"productCommercialId"`
+	ProductId           string `json:"productId"`
+	Quantity            string `json:"quantity"`
+}
+
+// orderStateHash returns the hex SHA-256 digest of the canonical JSON form of
+// the signing state of an order moving to status.
+func orderStateHash(order *Order, status string) string {
+	state := orderSigningState{
+		OrderId:      order.OrderId,
+		Status:       status,
+		Retailer:     order.Retailer.UserId,
+		Manufacturer: order.Manufacturer.UserId,
+		Items:        []orderSigningItem{},
+	}
+	for _, item := range order.ProductItemList {
+		state.Items = append(state.Items, orderSigningItem{
+			ProductCommercialId: item.Product.ProductCommercialId,
+			ProductId:           item.Product.ProductId,
+			Quantity:            item.Quantity,
+		})
+	}
+
+	stateAsBytes, _ := json.Marshal(state)
+	hash := sha256.Sum256(stateAsBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+// parsePublicKey reads a PEM encoded PKIX public key, which must be an ECDSA
+// or an Ed25519 key.
+func parsePublicKey(publicKeyPem string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPem))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("public key must be a PEM encoded PUBLIC KEY")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err.Error())
+	}
+	switch publicKey.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return publicKey, nil
+	}
+	return nil, fmt.Errorf("public key must be an ECDSA or Ed25519 key")
+}
+
+// verifySignature checks a base64 signature of a hex digest and returns the
+// algorithm it was made with.
+func verifySignature(publicKey crypto.PublicKey, stateHash string, signature string) (string, error) {
+	digest, err := hex.DecodeString(stateHash)
+	if err != nil {
+		return "", fmt.Errorf("invalid state hash %s", stateHash)
+	}
+	signatureAsBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", fmt.Errorf("signature must be base64 encoded")
+	}
+
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest, signatureAsBytes) {
+			return "ECDSA", nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, digest, signatureAsBytes) {
+			return "Ed25519", nil
+		}
+	}
+	return "", fmt.Errorf("signature does not match the order state %s", stateHash)
+}
+
+// signerPublicKey returns the key signatures of the caller are verified with:
+// its registered signing key, or else the key of its certificate.
+func signerPublicKey(ctx contractapi.TransactionContextInterface, identity *Identity) (string, crypto.PublicKey, error) {
+	if identity.SigningKey != "" {
+		publicKey, err := parsePublicKey(identity.SigningKey)
+		if err != nil {
+			return "", nil, err
+		}
+		return identity.SigningKey, publicKey, nil
+	}
+
+	certificate, err := cid.GetX509Certificate(ctx.GetStub())
+	if err != nil || certificate == nil {
+		return "", nil, fmt.Errorf("failed to read client certificate")
+	}
+	publicKeyAsBytes, err := x509.MarshalPKIXPublicKey(certificate.PublicKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read client public key: %s", err.Error())
+	}
+	publicKeyPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyAsBytes}))
+	publicKey, err := parsePublicKey(publicKeyPem)
+	if err != nil {
+		return "", nil, err
+	}
+	return publicKeyPem, publicKey, nil
+}
+
+// signOrder verifies the signature of the caller over order moving to status
+// and returns it as recorded on the order.
+func signOrder(ctx contractapi.TransactionContextInterface, order *Order, status string, signature string, timestamp string) (*OrderSignature, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyPem, publicKey, err := signerPublicKey(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	stateHash := orderStateHash(order, status)
+	algorithm, err := verifySignature(publicKey, stateHash, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderSignature{
+		Status:    status,
+		StateHash: stateHash,
+		Signature: signature,
+		Algorithm: algorithm,
+		PublicKey: publicKeyPem,
+		Signer:    identity.EnrollmentId,
+		MSPId:     identity.MSPId,
+		Time:      timestamp,
+	}, nil
+}
+
+// signNewOrder verifies the signatures the retailer sent with a new order over
+// its PENDING state and returns them as recorded on the order.
+func signNewOrder(ctx contractapi.TransactionContextInterface, order *Order, signatures []string, timestamp string) ([]OrderSignature, error) {
+	orderSignatures := []OrderSignature{}
+	for _, signature := range signatures {
+		orderSignature, err := signOrder(ctx, order, "PENDING", signature, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		orderSignatures = append(orderSignatures, *orderSignature)
+	}
+	return orderSignatures, nil
+}
+
+// signingKeyChallenge returns the hex SHA-256 digest a user signs with a new
+// signing key to prove it holds the private key. It binds the key to the
+// registry record of the user.
+func signingKeyChallenge(identityId string, publicKey string) string {
+	hash := sha256.Sum256([]byte(identityId + "\x00" + publicKey))
+	return hex.EncodeToString(hash[:])
+}
+
+// RegisterSigningKey sets the PEM encoded ECDSA or Ed25519 public key order
+// signatures of the caller are verified with, instead of its certificate key.
+// signature is the base64 signature, made with the new key, of the digest
+// returned by GetSigningKeyChallenge.
+func (s *IdentityContract) RegisterSigningKey(ctx contractapi.TransactionContextInterface, publicKey string, signature string) (*Identity, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parsedKey, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	_, err = verifySignature(parsedKey, signingKeyChallenge(identity.IdentityId, publicKey), signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature does not prove possession of the signing key")
+	}
+
+	txTimeAsPtr, errTx := ledger.TxTimestamp(ctx.GetStub())
+	if errTx != nil {
+		return nil, fmt.Errorf("transaction timeStamp error")
+	}
+
+	identity.SigningKey = publicKey
+	identity.UpdateDate = txTimeAsPtr
+
+	err = putIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// GetSigningKeyChallenge returns the hex digest the caller signs with a new
+// key to register it with RegisterSigningKey.
+func (s *IdentityContract) GetSigningKeyChallenge(ctx contractapi.TransactionContextInterface, publicKey string) (string, error) {
+	identity, err := getCallerIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	return signingKeyChallenge(identity.IdentityId, publicKey), nil
+}
+
+// GetNewOrderStateHash returns the hex digest the caller signs to create an
+// order from orderObj with CreateOrder in transaction txId.
+func (s *OrderContract) GetNewOrderStateHash(ctx contractapi.TransactionContextInterface, txId string, orderObj OrderForCreate) (string, error) {
+	client, err := getClientIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	order := Order{OrderId: ledger.AssetId("Order", txId), Retailer: Actor{UserId: client.EnrollmentId}}
+	for i, item := range orderObj.ProductIdQRCodeItems {
+		product, err := getProduct(ctx, item.ProductId)
+		if err != nil {
+			return "", err
+		}
+		order.Manufacturer = productManufacturer(product)
+		order.ProductItemList = append(order.ProductItemList, ProductCommercialItem{
+			Product: ProductCommercial{
+				ProductCommercialId: ledger.AssetId("ProductCommercial", txId) + "-" + strconv.Itoa(i),
+				ProductId:           item.ProductId,
+			},
+			Quantity: item.Quantity,
+		})
+	}
+	return orderStateHash(&order, "PENDING"), nil
+}
+
+// GetOrderStateHash returns the hex digest an actor signs to move an order to
+// status with ApproveOrder (APPROVED), UpdateOrder (SHIPPING) or FinishOrder
+// (SHIPPED).
+func (s *OrderContract) GetOrderStateHash(ctx contractapi.TransactionContextInterface, orderId string, status string) (string, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return "", err
+	}
+	return orderStateHash(order, status), nil
+}
+
+// VerifyOrderSignatures checks every signature of an order against the order
+// and the key it was made with, and reports who signed which state.
+func (s *OrderContract) VerifyOrderSignatures(ctx contractapi.TransactionContextInterface, orderId string) ([]SignatureVerification, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	verifications := []SignatureVerification{}
+	for _, signature := range order.Signatures {
+		verification := SignatureVerification{
+			Status:    signature.Status,
+			Signer:    signature.Signer,
+			MSPId:     signature.MSPId,
+			Time:      signature.Time,
+			StateHash: signature.StateHash,
+		}
+
+		if signature.PublicKey == "" {
+			verification.Reason = "signature recorded without a signer key"
+			verifications = append(verifications, verification)
+			continue
+		}
+
+		stateHash := orderStateHash(order, signature.Status)
+		if stateHash != signature.StateHash {
+			verification.Reason = fmt.Sprintf("order state %s no longer matches the signed state", stateHash)
+			verifications = append(verifications, verification)
+			continue
+		}
+
+		publicKey, err := parsePublicKey(signature.PublicKey)
+		if err == nil {
+			_, err = verifySignature(publicKey, signature.StateHash, signature.Signature)
+		}
+		if err != nil {
+			verification.Reason = err.Error()
+		} else {
+			verification.Valid = true
+		}
+		verifications = append(verifications, verification)
+	}
+
+	return verifications, nil
+}
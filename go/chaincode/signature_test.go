@@ -0,0 +1,175 @@
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestOrderSignaturesAreVerified(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+	orderObj := OrderForCreate{ProductIdQRCodeItems: []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "10"}}}
+
+	sign := func(user string, hash string) string {
+		t.Helper()
+		digest, err := hex.DecodeString(hash)
+		expectNoError(t, err)
+		signature, err := ecdsa.SignASN1(rand.Reader, n.identities[user].PrivateKey, digest)
+		expectNoError(t, err)
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+	newOrderHash := func() string {
+		t.Helper()
+		createTxId := n.ledger.UpcomingTxId(2)
+		return mustInvoke(n, "retailer1", "GetNewOrderStateHash", func(ctx contractapi.TransactionContextInterface) (string, error) {
+			return n.contract.GetNewOrderStateHash(ctx, createTxId, orderObj)
+		})
+	}
+	createSigned := func(signature string) (*Order, error) {
+		t.Helper()
+		signed := orderObj
+		signed.Signatures = []string{signature}
+		return invoke(n, "retailer1", "CreateOrder", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+			return n.contract.CreateOrder(ctx, signed)
+		})
+	}
+
+	// the retailer signs a new order before submitting it: its id derives from
+	// the transaction id
+	_, err := createSigned(sign("retailer2", newOrderHash()))
+	expectError(t, err, "signature does not match the order state")
+	staleHash := newOrderHash()
+	n.harvest("supplier1", "ST24", "1")
+	_, err = createSigned(sign("retailer1", staleHash))
+	expectError(t, err, "signature does not match the order state")
+	order, err := createSigned(sign("retailer1", newOrderHash()))
+	expectNoError(t, err)
+	if len(order.Signatures) != 1 || order.Signatures[0].Status != "PENDING" || order.Signatures[0].Signer != "retailer1" {
+		t.Fatalf("unexpected signatures %+v", order.Signatures)
+	}
+
+	stateHashOf := func(status string) string {
+		t.Helper()
+		return mustInvoke(n, "distributor1", "GetOrderStateHash", func(ctx contractapi.TransactionContextInterface) (string, error) {
+			return n.contract.GetOrderStateHash(ctx, order.OrderId, status)
+		})
+	}
+	stateHash := func(status string) []byte {
+		t.Helper()
+		digest, err := hex.DecodeString(stateHashOf(status))
+		expectNoError(t, err)
+		return digest
+	}
+
+	// the manufacturer signs the approval in the transient map
+	approveSigned := func(signature string) (*Order, error) {
+		t.Helper()
+		return invokeWithTransient(n, "manufacturer1", "ApproveOrder", map[string]string{"signature": signature}, func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+			return n.contract.ApproveOrder(ctx, order.OrderId)
+		})
+	}
+	_, err = approveSigned(sign("manufacturer1", stateHashOf("PENDING")))
+	expectError(t, err, "signature does not match the order state")
+	_, err = approveSigned(sign("manufacturer1", stateHashOf("APPROVED")))
+	expectNoError(t, err)
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+
+	signedAction := func(function string, signature string) (*Order, error) {
+		t.Helper()
+		return invoke(n, "distributor1", function, func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+			orderObj := OrderForUpdateFinish{OrderId: order.OrderId, Signature: signature}
+			if function == "UpdateOrder" {
+				return n.contract.UpdateOrder(ctx, orderObj)
+			}
+			return n.contract.FinishOrder(ctx, orderObj)
+		})
+	}
+
+	// the certificate key signs the shipping state
+	certificateSignature, err := ecdsa.SignASN1(rand.Reader, n.identities["distributor1"].PrivateKey, stateHash("SHIPPING"))
+	expectNoError(t, err)
+	_, err = signedAction("UpdateOrder", base64.StdEncoding.EncodeToString(certificateSignature[:len(certificateSignature)-1]))
+	expectError(t, err, "signature does not match the order state")
+	wrongState, err := ecdsa.SignASN1(rand.Reader, n.identities["distributor1"].PrivateKey, stateHash("SHIPPED"))
+	expectNoError(t, err)
+	_, err = signedAction("UpdateOrder", base64.StdEncoding.EncodeToString(wrongState))
+	expectError(t, err, "signature does not match the order state")
+	_, err = signedAction("UpdateOrder", base64.StdEncoding.EncodeToString(certificateSignature))
+	expectNoError(t, err)
+
+	// a registered Ed25519 key signs the shipped state
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	expectNoError(t, err)
+	publicKeyAsBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	expectNoError(t, err)
+	publicKeyPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyAsBytes}))
+	registerKey := func(publicKey string, signature []byte) (*Identity, error) {
+		return invoke(n, "distributor1", "RegisterSigningKey", func(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+			return n.contract.RegisterSigningKey(ctx, publicKey, base64.StdEncoding.EncodeToString(signature))
+		})
+	}
+	challenge, err := hex.DecodeString(mustInvoke(n, "distributor1", "GetSigningKeyChallenge", func(ctx contractapi.TransactionContextInterface) (string, error) {
+		return n.contract.GetSigningKeyChallenge(ctx, publicKeyPem)
+	}))
+	expectNoError(t, err)
+	_, err = registerKey("not a key", nil)
+	expectError(t, err, "PEM encoded PUBLIC KEY")
+	_, err = registerKey(publicKeyPem, nil)
+	expectError(t, err, "does not prove possession of the signing key")
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	expectNoError(t, err)
+	_, err = registerKey(publicKeyPem, ed25519.Sign(otherKey, challenge))
+	expectError(t, err, "does not prove possession of the signing key")
+	_, err = registerKey(publicKeyPem, ed25519.Sign(privateKey, challenge))
+	expectNoError(t, err)
+
+	_, err = signedAction("FinishOrder", base64.StdEncoding.EncodeToString(certificateSignature))
+	expectError(t, err, "signature does not match the order state")
+	finished, err := signedAction("FinishOrder", base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, stateHash("SHIPPED"))))
+	expectNoError(t, err)
+	if len(finished.Signatures) != 4 || finished.Signatures[2].Algorithm != "ECDSA" || finished.Signatures[3].Algorithm != "Ed25519" || finished.Signatures[3].Signer != "distributor1" {
+		t.Fatalf("unexpected signatures %+v", finished.Signatures)
+	}
+
+	verify := func() []SignatureVerification {
+		t.Helper()
+		return mustInvoke(n, "consumer1", "VerifyOrderSignatures", func(ctx contractapi.TransactionContextInterface) ([]SignatureVerification, error) {
+			return n.contract.VerifyOrderSignatures(ctx, order.OrderId)
+		})
+	}
+	verifications := verify()
+	var statuses []string
+	for _, verification := range verifications {
+		if !verification.Valid {
+			t.Fatalf("unexpected verifications %+v", verifications)
+		}
+		statuses = append(statuses, verification.Status)
+	}
+	if strings.Join(statuses, ",") != "PENDING,APPROVED,SHIPPING,SHIPPED" {
+		t.Fatalf("unexpected signed states %v", statuses)
+	}
+
+	// an order changed behind the chaincode no longer matches its signatures,
+	// and plain strings from before signatures were verified are reported
+	var stored map[string]interface{}
+	expectNoError(t, json.Unmarshal(n.ledger.GetState(order.OrderId), &stored))
+	stored["productItemList"].([]interface{})[0].(map[string]interface{})["quantity"] = "1"
+	stored["signatures"] = append(stored["signatures"].([]interface{}), "legacy")
+	storedAsBytes, _ := json.Marshal(stored)
+	n.ledger.PutState(order.OrderId, storedAsBytes)
+
+	verifications = verify()
+	if len(verifications) != 5 || verifications[0].Valid || verifications[3].Valid || verifications[4].Valid || verifications[4].Reason != "signature recorded without a signer key" {
+		t.Fatalf("unexpected verifications %+v", verifications)
+	}
+}
@@ -0,0 +1,525 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+	"supplychain/internal/units"
+)
+
+func TestInitLedgerIsIdempotent(t *testing.T) {
+	n := newTestNetwork(t)
+
+	mustInvoke(n, "admin", "SetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.SetAccessPolicy(ctx, rolePolicy("CultivateProduct", "supplier", "manufacturer"))
+	})
+	mustInvoke(n, "admin", "InitLedger", func(ctx contractapi.TransactionContextInterface) (bool, error) {
+		return true, n.contract.InitLedger(ctx)
+	})
+
+	policy := mustInvoke(n, "admin", "GetAccessPolicy", func(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+		return n.contract.GetAccessPolicy(ctx, "CultivateProduct")
+	})
+	if len(policy.Attributes["role"]) != 2 {
+		t.Fatalf("InitLedger overwrote a stored policy: %+v", policy)
+	}
+
+	counter := mustInvoke(n, "admin", "GetCounterOfType", func(ctx contractapi.TransactionContextInterface) (int, error) {
+		return n.contract.GetCounterOfType(ctx, "ProductCounterNO")
+	})
+	if counter != 0 {
+		t.Fatalf("expected a zero counter, got %d", counter)
+	}
+
+	timestamp := mustInvoke(n, "admin", "GetTxTimestampChannel", func(ctx contractapi.TransactionContextInterface) (string, error) {
+		return n.contract.GetTxTimestampChannel(ctx)
+	})
+	if timestamp != ledger.FormatTimestamp(n.lastStub.Timestamp) {
+		t.Fatalf("unexpected timestamp %s", timestamp)
+	}
+}
+
+func TestProductLifecycle(t *testing.T) {
+	n := newTestNetwork(t)
+
+	product := mustInvoke(n, "supplier1", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductName: "Rice", ProductCode: "ST25", Amount: "1000", Unit: "kg"})
+	})
+	n.expectEventTypes("ProductCultivated")
+	if product.Status != "CULTIVATED" || product.Supplier.UserId != "supplier1" || product.ProductId != "Product-"+n.lastStub.TxId {
+		t.Fatalf("unexpected product %+v", product)
+	}
+
+	product = mustInvoke(n, "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.HarvestProduct(ctx, Product{ProductId: product.ProductId, Amount: "900"})
+	})
+	n.expectEventTypes("ProductHarvested")
+	if product.Status != "HARVESTED" || product.Stock.Available != 900*units.One {
+		t.Fatalf("unexpected product %+v", product)
+	}
+
+	updated := *product
+	updated.Description = "fragrant rice"
+	updated.Stock = &StockBalance{Available: 1e6 * units.One}
+	updated.Status = "MANUFACTURED"
+	updated.Amount = "1"
+	updated.Supplier = Actor{UserId: "supplier2"}
+	updated.Dates = nil
+	_, err := invoke(n, "supplier2", "UpdateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.UpdateProduct(ctx, updated)
+	})
+	expectError(t, err, "Permission denied")
+	product = mustInvoke(n, "supplier1", "UpdateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.UpdateProduct(ctx, updated)
+	})
+	n.expectEventTypes("ProductUpdated")
+	if product.Description != "fragrant rice" || product.Stock.Available != 900*units.One {
+		t.Fatalf("UpdateProduct must keep the stock, got %+v", product)
+	}
+	if product.Status != "HARVESTED" || product.Amount != "900" || product.Supplier.UserId != "supplier1" || len(product.Dates) != 2 {
+		t.Fatalf("UpdateProduct must only change descriptive fields, got %+v", product)
+	}
+
+	product = mustInvoke(n, "manufacturer1", "ImportProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.ImportProduct(ctx, Product{ProductId: product.ProductId})
+	})
+	n.expectEventTypes("ProductImported")
+
+	_, err = invoke(n, "manufacturer2", "ManufactureProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.ManufactureProduct(ctx, Product{ProductId: product.ProductId})
+	})
+	expectError(t, err, "Permission denied")
+
+	product = mustInvoke(n, "manufacturer1", "ManufactureProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.ManufactureProduct(ctx, Product{ProductId: product.ProductId, QRCode: "QR1", Expired: "2030-06-01"})
+	})
+	n.expectEventTypes("ProductManufactured")
+	if product.Status != "MANUFACTURED" || product.Expired != "2030-06-01T00:00:00Z" || len(product.Dates) != 4 {
+		t.Fatalf("unexpected product %+v", product)
+	}
+
+	history := mustInvoke(n, "consumer1", "GetProductTransactionHistory", func(ctx contractapi.TransactionContextInterface) ([]ProductHistory, error) {
+		return n.contract.GetProductTransactionHistory(ctx, product.ProductId)
+	})
+	if len(history) != 5 || history[0].Record.Status != "MANUFACTURED" || history[4].Record.Status != "CULTIVATED" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+}
+
+func TestProductTransitionsAreChecked(t *testing.T) {
+	n := newTestNetwork(t)
+	harvested := n.harvest("supplier1", "ST25", "100")
+	cultivated := mustInvoke(n, "supplier1", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "ST25", Amount: "100", Unit: "kg"})
+	})
+
+	tests := []struct {
+		name     string
+		user     string
+		function string
+		call     func(ctx contractapi.TransactionContextInterface) (*Product, error)
+		want     string
+	}{
+		{"harvest twice", "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.HarvestProduct(ctx, Product{ProductId: harvested.ProductId, Amount: "1"})
+		}, "cannot move from HARVESTED to HARVESTED"},
+		{"manufacture before import", "manufacturer1", "ManufactureProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.ManufactureProduct(ctx, Product{ProductId: harvested.ProductId})
+		}, "cannot move from HARVESTED to MANUFACTURED"},
+		{"unknown product", "manufacturer1", "ImportProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.ImportProduct(ctx, Product{ProductId: "Product-missing"})
+		}, "product not found"},
+		{"public price", "manufacturer1", "ImportProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.ImportProduct(ctx, Product{ProductId: harvested.ProductId, Price: "10"})
+		}, "transient map"},
+		{"invalid amount", "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.HarvestProduct(ctx, Product{ProductId: cultivated.ProductId, Amount: "lots"})
+		}, "invalid quantity"},
+		{"harvest of another supplier", "supplier2", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.HarvestProduct(ctx, Product{ProductId: cultivated.ProductId, Amount: "50"})
+		}, "Permission denied"},
+		{"harvest above cultivated amount", "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.HarvestProduct(ctx, Product{ProductId: cultivated.ProductId, Amount: "5000"})
+		}, "exceeds the cultivated amount 100"},
+		{"invalid cultivated amount", "supplier1", "CultivateProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.CultivateProduct(ctx, ProductPayload{ProductCode: "ST25", Amount: "plenty", Unit: "kg"})
+		}, "invalid quantity"},
+		{"invalid expiry", "manufacturer1", "InventoryProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+			return n.contract.InventoryProduct(ctx, Product{ProductCode: "ST25", Amount: "1", Unit: "kg", Expired: "soon"})
+		}, "invalid expiry date"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(n, test.user, test.function, test.call)
+			expectError(t, err, test.want)
+		})
+	}
+
+	_, err := invoke(n, "supplier1", "HarvestProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.HarvestProduct(ctx, Product{ProductId: harvested.ProductId, Amount: "1"})
+	})
+	var transitionError *TransitionError
+	if !errors.As(err, &transitionError) || transitionError.Current != "HARVESTED" {
+		t.Fatalf("expected a TransitionError, got %v", err)
+	}
+}
+
+func TestInventoryProduct(t *testing.T) {
+	n := newTestNetwork(t)
+
+	product := mustInvoke(n, "manufacturer1", "InventoryProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		// forged provenance naming another grower and manufacturer
+		dates := []ProductDate{
+			{Status: "CULTIVATED", Time: "2020-01-01T00:00:00.000000000Z", Actor: Actor{UserId: "supplier1", Role: "supplier"}},
+			{Status: "MANUFACTURED", Time: "2020-02-01T00:00:00.000000000Z", Actor: Actor{UserId: "manufacturer2", Role: "manufacturer"}},
+		}
+		return n.contract.InventoryProduct(ctx, Product{ProductName: "Fish sauce", ProductCode: "NM", Amount: "50", Unit: "l", Expired: "2031-01-01T10:00:00+07:00", Dates: dates})
+	})
+	n.expectEventTypes("ProductInventoried")
+	if len(product.Dates) != 1 || product.Dates[0].Status != "MANUFACTURED" || product.Dates[0].Time != ledger.FormatTimestamp(n.lastStub.Timestamp) {
+		t.Fatalf("the dates must be recorded by the contract, got %+v", product.Dates)
+	}
+
+	if product.Status != "MANUFACTURED" || product.Supplier.UserId != "manufacturer1" || product.Stock.Available != 50*units.One || product.Expired != "2031-01-01T03:00:00Z" {
+		t.Fatalf("unexpected product %+v", product)
+	}
+	if manufacturer := productManufacturer(product); manufacturer.UserId != "manufacturer1" {
+		t.Fatalf("unexpected manufacturer %+v", manufacturer)
+	}
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("1000")
+
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "100", QRCode: "QR-item"})
+	n.expectEventTypes("OrderCreated", "ProductCommercialCreated")
+	if order.Status != "PENDING" || order.Retailer.UserId != "retailer1" || order.Manufacturer.UserId != "manufacturer1" {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	item := order.ProductItemList[0]
+	if item.Product.ProductId != product.ProductId || item.Product.Status != "MANUFACTURED" || item.OrderedQuantity.Value != 100*units.One {
+		t.Fatalf("unexpected item %+v", item)
+	}
+
+	_, err := n.orderAction("manufacturer2", "ApproveOrder", order.OrderId)
+	expectError(t, err, "not allowed to approve")
+	_, err = n.orderAction("distributor1", "UpdateOrder", order.OrderId)
+	expectError(t, err, "cannot move from PENDING to SHIPPING")
+	_, err = n.assignDistributor(order.OrderId, "distributor1")
+	expectError(t, err, "a distributor is assigned to APPROVED orders")
+
+	order = n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	n.expectEventTypes("OrderApproved", "ProductExported")
+	if order.Status != "APPROVED" || order.ProductItemList[0].Product.Status != "EXPORTED" {
+		t.Fatalf("unexpected order %+v", order)
+	}
+
+	// only the assigned distributor ships the order
+	_, err = n.orderAction("distributor1", "UpdateOrder", order.OrderId)
+	expectError(t, err, "Permission denied")
+	_, err = invoke(n, "manufacturer2", "AssignDistributor", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.AssignDistributor(ctx, order.OrderId, "DistributorMSP", "distributor1")
+	})
+	expectError(t, err, "Permission denied")
+	_, err = invoke(n, "manufacturer1", "AssignDistributor", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.AssignDistributor(ctx, order.OrderId, "RetailerMSP", "retailer1")
+	})
+	expectError(t, err, "retailer1 is not an active distributor")
+	n.mustAssignDistributor(order.OrderId, "distributor2")
+	n.expectEventTypes("OrderDistributorAssigned")
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	_, err = n.orderAction("distributor2", "UpdateOrder", order.OrderId)
+	expectError(t, err, "Permission denied")
+	order = n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+	n.expectEventTypes("OrderShipping", "ProductDistributing")
+	if order.Distributor.UserId != "distributor1" {
+		t.Fatalf("unexpected distributor %+v", order.Distributor)
+	}
+
+	_, err = n.orderAction("distributor2", "FinishOrder", order.OrderId)
+	expectError(t, err, "Permission denied")
+	_, err = n.orderAction("retailer1", "CancelOrder", order.OrderId)
+	expectError(t, err, "cannot move from SHIPPING to CANCELLED")
+
+	order = n.mustOrderAction("distributor1", "FinishOrder", order.OrderId)
+	n.expectEventTypes("OrderShipped", "ProductRetailing")
+
+	_, err = n.orderAction("retailer2", "ConfirmOrderDelivery", order.OrderId)
+	expectError(t, err, "Permission denied")
+	order = n.mustOrderAction("retailer1", "ConfirmOrderDelivery", order.OrderId)
+	n.expectEventTypes("OrderDelivered")
+	if order.Status != "DELIVERED" || len(order.DeliveryStatuses) != 5 {
+		t.Fatalf("unexpected order %+v", order)
+	}
+
+	productCommercial := n.getProductCommercial(item.Product.ProductCommercialId)
+	if productCommercial.Status != "RETAILING" {
+		t.Fatalf("unexpected product commercial %+v", productCommercial)
+	}
+	productCommercial = mustInvoke(n, "retailer1", "SellProduct", func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+		return n.contract.SellProduct(ctx, ProductCommercial{ProductCommercialId: productCommercial.ProductCommercialId})
+	})
+	n.expectEventTypes("ProductSold")
+	if productCommercial.Status != "SOLD" {
+		t.Fatalf("unexpected product commercial %+v", productCommercial)
+	}
+
+	commercialHistory := mustInvoke(n, "consumer1", "GetProductCommercialTransactionHistory", func(ctx contractapi.TransactionContextInterface) ([]ProductCommercialHistory, error) {
+		return n.contract.GetProductCommercialTransactionHistory(ctx, productCommercial.ProductCommercialId)
+	})
+	if len(commercialHistory) != 5 || commercialHistory[0].Record.Status != "SOLD" {
+		t.Fatalf("unexpected history %+v", commercialHistory)
+	}
+	orderHistory := mustInvoke(n, "consumer1", "GetOrderTransactionHistory", func(ctx contractapi.TransactionContextInterface) ([]OrderHistory, error) {
+		return n.contract.GetOrderTransactionHistory(ctx, order.OrderId)
+	})
+	if len(orderHistory) != 7 || orderHistory[6].Record.Status != "PENDING" {
+		t.Fatalf("unexpected history %+v", orderHistory)
+	}
+}
+
+func TestRejectAndCancelOrder(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("1000")
+
+	rejected := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	_, err := n.orderAction("manufacturer2", "RejectOrder", rejected.OrderId)
+	expectError(t, err, "not allowed to reject")
+	rejected = n.mustOrderAction("manufacturer1", "RejectOrder", rejected.OrderId)
+	n.expectEventTypes("OrderRejected", "ProductCancelled")
+	if rejected.Status != "REJECTED" || rejected.ProductItemList[0].Product.Status != "CANCELLED" {
+		t.Fatalf("unexpected order %+v", rejected)
+	}
+	_, err = n.orderAction("manufacturer1", "ApproveOrder", rejected.OrderId)
+	expectError(t, err, "cannot move from REJECTED to APPROVED")
+
+	cancelled := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	_, err = n.orderAction("retailer2", "CancelOrder", cancelled.OrderId)
+	expectError(t, err, "not allowed to cancel")
+	cancelled = n.mustOrderAction("retailer1", "CancelOrder", cancelled.OrderId)
+	n.expectEventTypes("OrderCancelled", "ProductCancelled")
+	if cancelled.Status != "CANCELLED" {
+		t.Fatalf("unexpected order %+v", cancelled)
+	}
+
+	// the goods of an approved order go back to stock and stay behind
+	approved := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", approved.OrderId)
+	n.mustOrderAction("retailer1", "CancelOrder", approved.OrderId)
+	for _, order := range []*Order{rejected, cancelled, approved} {
+		productCommercial := n.getProductCommercial(order.ProductItemList[0].Product.ProductCommercialId)
+		if productCommercial.Status != "CANCELLED" {
+			t.Fatalf("unexpected product commercial %+v", productCommercial)
+		}
+		_, err = invoke(n, "retailer1", "SellProduct", func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+			return n.contract.SellProduct(ctx, ProductCommercial{ProductCommercialId: productCommercial.ProductCommercialId})
+		})
+		expectError(t, err, "cannot move from CANCELLED to SOLD")
+	}
+	if stock := n.getProduct(product.ProductId).Stock; stock.Available != 1000*units.One || stock.Reserved != 0 {
+		t.Fatalf("unexpected stock %+v", stock)
+	}
+
+	_, err = n.orderAction("manufacturer1", "ApproveOrder", "Order-missing")
+	expectError(t, err, "does not exist")
+}
+
+func TestCreateOrderValidatesItems(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("1000")
+	other := n.harvest("supplier2", "ST24", "100")
+	otherManufactured := n.manufacture("manufacturer2", other.ProductId, "")
+	harvested := n.harvest("supplier1", "ST25", "100")
+
+	tests := []struct {
+		name  string
+		items []ProductIdQRCodeItem
+		want  string
+	}{
+		{"no items", nil, "at least one product"},
+		{"unknown product", []ProductIdQRCodeItem{{ProductId: "Product-missing", Quantity: "1"}}, "product not found"},
+		{"not manufactured", []ProductIdQRCodeItem{{ProductId: harvested.ProductId, Quantity: "1"}}, "only MANUFACTURED products can be ordered"},
+		{"two manufacturers", []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "1"}, {ProductId: otherManufactured.ProductId, Quantity: "1"}}, "same manufacturer"},
+		{"invalid quantity", []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "plenty"}}, "invalid quantity"},
+		{"zero quantity", []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "0"}}, "must be positive"},
+		{"wrong unit", []ProductIdQRCodeItem{{ProductId: product.ProductId, Quantity: "1 l"}}, "is not in kg"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := n.createOrder("retailer1", test.items...)
+			expectError(t, err, test.want)
+		})
+	}
+
+	// an order without a manufacturer cannot be approved or rejected by anyone
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	stored := n.getOrder(order.OrderId)
+	stored.Manufacturer = Actor{}
+	storedAsBytes, _ := json.Marshal(stored)
+	n.ledger.PutState(order.OrderId, storedAsBytes)
+	for _, function := range []string{"ApproveOrder", "RejectOrder"} {
+		_, err := n.orderAction("manufacturer2", function, order.OrderId)
+		expectError(t, err, "is not allowed to")
+	}
+}
+
+func TestCommercialProductTransitions(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "100"})
+	productCommercialId := order.ProductItemList[0].Product.ProductCommercialId
+
+	callCommercial := func(user string, function string) (*ProductCommercial, error) {
+		return invoke(n, user, function, func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+			productObj := ProductCommercial{ProductCommercialId: productCommercialId}
+			switch function {
+			case "ExportProduct":
+				return n.contract.ExportProduct(ctx, productObj)
+			case "DistributeProduct":
+				return n.contract.DistributeProduct(ctx, productObj)
+			case "ImportRetailerProduct":
+				return n.contract.ImportRetailerProduct(ctx, productObj)
+			default:
+				return n.contract.SellProduct(ctx, productObj)
+			}
+		})
+	}
+
+	_, err := callCommercial("retailer1", "SellProduct")
+	expectError(t, err, "cannot move from MANUFACTURED to SOLD")
+
+	// ordered goods only move with their order, which keeps the stock
+	_, err = callCommercial("manufacturer1", "ExportProduct")
+	expectError(t, err, "moves with order "+order.OrderId)
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	_, err = callCommercial("distributor1", "DistributeProduct")
+	expectError(t, err, "moves with order "+order.OrderId)
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+	_, err = callCommercial("retailer1", "ImportRetailerProduct")
+	expectError(t, err, "moves with order "+order.OrderId)
+	n.mustOrderAction("distributor1", "FinishOrder", order.OrderId)
+
+	_, err = callCommercial("retailer2", "SellProduct")
+	expectError(t, err, "Permission denied")
+	productCommercial, err := callCommercial("retailer1", "SellProduct")
+	expectNoError(t, err)
+	if productCommercial.Status != "SOLD" {
+		t.Fatalf("expected SOLD, got %s", productCommercial.Status)
+	}
+	if stock := n.getProduct(product.ProductId).Stock; stock.Available != 0 || stock.Reserved != 0 || stock.Shipped != 100*units.One {
+		t.Fatalf("unexpected stock %+v", stock)
+	}
+
+	// the whole batch left with the first order, a second one cannot have it
+	_, err = n.createOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "100"})
+	expectError(t, err, "has 0 kg available")
+
+	// a commercial product not linked to its order yet cannot move either
+	legacy := n.getProductCommercial(productCommercialId)
+	legacy.ProductCommercialId = "ProductCommercial-legacy"
+	legacy.Status = "EXPORTED"
+	legacyAsBytes, _ := json.Marshal(legacy)
+	n.ledger.PutState(legacy.ProductCommercialId, legacyAsBytes)
+	productCommercialId = legacy.ProductCommercialId
+	_, err = callCommercial("distributor1", "DistributeProduct")
+	expectError(t, err, "run IndexLegacyAssets")
+}
+
+func TestGetAllListings(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("1000")
+	n.harvest("supplier2", "ST24", "10")
+	first := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	n.mustCreateOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", first.OrderId)
+	n.mustAssignDistributor(first.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", first.OrderId)
+
+	products := mustInvoke(n, "consumer1", "GetAllProducts", func(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+		return n.contract.GetAllProducts(ctx)
+	})
+	productsCommercial := mustInvoke(n, "consumer1", "GetAllProductsCommercial", func(ctx contractapi.TransactionContextInterface) ([]*ProductCommercial, error) {
+		return n.contract.GetAllProductsCommercial(ctx)
+	})
+	if len(products) != 2 || len(productsCommercial) != 2 {
+		t.Fatalf("expected 2 products and 2 commercial products, got %d and %d", len(products), len(productsCommercial))
+	}
+
+	tests := []struct {
+		name string
+		call func(ctx contractapi.TransactionContextInterface) ([]*Order, error)
+		want int
+	}{
+		{"all", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+			return n.contract.GetAllOrders(ctx, "")
+		}, 2},
+		{"all pending", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+			return n.contract.GetAllOrders(ctx, "PENDING")
+		}, 1},
+		{"of manufacturer", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+			return n.contract.GetAllOrdersOfManufacturer(ctx, "manufacturer1", "")
+		}, 2},
+		{"of other manufacturer", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+			return n.contract.GetAllOrdersOfManufacturer(ctx, "manufacturer2", "")
+		}, 0},
+		{"of distributor shipping", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+			return n.contract.GetAllOrdersOfDistributor(ctx, "distributor1", "SHIPPING")
+		}, 1},
+		{"of retailer", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+			return n.contract.GetAllOrdersOfRetailer(ctx, "retailer2", "")
+		}, 1},
+		{"of retailer in other status", func(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
+			return n.contract.GetAllOrdersOfRetailer(ctx, "retailer2", "SHIPPING")
+		}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			orders := mustInvoke(n, "consumer1", "GetAllOrders", test.call)
+			if len(orders) != test.want {
+				t.Fatalf("expected %d orders, got %d", test.want, len(orders))
+			}
+		})
+	}
+
+	_, err := invoke(n, "consumer1", "GetProduct", func(ctx contractapi.TransactionContextInterface) (*Product, error) {
+		return n.contract.GetProduct(ctx, "Product-missing")
+	})
+	expectError(t, err, "does not exist")
+	_, err = invoke(n, "consumer1", "GetProductCommercial", func(ctx contractapi.TransactionContextInterface) (*ProductCommercial, error) {
+		return n.contract.GetProductCommercial(ctx, "ProductCommercial-missing")
+	})
+	expectError(t, err, "does not exist")
+	_, err = invoke(n, "consumer1", "GetOrder", func(ctx contractapi.TransactionContextInterface) (*Order, error) {
+		return n.contract.GetOrder(ctx, "Order-missing")
+	})
+	expectError(t, err, "does not exist")
+}
+
+func TestOrderActionsUseStoredCommercialProducts(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("1000")
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10"})
+	productCommercialId := order.ProductItemList[0].Product.ProductCommercialId
+
+	// the stored commercial product moved on while the order still holds its
+	// MANUFACTURED copy
+	stored := n.getProductCommercial(productCommercialId)
+	stored.Status = "DISTRIBUTING"
+	storedAsBytes, _ := json.Marshal(stored)
+	n.ledger.PutState(productCommercialId, storedAsBytes)
+
+	_, err := n.orderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	expectError(t, err, "cannot move from DISTRIBUTING to EXPORTED")
+	if stored := n.getProductCommercial(productCommercialId); stored.Status != "DISTRIBUTING" || len(stored.Dates) != len(order.ProductItemList[0].Product.Dates) {
+		t.Fatalf("unexpected commercial product %+v", stored)
+	}
+}
@@ -0,0 +1,196 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"supplychain/internal/ledger"
+)
+
+// qrCodeIndex links the QR code printed on a commercial product to it and to
+// the order that created it.
+const qrCodeIndex = "qrCode~productCommercialId~orderId"
+
+// PublicActor is what consumers are shown of an actor. Phone numbers and
+// addresses are left out. Organization is the MSP the actor is registered
+// with; it is empty for consumers, recorded by pseudonym only, and for actors
+// recorded before their organization was.
+type PublicActor struct {
+	UserId       string `json:"userId"`
+	FullName     string `json:"fullName"`
+	Avatar       string `json:"avatar"`
+	Role         string `json:"role"`
+	Organization string `json:"organization"`
+}
+
+// ProvenanceStep is one status a product or its order went through.
+type ProvenanceStep struct {
+	Status string      `json:"status"`
+	Time   string      `json:"time"`
+	Actor  PublicActor `json:"actor"`
+}
+
+// TracedCertificate is what consumers are shown of a certificate of a
+// product. Valid tells whether it is in force at the time of the query.
+type TracedCertificate struct {
+	CertificateId string      `json:"certificateId"`
+	Type          string      `json:"type"`
+	StarLevel     int         `json:"starLevel,omitempty" metadata:",optional"`
+	Issuer        PublicActor `json:"issuer"`
+	ValidUntil    string      `json:"validUntil"`
+	Status        string      `json:"status"`
+	Valid         bool        `json:"valid"`
+}
+
+// ProvenanceTrace is the consumer view of a commercial product: what it is,
+// who grew, made, certified and sold it, and the timeline of the product and
+// of the order that carried it to the retailer.
+type ProvenanceTrace struct {
+	QRCode              string              `json:"qrCode"`
+	ProductCommercialId string              `json:"productCommercialId"`
+	ProductId           string              `json:"productId"`
+	ProductCode         string              `json:"productCode"`
+	ProductName         string              `json:"productName"`
+	Description         string              `json:"description"`
+	Image               []string            `json:"image"`
+	Unit                string              `json:"unit"`
+	Status              string              `json:"status"`
+	Expired             string              `json:"expireTime"`
+	CertificateUrl      string              `json:"certificateUrl"`
+	Certificates        []TracedCertificate `json:"certificates"`
+	Supplier            PublicActor         `json:"supplier"`
+	Manufacturer        PublicActor         `json:"manufacturer"`
+	Retailer            PublicActor         `json:"retailer"`
+	OrderId             string              `json:"orderId"`
+	Timeline            []ProvenanceStep    `json:"timeline"`
+	Delivery            []ProvenanceStep    `json:"delivery"`
+}
+
+func publicActor(actor Actor) PublicActor {
+	return PublicActor{
+		UserId:       actor.UserId,
+		FullName:     actor.FullName,
+		Avatar:       actor.Avatar,
+		Role:         actor.Role,
+		Organization: actor.MSPId,
+	}
+}
+
+func putQRCodeIndex(ctx contractapi.TransactionContextInterface, qrCode string, productCommercialId string, orderId string) error {
+	return ledger.PutIndex(ctx.GetStub(), qrCodeIndex, qrCode, productCommercialId, orderId)
+}
+
+// qrCodeSource returns the commercial product id and order id a QR code was
+// given to. found is false when the code was never given out.
+func qrCodeSource(ctx contractapi.TransactionContextInterface, qrCode string) (source [2]string, found bool, err error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(qrCodeIndex, []string{qrCode})
+	if err != nil {
+		return source, false, err
+	}
+	defer resultsIterator.Close()
+
+	if !resultsIterator.HasNext() {
+		return source, false, nil
+	}
+	response, err := resultsIterator.Next()
+	if err != nil {
+		return source, false, err
+	}
+
+	_, attributes, err := ctx.GetStub().SplitCompositeKey(response.Key)
+	if err != nil {
+		return source, false, err
+	}
+	if len(attributes) != 3 {
+		return source, false, fmt.Errorf("malformed index key %s", response.Key)
+	}
+	return [2]string{attributes[1], attributes[2]}, true, nil
+}
+
+// checkQRCodeUnused fails when a QR code was already given to a commercial
+// product, so that every code resolves to one product.
+func checkQRCodeUnused(ctx contractapi.TransactionContextInterface, qrCode string) error {
+	_, found, err := qrCodeSource(ctx, qrCode)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("QR code %s is already in use", qrCode)
+	}
+	return nil
+}
+
+// TraceByQRCode resolves a scanned QR code to the provenance of the commercial
+// product it was printed on. Contact details of the actors are left out.
+func (s *ProductContract) TraceByQRCode(ctx contractapi.TransactionContextInterface, qrCode string) (*ProvenanceTrace, error) {
+	source, found, err := qrCodeSource(ctx, qrCode)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("QR code %s is unknown", qrCode)
+	}
+
+	productCommercial, err := getProductCommercial(ctx, source[0])
+	if err != nil {
+		return nil, err
+	}
+	product, err := getProduct(ctx, productCommercial.ProductId)
+	if err != nil {
+		return nil, err
+	}
+	order, err := getOrder(ctx, source[1])
+	if err != nil {
+		return nil, err
+	}
+
+	trace := ProvenanceTrace{
+		QRCode:              qrCode,
+		ProductCommercialId: productCommercial.ProductCommercialId,
+		ProductId:           productCommercial.ProductId,
+		ProductCode:         productCommercial.ProductCode,
+		ProductName:         productCommercial.ProductName,
+		Description:         productCommercial.Description,
+		Image:               append([]string{}, productCommercial.Image...),
+		Unit:                productCommercial.Unit,
+		Status:              productCommercial.Status,
+		Expired:             productCommercial.Expired,
+		CertificateUrl:      productCommercial.CertificateUrl,
+		Supplier:            publicActor(product.Supplier),
+		Manufacturer:        publicActor(productManufacturer(product)),
+		Retailer:            publicActor(order.Retailer),
+		OrderId:             order.OrderId,
+		Certificates:        []TracedCertificate{},
+		Timeline:            []ProvenanceStep{},
+		Delivery:            []ProvenanceStep{},
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, certificateId := range productCommercial.CertificateIds {
+		certificate, err := getCertificate(ctx, certificateId)
+		if err != nil {
+			return nil, err
+		}
+		trace.Certificates = append(trace.Certificates, TracedCertificate{
+			CertificateId: certificate.CertificateId,
+			Type:          certificate.Type,
+			StarLevel:     certificate.StarLevel,
+			Issuer:        publicActor(certificate.Issuer),
+			ValidUntil:    certificate.ValidUntil,
+			Status:        certificate.Status,
+			Valid:         certificateInForce(certificate, now) == "",
+		})
+	}
+	for _, date := range productCommercial.Dates {
+		trace.Timeline = append(trace.Timeline, ProvenanceStep{Status: date.Status, Time: date.Time, Actor: publicActor(date.Actor)})
+	}
+	for _, delivery := range order.DeliveryStatuses {
+		trace.Delivery = append(trace.Delivery, ProvenanceStep{Status: delivery.Status, Time: delivery.DeliveryDate, Actor: publicActor(delivery.Actor)})
+	}
+
+	return &trace, nil
+}
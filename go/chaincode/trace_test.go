@@ -0,0 +1,84 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestTraceByQRCode(t *testing.T) {
+	n := newTestNetwork(t)
+	product := n.manufactured("100")
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10", QRCode: "QR-pack-1"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+	n.mustAssignDistributor(order.OrderId, "distributor1")
+	n.mustOrderAction("distributor1", "UpdateOrder", order.OrderId)
+	n.mustOrderAction("distributor1", "FinishOrder", order.OrderId)
+
+	_, err := n.createOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10", QRCode: "QR-pack-1"})
+	expectError(t, err, "QR code QR-pack-1 is already in use")
+	_, err = n.createOrder("retailer2", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1", QRCode: "QR-pack-2"}, ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "1", QRCode: "QR-pack-2"})
+	expectError(t, err, "QR code QR-pack-2 is given to several items")
+
+	trace := func(qrCode string) (*ProvenanceTrace, error) {
+		return invoke(n, "consumer1", "TraceByQRCode", func(ctx contractapi.TransactionContextInterface) (*ProvenanceTrace, error) {
+			return n.contract.TraceByQRCode(ctx, qrCode)
+		})
+	}
+
+	_, err = trace("QR-unknown")
+	expectError(t, err, "QR code QR-unknown is unknown")
+
+	provenance, err := trace("QR-pack-1")
+	expectNoError(t, err)
+	if provenance.ProductCommercialId != order.ProductItemList[0].Product.ProductCommercialId || provenance.ProductId != product.ProductId || provenance.OrderId != order.OrderId || provenance.Status != "RETAILING" {
+		t.Fatalf("unexpected trace %+v", provenance)
+	}
+	if provenance.Supplier.UserId != "supplier1" || provenance.Manufacturer.UserId != "manufacturer1" || provenance.Retailer.UserId != "retailer1" || provenance.Manufacturer.Organization != "ManufacturerMSP" {
+		t.Fatalf("unexpected actors %+v %+v %+v", provenance.Supplier, provenance.Manufacturer, provenance.Retailer)
+	}
+
+	var statuses []string
+	for _, step := range provenance.Timeline {
+		statuses = append(statuses, step.Status)
+	}
+	if strings.Join(statuses, ",") != "CULTIVATED,HARVESTED,IMPORTED,MANUFACTURED,EXPORTED,DISTRIBUTING,RETAILING" {
+		t.Fatalf("unexpected timeline %v", statuses)
+	}
+	if len(provenance.Delivery) != 4 || provenance.Delivery[3].Status != "SHIPPED" || provenance.Delivery[3].Actor.Organization != "DistributorMSP" {
+		t.Fatalf("unexpected delivery %+v", provenance.Delivery)
+	}
+
+	// no contact details reach consumers
+	provenanceAsBytes, _ := json.Marshal(provenance)
+	for _, field := range []string{"phoneNumber", "address", "0900000000", "street"} {
+		if strings.Contains(string(provenanceAsBytes), field) {
+			t.Fatalf("trace leaks %s: %s", field, provenanceAsBytes)
+		}
+	}
+}
+
+func TestTraceShowsTheRegisteredOrganization(t *testing.T) {
+	n := newTestNetwork(t)
+	// actors recorded before they carried their organization
+	legacy := n.harvest("supplier1", "ST25", "100")
+	legacy.Supplier.MSPId = ""
+	for i := range legacy.Dates {
+		legacy.Dates[i].Actor.MSPId = ""
+	}
+	legacyAsBytes, _ := json.Marshal(legacy)
+	n.ledger.PutState(legacy.ProductId, legacyAsBytes)
+
+	product := n.manufacture("manufacturer1", legacy.ProductId, "2030-01-01")
+	order := n.mustCreateOrder("retailer1", ProductIdQRCodeItem{ProductId: product.ProductId, Quantity: "10", QRCode: "QR-org-1"})
+	n.mustOrderAction("manufacturer1", "ApproveOrder", order.OrderId)
+
+	provenance := mustInvoke(n, "consumer1", "TraceByQRCode", func(ctx contractapi.TransactionContextInterface) (*ProvenanceTrace, error) {
+		return n.contract.TraceByQRCode(ctx, "QR-org-1")
+	})
+	if provenance.Supplier.Organization != "" || provenance.Manufacturer.Organization != "ManufacturerMSP" || provenance.Retailer.Organization != "RetailerMSP" {
+		t.Fatalf("unexpected actors %+v %+v %+v", provenance.Supplier, provenance.Manufacturer, provenance.Retailer)
+	}
+}
@@ -0,0 +1,30 @@
+// Package dates reads the dates clients send, as RFC 3339 times or as plain
+// dates.
+package dates
+
+import (
+	"fmt"
+	"time"
+)
+
+// dayLayout is the layout of plain dates.
+const dayLayout = "2006-01-02"
+
+// Parse reads an RFC 3339 time or a YYYY-MM-DD date, which means the start of
+// that day in UTC.
+func Parse(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(dayLayout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %s, expected RFC 3339 or YYYY-MM-DD", value)
+		}
+	}
+	return t, nil
+}
+
+// IsDay tells whether value is a plain YYYY-MM-DD date rather than a time.
+func IsDay(value string) bool {
+	_, err := time.Parse(dayLayout, value)
+	return err == nil
+}
@@ -0,0 +1,22 @@
+package fabrictest
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// NewTransactionContext returns the context contractapi passes to transaction
+// functions, set up over stub and the client identity of its creator.
+func NewTransactionContext(stub *Stub) (*contractapi.TransactionContext, error) {
+	clientIdentity, err := cid.New(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identity: %s", err.Error())
+	}
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(clientIdentity)
+	return ctx, nil
+}
@@ -0,0 +1,91 @@
+package fabrictest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/attrmgr"
+	"github.com/hyperledger/fabric-protos-go/msp"
+)
+
+// Identity is a client identity as a Fabric CA would enroll it: an X.509
+// certificate for EnrollmentId carrying Attributes in the Fabric CA attribute
+// extension, serialized with its MSP ID as proposal creator.
+type Identity struct {
+	MSPId        string
+	EnrollmentId string
+	Attributes   map[string]string
+	Certificate  *x509.Certificate
+	PrivateKey   *ecdsa.PrivateKey
+	Creator      []byte
+}
+
+// NewIdentity creates a self-signed client identity. Unless attributes set
+// it, the certificate carries hf.EnrollmentID like Fabric CA certificates do.
+func NewIdentity(mspId string, enrollmentId string, attributes map[string]string) (*Identity, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %s", err.Error())
+	}
+
+	attrs := map[string]string{"hf.EnrollmentID": enrollmentId}
+	for name, value := range attributes {
+		attrs[name] = value
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			CommonName:   enrollmentId,
+			Organization: []string{mspId},
+		},
+		NotBefore: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}
+	err = attrmgr.New().AddAttributesToCert(&attrmgr.Attributes{Attrs: attrs}, template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add attributes: %s", err.Error())
+	}
+	// x509.CreateCertificate only writes extensions listed as extra ones
+	template.ExtraExtensions = template.Extensions
+
+	certificateAsBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %s", err.Error())
+	}
+	certificate, err := x509.ParseCertificate(certificateAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %s", err.Error())
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateAsBytes}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize identity: %s", err.Error())
+	}
+
+	return &Identity{
+		MSPId:        mspId,
+		EnrollmentId: enrollmentId,
+		Attributes:   attrs,
+		Certificate:  certificate,
+		PrivateKey:   privateKey,
+		Creator:      creator,
+	}, nil
+}
+
+// CertificatePEM returns the certificate of the identity in PEM form.
+func (i *Identity) CertificatePEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.Certificate.Raw}))
+}
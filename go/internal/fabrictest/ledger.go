@@ -0,0 +1,132 @@
+// Package fabrictest runs chaincode without a Fabric network. A Ledger holds
+// committed world state, private data and key history; each transaction gets
+// its own Stub over it, which buffers writes the way a peer simulates a
+// proposal and applies them on Commit.
+package fabrictest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// DefaultChannel is the channel ID stubs report unless told otherwise.
+const DefaultChannel = "supplychain"
+
+// Ledger is the committed state shared by the stubs of successive
+// transactions. Clock is the timestamp the next transaction gets; every
+// transaction moves it one second on.
+type Ledger struct {
+	Clock time.Time
+
+	state       map[string][]byte
+	history     map[string][]*queryresult.KeyModification
+	privateData map[string]map[string][]byte
+	txCount     int
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{
+		Clock:       time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+		state:       map[string][]byte{},
+		history:     map[string][]*queryresult.KeyModification{},
+		privateData: map[string]map[string][]byte{},
+	}
+}
+
+// NewStub starts a transaction invoking function with args, proposed by the
+// client whose serialized identity is creator.
+func (l *Ledger) NewStub(creator []byte, function string, args ...string) *Stub {
+	l.txCount++
+	timestamp := l.Clock
+	l.Clock = l.Clock.Add(time.Second)
+
+	stubArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		stubArgs = append(stubArgs, []byte(arg))
+	}
+
+	return &Stub{
+		ledger:        l,
+		TxId:          txId(l.txCount),
+		ChannelId:     DefaultChannel,
+		Args:          stubArgs,
+		Creator:       creator,
+		Transient:     map[string][]byte{},
+		Timestamp:     timestamp,
+		writes:        map[string][]byte{},
+		privateWrites: map[string]map[string][]byte{},
+	}
+}
+
+// UpcomingTxId returns the ID of the transaction ahead transactions from now,
+// 1 being the next one, as a client that builds its proposal knows it.
+func (l *Ledger) UpcomingTxId(ahead int) string {
+	return txId(l.txCount + ahead)
+}
+
+func txId(count int) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("tx%d", count))))
+}
+
+// GetState reads committed world state, as chaincode outside a transaction
+// would.
+func (l *Ledger) GetState(key string) []byte {
+	return l.state[key]
+}
+
+// PutState writes world state directly, bypassing any transaction. It is meant
+// for seeding records older code would have written.
+func (l *Ledger) PutState(key string, value []byte) {
+	l.state[key] = value
+}
+
+// GetPrivateData reads a committed private record.
+func (l *Ledger) GetPrivateData(collection string, key string) []byte {
+	return l.privateData[collection][key]
+}
+
+func (l *Ledger) commit(stub *Stub) {
+	timestamp := timestamppb.New(stub.Timestamp)
+
+	for _, key := range sortedKeys(stub.writes) {
+		value := stub.writes[key]
+		if value == nil {
+			delete(l.state, key)
+		} else {
+			l.state[key] = value
+		}
+		l.history[key] = append(l.history[key], &queryresult.KeyModification{
+			TxId:      stub.TxId,
+			Value:     value,
+			Timestamp: timestamp,
+			IsDelete:  value == nil,
+		})
+	}
+
+	for collection, writes := range stub.privateWrites {
+		if l.privateData[collection] == nil {
+			l.privateData[collection] = map[string][]byte{}
+		}
+		for key, value := range writes {
+			if value == nil {
+				delete(l.privateData[collection], key)
+			} else {
+				l.privateData[collection][key] = value
+			}
+		}
+	}
+}
+
+func sortedKeys(values map[string][]byte) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,339 @@
+package fabrictest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// richQuery is the part of a CouchDB Mango query fabrictest understands.
+type richQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []interface{}          `json:"sort"`
+	Limit    int                    `json:"limit"`
+	Skip     int                    `json:"skip"`
+}
+
+type sortField struct {
+	path       string
+	descending bool
+}
+
+// runQuery evaluates a Mango query over JSON values, in key order unless the
+// query sorts. It supports the combination operators $and, $or, $nor and
+// $not and the condition operators $eq, $ne, $gt, $gte, $lt, $lte, $exists,
+// $in, $nin, $all, $size, $regex and $elemMatch. Values are compared in
+// CouchDB collation order, except that strings compare by code point.
+func runQuery(values map[string][]byte, query string) ([]*queryresult.KV, error) {
+	var q richQuery
+	err := json.Unmarshal([]byte(query), &q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %s: %s", query, err.Error())
+	}
+	if q.Selector == nil {
+		return nil, fmt.Errorf("query %s has no selector", query)
+	}
+
+	sortFields, err := parseSort(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	type document struct {
+		kv    *queryresult.KV
+		value interface{}
+	}
+	var documents []document
+	for _, key := range sortedKeys(values) {
+		var value interface{}
+		if json.Unmarshal(values[key], &value) != nil {
+			continue
+		}
+		if _, ok := value.(map[string]interface{}); !ok {
+			continue
+		}
+
+		ok, err := matches(value, true, q.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			documents = append(documents, document{kv: &queryresult.KV{Key: key, Value: values[key]}, value: value})
+		}
+	}
+
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, field := range sortFields {
+			a, _ := lookup(documents[i].value, field.path)
+			b, _ := lookup(documents[j].value, field.path)
+			c := collate(a, b)
+			if c != 0 {
+				return (c < 0) != field.descending
+			}
+		}
+		return false
+	})
+
+	if q.Skip > 0 {
+		if q.Skip > len(documents) {
+			q.Skip = len(documents)
+		}
+		documents = documents[q.Skip:]
+	}
+	if q.Limit > 0 && len(documents) > q.Limit {
+		documents = documents[:q.Limit]
+	}
+
+	kvs := make([]*queryresult.KV, 0, len(documents))
+	for _, document := range documents {
+		kvs = append(kvs, document.kv)
+	}
+	return kvs, nil
+}
+
+func parseSort(sortClause []interface{}) ([]sortField, error) {
+	var fields []sortField
+	for _, entry := range sortClause {
+		switch entry := entry.(type) {
+		case string:
+			fields = append(fields, sortField{path: entry})
+		case map[string]interface{}:
+			for path, direction := range entry {
+				switch direction {
+				case "asc":
+					fields = append(fields, sortField{path: path})
+				case "desc":
+					fields = append(fields, sortField{path: path, descending: true})
+				default:
+					return nil, fmt.Errorf("invalid sort direction %v", direction)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid sort clause %v", entry)
+		}
+	}
+	return fields, nil
+}
+
+// matches tells whether value, which may not exist, satisfies condition. An
+// object condition is a selector: its operator keys apply to value itself and
+// its other keys to fields of value.
+func matches(value interface{}, exists bool, condition interface{}) (bool, error) {
+	object, ok := condition.(map[string]interface{})
+	if !ok {
+		return exists && collate(value, condition) == 0, nil
+	}
+
+	for key, operand := range object {
+		var ok bool
+		var err error
+		if strings.HasPrefix(key, "$") {
+			ok, err = matchesOperator(value, exists, key, operand)
+		} else {
+			field, fieldExists := lookup(value, key)
+			ok, err = matches(field, fieldExists, operand)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchesOperator(value interface{}, exists bool, operator string, operand interface{}) (bool, error) {
+	switch operator {
+	case "$and", "$or", "$nor":
+		conditions, ok := operand.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s takes an array", operator)
+		}
+		matched := 0
+		for _, condition := range conditions {
+			ok, err := matches(value, exists, condition)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched++
+			}
+		}
+		switch operator {
+		case "$and":
+			return matched == len(conditions), nil
+		case "$or":
+			return matched > 0, nil
+		default:
+			return matched == 0, nil
+		}
+	case "$not":
+		ok, err := matches(value, exists, operand)
+		return !ok, err
+	case "$exists":
+		want, ok := operand.(bool)
+		if !ok {
+			return false, fmt.Errorf("$exists takes a boolean")
+		}
+		return exists == want, nil
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	switch operator {
+	case "$eq":
+		return collate(value, operand) == 0, nil
+	case "$ne":
+		return collate(value, operand) != 0, nil
+	case "$gt":
+		return collate(value, operand) > 0, nil
+	case "$gte":
+		return collate(value, operand) >= 0, nil
+	case "$lt":
+		return collate(value, operand) < 0, nil
+	case "$lte":
+		return collate(value, operand) <= 0, nil
+	case "$in", "$nin":
+		candidates, ok := operand.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s takes an array", operator)
+		}
+		found := false
+		for _, candidate := range candidates {
+			if collate(value, candidate) == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (operator == "$in"), nil
+	case "$all":
+		wanted, ok := operand.([]interface{})
+		elements, isArray := value.([]interface{})
+		if !ok || !isArray {
+			return false, nil
+		}
+		for _, w := range wanted {
+			found := false
+			for _, element := range elements {
+				if collate(element, w) == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$size":
+		elements, isArray := value.([]interface{})
+		size, ok := operand.(float64)
+		return isArray && ok && float64(len(elements)) == size, nil
+	case "$regex":
+		pattern, ok := operand.(string)
+		text, isString := value.(string)
+		if !ok {
+			return false, fmt.Errorf("$regex takes a string")
+		}
+		if !isString {
+			return false, nil
+		}
+		return regexp.MatchString(pattern, text)
+	case "$elemMatch":
+		elements, isArray := value.([]interface{})
+		if !isArray {
+			return false, nil
+		}
+		for _, element := range elements {
+			ok, err := matches(element, true, operand)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	return false, fmt.Errorf("operator %s is not supported by fabrictest", operator)
+}
+
+// lookup follows a dotted field path into a JSON object.
+func lookup(value interface{}, path string) (interface{}, bool) {
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func collationRank(value interface{}) int {
+	switch value := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		if value {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// collate orders JSON values as CouchDB views do: null, false, true, numbers,
+// strings, arrays, objects.
+func collate(a interface{}, b interface{}) int {
+	rankA, rankB := collationRank(a), collationRank(b)
+	if rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := collate(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	case map[string]interface{}:
+		if reflect.DeepEqual(a, b) {
+			return 0
+		}
+		aAsBytes, _ := json.Marshal(a)
+		bAsBytes, _ := json.Marshal(b)
+		return strings.Compare(string(aAsBytes), string(bAsBytes))
+	}
+	return 0
+}
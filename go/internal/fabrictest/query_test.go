@@ -0,0 +1,90 @@
+package fabrictest
+
+import (
+	"strings"
+	"testing"
+)
+
+var queryDocuments = map[string][]byte{
+	"a": []byte(`{"status":"CULTIVATED","amount":5,"supplier":{"userId":"s1"},"dates":[{"status":"CULTIVATED","time":"2023-01-02"}]}`),
+	"b": []byte(`{"status":"HARVESTED","amount":10,"supplier":{"userId":"s2"},"dates":[{"status":"CULTIVATED","time":"2023-01-05"}]}`),
+	"c": []byte(`{"status":"HARVESTED","amount":1,"supplier":{"userId":"s1"}}`),
+	"d": []byte(`{"orderId":"d","status":"PENDING"}`),
+	"e": {0x00},
+}
+
+func TestRunQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		err   string
+	}{
+		{"equality", `{"selector":{"status":"HARVESTED"}}`, "b,c", ""},
+		{"dotted field", `{"selector":{"supplier.userId":"s1"}}`, "a,c", ""},
+		{"nested field", `{"selector":{"supplier":{"userId":"s2"}}}`, "b", ""},
+		{"exists", `{"selector":{"orderId":{"$exists":true}}}`, "d", ""},
+		{"not exists", `{"selector":{"orderId":{"$exists":false}}}`, "a,b,c", ""},
+		{"range", `{"selector":{"amount":{"$gt":1,"$lte":10}}}`, "a,b", ""},
+		{"greater than null", `{"selector":{"amount":{"$gt":null}}}`, "a,b,c", ""},
+		{"in", `{"selector":{"status":{"$in":["PENDING","CULTIVATED"]}}}`, "a,d", ""},
+		{"not in", `{"selector":{"supplier":{"$exists":true},"status":{"$nin":["CULTIVATED"]}}}`, "b,c", ""},
+		{"or", `{"selector":{"$or":[{"amount":1},{"orderId":"d"}]}}`, "c,d", ""},
+		{"elemMatch", `{"selector":{"dates":{"$elemMatch":{"status":"CULTIVATED","time":{"$gte":"2023-01-03"}}}}}`, "b", ""},
+		{"sort descending", `{"selector":{"amount":{"$gt":0}},"sort":[{"amount":"desc"}]}`, "b,a,c", ""},
+		{"limit and skip", `{"selector":{"amount":{"$gt":0}},"sort":["amount"],"skip":1,"limit":1}`, "a", ""},
+		{"regex", `{"selector":{"status":{"$regex":"^HARV"}}}`, "b,c", ""},
+		{"unsupported operator", `{"selector":{"amount":{"$mod":[2,0]}}}`, "", "not supported"},
+		{"no selector", `{}`, "", "no selector"},
+		{"invalid json", `{`, "", "invalid query"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kvs, err := runQuery(queryDocuments, test.query)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var keys []string
+			for _, kv := range kvs {
+				keys = append(keys, kv.Key)
+			}
+			if got := strings.Join(keys, ","); got != test.want {
+				t.Fatalf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryResultWithPagination(t *testing.T) {
+	ledger := NewLedger()
+	for key, value := range queryDocuments {
+		ledger.PutState(key, value)
+	}
+	stub := ledger.NewStub(nil, "Query")
+	query := `{"selector":{"amount":{"$gt":0}},"sort":["amount"]}`
+
+	iterator, metadata, err := stub.GetQueryResultWithPagination(query, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := collectKeys(t, iterator); strings.Join(keys, ",") != "c,a" || metadata.Bookmark == "" {
+		t.Fatalf("unexpected first page %q %v", keys, metadata)
+	}
+
+	iterator, metadata, _ = stub.GetQueryResultWithPagination(query, 2, metadata.Bookmark)
+	if keys := collectKeys(t, iterator); strings.Join(keys, ",") != "b" || metadata.Bookmark != "" {
+		t.Fatalf("unexpected last page %q %v", keys, metadata)
+	}
+
+	if _, _, err := stub.GetQueryResultWithPagination(query, 2, "x"); err == nil {
+		t.Fatal("expected an error for an invalid bookmark")
+	}
+}
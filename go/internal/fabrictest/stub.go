@@ -0,0 +1,456 @@
+package fabrictest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	emptyKeySubstitute    = "\x01"
+	minUnicodeRuneValue   = 0
+	maxUnicodeRuneValue   = utf8.MaxRune
+)
+
+// Stub is an in-memory shim.ChaincodeStubInterface for one transaction. Reads
+// see the committed ledger only, never the transaction's own writes, as on a
+// peer. Writes are applied to the ledger by Commit.
+type Stub struct {
+	TxId      string
+	ChannelId string
+	Args      [][]byte
+	Creator   []byte
+	Transient map[string][]byte
+	Timestamp time.Time
+
+	// EventName and EventPayload hold the event set by the transaction. As on
+	// a peer, only the last call to SetEvent is kept.
+	EventName    string
+	EventPayload []byte
+
+	ledger              *Ledger
+	writes              map[string][]byte
+	privateWrites       map[string]map[string][]byte
+	validationParameter map[string][]byte
+}
+
+var _ shim.ChaincodeStubInterface = (*Stub)(nil)
+
+// Commit applies the writes of the transaction to the ledger.
+func (s *Stub) Commit() {
+	s.ledger.commit(s)
+}
+
+func (s *Stub) GetArgs() [][]byte {
+	return s.Args
+}
+
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.Args))
+	for _, arg := range s.Args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *Stub) GetArgsSlice() ([]byte, error) {
+	var slice []byte
+	for _, arg := range s.Args {
+		slice = append(slice, arg...)
+	}
+	return slice, nil
+}
+
+func (s *Stub) GetTxID() string {
+	return s.TxId
+}
+
+func (s *Stub) GetChannelID() string {
+	return s.ChannelId
+}
+
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	return shim.Error("chaincode to chaincode calls are not supported by fabrictest")
+}
+
+func (s *Stub) GetState(key string) ([]byte, error) {
+	return s.ledger.state[key], nil
+}
+
+// PutState writes value to key on commit. As on a peer, a nil or empty value
+// deletes the key.
+func (s *Stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if len(value) == 0 {
+		return s.DelState(key)
+	}
+	s.writes[key] = value
+	return nil
+}
+
+func (s *Stub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+func (s *Stub) SetStateValidationParameter(key string, ep []byte) error {
+	if s.validationParameter == nil {
+		s.validationParameter = map[string][]byte{}
+	}
+	s.validationParameter[key] = ep
+	return nil
+}
+
+func (s *Stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return s.validationParameter[key], nil
+}
+
+func (s *Stub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return newKVIterator(rangeOf(s.ledger.state, startKey, endKey)), nil
+}
+
+func (s *Stub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	kvs, metadata := pageOfRange(rangeOf(s.ledger.state, startKey, endKey), pageSize)
+	return newKVIterator(kvs), metadata, nil
+}
+
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newKVIterator(rangeOf(s.ledger.state, startKey, endKey)), nil
+}
+
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	kvs, metadata := pageOfRange(rangeOf(s.ledger.state, startKey, endKey), pageSize)
+	return newKVIterator(kvs), metadata, nil
+}
+
+func (s *Stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return CreateCompositeKey(objectType, attributes)
+}
+
+func (s *Stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	return SplitCompositeKey(compositeKey)
+}
+
+func (s *Stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	kvs, err := runQuery(s.ledger.state, query)
+	if err != nil {
+		return nil, err
+	}
+	return newKVIterator(kvs), nil
+}
+
+// GetQueryResultWithPagination pages through a query. Bookmarks are offsets
+// into the result, which is enough for a ledger nobody writes to meanwhile.
+func (s *Stub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	kvs, err := runQuery(s.ledger.state, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offset := 0
+	if bookmark != "" {
+		offset, err = strconv.Atoi(bookmark)
+		if err != nil || offset < 0 {
+			return nil, nil, fmt.Errorf("invalid bookmark %s", bookmark)
+		}
+	}
+	if offset > len(kvs) {
+		offset = len(kvs)
+	}
+	kvs = kvs[offset:]
+
+	metadata := &peer.QueryResponseMetadata{}
+	if pageSize > 0 && len(kvs) > int(pageSize) {
+		kvs = kvs[:pageSize]
+		metadata.Bookmark = strconv.Itoa(offset + int(pageSize))
+	}
+	metadata.FetchedRecordsCount = int32(len(kvs))
+	return newKVIterator(kvs), metadata, nil
+}
+
+// GetHistoryForKey returns the committed changes of a key, newest first.
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	modifications := s.ledger.history[key]
+	reversed := make([]*queryresult.KeyModification, 0, len(modifications))
+	for i := len(modifications) - 1; i >= 0; i-- {
+		reversed = append(reversed, modifications[i])
+	}
+	return &historyIterator{modifications: reversed}, nil
+}
+
+func (s *Stub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return s.ledger.privateData[collection][key], nil
+}
+
+func (s *Stub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value := s.ledger.privateData[collection][key]
+	if value == nil {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+// PutPrivateData writes value to key of collection on commit. Like PutState,
+// a nil or empty value deletes the key.
+func (s *Stub) PutPrivateData(collection string, key string, value []byte) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if len(value) == 0 {
+		return s.DelPrivateData(collection, key)
+	}
+	if s.privateWrites[collection] == nil {
+		s.privateWrites[collection] = map[string][]byte{}
+	}
+	s.privateWrites[collection][key] = value
+	return nil
+}
+
+func (s *Stub) DelPrivateData(collection string, key string) error {
+	if s.privateWrites[collection] == nil {
+		s.privateWrites[collection] = map[string][]byte{}
+	}
+	s.privateWrites[collection][key] = nil
+	return nil
+}
+
+func (s *Stub) PurgePrivateData(collection string, key string) error {
+	return s.DelPrivateData(collection, key)
+}
+
+func (s *Stub) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+	return s.SetStateValidationParameter(collection+compositeKeyNamespace+key, ep)
+}
+
+func (s *Stub) GetPrivateDataValidationParameter(collection string, key string) ([]byte, error) {
+	return s.GetStateValidationParameter(collection + compositeKeyNamespace + key)
+}
+
+func (s *Stub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return newKVIterator(rangeOf(s.ledger.privateData[collection], startKey, endKey)), nil
+}
+
+func (s *Stub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newKVIterator(rangeOf(s.ledger.privateData[collection], startKey, endKey)), nil
+}
+
+func (s *Stub) GetPrivateDataQueryResult(collection string, query string) (shim.StateQueryIteratorInterface, error) {
+	kvs, err := runQuery(s.ledger.privateData[collection], query)
+	if err != nil {
+		return nil, err
+	}
+	return newKVIterator(kvs), nil
+}
+
+func (s *Stub) GetCreator() ([]byte, error) {
+	return s.Creator, nil
+}
+
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	return s.Transient, nil
+}
+
+func (s *Stub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Stub) GetDecorations() map[string][]byte {
+	return map[string][]byte{}
+}
+
+func (s *Stub) GetSignedProposal() (*peer.SignedProposal, error) {
+	return nil, fmt.Errorf("signed proposals are not supported by fabrictest")
+}
+
+func (s *Stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return timestamppb.New(s.Timestamp), nil
+}
+
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return fmt.Errorf("event name can not be empty string")
+	}
+	s.EventName = name
+	s.EventPayload = payload
+	return nil
+}
+
+// CreateCompositeKey builds composite keys the way the shim does.
+func CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	compositeKey := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
+	for _, attribute := range attributes {
+		if err := validateCompositeKeyAttribute(attribute); err != nil {
+			return "", err
+		}
+		compositeKey += attribute + string(rune(minUnicodeRuneValue))
+	}
+	return compositeKey, nil
+}
+
+// SplitCompositeKey splits composite keys the way the shim does.
+func SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+
+	componentIndex := 1
+	var components []string
+	for i := 1; i < len(compositeKey); i++ {
+		if compositeKey[i] == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+	return components[0], components[1:], nil
+}
+
+func validateCompositeKeyAttribute(attribute string) error {
+	if !utf8.ValidString(attribute) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", attribute)
+	}
+	for _, r := range attribute {
+		if r == minUnicodeRuneValue || r == maxUnicodeRuneValue {
+			return fmt.Errorf("input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key",
+				r, strings.IndexRune(attribute, r), minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+func partialCompositeKeyRange(objectType string, keys []string) (string, string, error) {
+	partialKey, err := CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return "", "", err
+	}
+	return partialKey, partialKey + string(rune(maxUnicodeRuneValue)), nil
+}
+
+// rangeOf returns the records with startKey <= key < endKey in key order. An
+// empty endKey leaves the range open.
+func rangeOf(values map[string][]byte, startKey string, endKey string) []*queryresult.KV {
+	var kvs []*queryresult.KV
+	for key, value := range values {
+		if key < startKey || (endKey != "" && key >= endKey) {
+			continue
+		}
+		kvs = append(kvs, &queryresult.KV{Key: key, Value: value})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
+
+// pageOfRange cuts a page out of a key range. The bookmark is the key the next
+// page starts at, and is empty on the last page.
+func pageOfRange(kvs []*queryresult.KV, pageSize int32) ([]*queryresult.KV, *peer.QueryResponseMetadata) {
+	metadata := &peer.QueryResponseMetadata{}
+	if pageSize > 0 && len(kvs) > int(pageSize) {
+		metadata.Bookmark = kvs[pageSize].Key
+		kvs = kvs[:pageSize]
+	}
+	metadata.FetchedRecordsCount = int32(len(kvs))
+	return kvs, metadata
+}
+
+type kvIterator struct {
+	kvs []*queryresult.KV
+}
+
+func newKVIterator(kvs []*queryresult.KV) *kvIterator {
+	return &kvIterator{kvs: kvs}
+}
+
+func (it *kvIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *kvIterator) Close() error {
+	return nil
+}
+
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool {
+	return len(it.modifications) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if len(it.modifications) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return modification, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,250 @@
+package fabrictest
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func newTestIdentity(t *testing.T) *Identity {
+	t.Helper()
+	identity, err := NewIdentity("SupplierMSP", "supplier1", map[string]string{"role": "supplier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func collectKeys(t *testing.T, iterator shim.StateQueryIteratorInterface) []string {
+	t.Helper()
+	defer iterator.Close()
+
+	var keys []string
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestStubWritesAreVisibleAfterCommit(t *testing.T) {
+	ledger := NewLedger()
+	stub := ledger.NewStub(nil, "Put")
+
+	if err := stub.PutState("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := stub.GetState("a"); value != nil {
+		t.Fatalf("transaction read its own write %q", value)
+	}
+
+	stub.Commit()
+	next := ledger.NewStub(nil, "Get")
+	if value, _ := next.GetState("a"); string(value) != "1" {
+		t.Fatalf("expected committed value 1, got %q", value)
+	}
+
+	if err := next.PutState("", []byte("1")); err == nil {
+		t.Fatal("expected an error for an empty key")
+	}
+}
+
+func TestEmptyValuesDeleteKeys(t *testing.T) {
+	ledger := NewLedger()
+	stub := ledger.NewStub(nil, "Put")
+	stub.PutState("a", []byte("1"))
+	stub.PutState("b", []byte("2"))
+	stub.PutPrivateData("collection", "c", []byte("3"))
+	stub.Commit()
+
+	stub = ledger.NewStub(nil, "Clear")
+	stub.PutState("a", nil)
+	stub.PutState("b", []byte{})
+	stub.PutPrivateData("collection", "c", []byte{})
+	stub.Commit()
+
+	next := ledger.NewStub(nil, "Get")
+	for _, key := range []string{"a", "b"} {
+		if value, _ := next.GetState(key); value != nil {
+			t.Fatalf("expected %s to be deleted, got %q", key, value)
+		}
+		history, err := next.GetHistoryForKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modification, err := history.Next()
+		if err != nil || !modification.IsDelete {
+			t.Fatalf("expected the last write of %s to be a delete, got %+v", key, modification)
+		}
+	}
+	if value, _ := next.GetPrivateData("collection", "c"); value != nil {
+		t.Fatalf("expected private key c to be deleted, got %q", value)
+	}
+}
+
+func TestUpcomingTxIdIsKnownInAdvance(t *testing.T) {
+	ledger := NewLedger()
+	second := ledger.UpcomingTxId(2)
+	first := ledger.UpcomingTxId(1)
+
+	if stub := ledger.NewStub(nil, "First"); stub.TxId != first {
+		t.Fatalf("expected tx ID %s, got %s", first, stub.TxId)
+	}
+	if stub := ledger.NewStub(nil, "Second"); stub.TxId != second || second == first {
+		t.Fatalf("expected tx ID %s, got %s", second, stub.TxId)
+	}
+}
+
+func TestStubHistoryIsNewestFirst(t *testing.T) {
+	ledger := NewLedger()
+	for _, value := range []string{"1", "2"} {
+		stub := ledger.NewStub(nil, "Put")
+		_ = stub.PutState("a", []byte(value))
+		stub.Commit()
+	}
+	stub := ledger.NewStub(nil, "Delete")
+	_ = stub.DelState("a")
+	stub.Commit()
+
+	iterator, _ := ledger.NewStub(nil, "History").GetHistoryForKey("a")
+	var values []string
+	var deletes []bool
+	for iterator.HasNext() {
+		modification, _ := iterator.Next()
+		values = append(values, string(modification.Value))
+		deletes = append(deletes, modification.IsDelete)
+	}
+
+	if len(values) != 3 || values[1] != "2" || values[2] != "1" || !deletes[0] || deletes[1] {
+		t.Fatalf("unexpected history %v %v", values, deletes)
+	}
+	if value := ledger.GetState("a"); value != nil {
+		t.Fatalf("expected a to be deleted, got %q", value)
+	}
+}
+
+func TestStubRangesAndCompositeKeys(t *testing.T) {
+	ledger := NewLedger()
+	stub := ledger.NewStub(nil, "Put")
+	for _, key := range []string{"Product-1", "Product-2", "Product-3", "Order-1"} {
+		_ = stub.PutState(key, []byte("{}"))
+		indexKey, err := stub.CreateCompositeKey("type~id", []string{key[:len(key)-2], key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = stub.PutState(indexKey, []byte{0x00})
+	}
+	stub.Commit()
+
+	stub = ledger.NewStub(nil, "Get")
+
+	iterator, _ := stub.GetStateByRange("", "")
+	if keys := collectKeys(t, iterator); len(keys) != 4 {
+		t.Fatalf("open range must skip composite keys, got %q", keys)
+	}
+
+	iterator, _ = stub.GetStateByRange("Product-", "Product.")
+	if keys := collectKeys(t, iterator); len(keys) != 3 || keys[0] != "Product-1" {
+		t.Fatalf("unexpected range %q", keys)
+	}
+
+	iterator, _ = stub.GetStateByPartialCompositeKey("type~id", []string{"Product"})
+	keys := collectKeys(t, iterator)
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 composite keys, got %q", keys)
+	}
+	objectType, attributes, err := stub.SplitCompositeKey(keys[0])
+	if err != nil || objectType != "type~id" || len(attributes) != 2 || attributes[1] != "Product-1" {
+		t.Fatalf("unexpected split %s %q %v", objectType, attributes, err)
+	}
+
+	iterator, metadata, _ := stub.GetStateByPartialCompositeKeyWithPagination("type~id", []string{"Product"}, 2, "")
+	if keys := collectKeys(t, iterator); len(keys) != 2 || metadata.FetchedRecordsCount != 2 || metadata.Bookmark == "" {
+		t.Fatalf("unexpected first page %q %v", keys, metadata)
+	}
+	iterator, metadata, _ = stub.GetStateByPartialCompositeKeyWithPagination("type~id", []string{"Product"}, 2, metadata.Bookmark)
+	if keys := collectKeys(t, iterator); len(keys) != 1 || metadata.Bookmark != "" {
+		t.Fatalf("unexpected last page %q %v", keys, metadata)
+	}
+
+	if _, err := stub.CreateCompositeKey("type", []string{"a\x00b"}); err == nil {
+		t.Fatal("expected an error for an attribute holding U+0000")
+	}
+}
+
+func TestStubPrivateDataTransientAndEvents(t *testing.T) {
+	ledger := NewLedger()
+	stub := ledger.NewStub(nil, "Put")
+	stub.Transient["price"] = []byte("100")
+
+	transient, _ := stub.GetTransient()
+	_ = stub.PutPrivateData("collection", "a", transient["price"])
+	_ = stub.SetEvent("first", []byte("1"))
+	_ = stub.SetEvent("second", []byte("2"))
+	if stub.EventName != "second" || string(stub.EventPayload) != "2" {
+		t.Fatalf("expected only the last event to be kept, got %s", stub.EventName)
+	}
+	if err := stub.SetEvent("", nil); err == nil {
+		t.Fatal("expected an error for an empty event name")
+	}
+	if value, _ := stub.GetPrivateData("collection", "a"); value != nil {
+		t.Fatal("transaction read its own private write")
+	}
+	stub.Commit()
+
+	stub = ledger.NewStub(nil, "Get")
+	if value, _ := stub.GetPrivateData("collection", "a"); string(value) != "100" {
+		t.Fatalf("expected private value 100, got %q", value)
+	}
+	if hash, _ := stub.GetPrivateDataHash("collection", "a"); len(hash) != 32 {
+		t.Fatalf("expected a SHA-256 hash, got %x", hash)
+	}
+}
+
+func TestStubTimestampsAndTxIds(t *testing.T) {
+	ledger := NewLedger()
+	first := ledger.NewStub(nil, "A", "x")
+	second := ledger.NewStub(nil, "B")
+
+	if first.GetTxID() == second.GetTxID() {
+		t.Fatal("transactions share an ID")
+	}
+	firstTime, _ := first.GetTxTimestamp()
+	secondTime, _ := second.GetTxTimestamp()
+	if secondTime.Seconds != firstTime.Seconds+1 {
+		t.Fatalf("expected the clock to move one second, got %v then %v", firstTime, secondTime)
+	}
+
+	function, params := first.GetFunctionAndParameters()
+	if function != "A" || len(params) != 1 || params[0] != "x" {
+		t.Fatalf("unexpected function %s %q", function, params)
+	}
+}
+
+func TestIdentityIsReadByCid(t *testing.T) {
+	identity := newTestIdentity(t)
+	stub := NewLedger().NewStub(identity.Creator, "Get")
+
+	mspId, err := cid.GetMSPID(stub)
+	if err != nil || mspId != "SupplierMSP" {
+		t.Fatalf("unexpected MSP ID %s %v", mspId, err)
+	}
+	for name, want := range map[string]string{"role": "supplier", "hf.EnrollmentID": "supplier1"} {
+		value, found, err := cid.GetAttributeValue(stub, name)
+		if err != nil || !found || value != want {
+			t.Fatalf("unexpected attribute %s=%s %v %v", name, value, found, err)
+		}
+	}
+
+	ctx, err := NewTransactionContext(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.GetStub() != stub || ctx.GetClientIdentity() == nil {
+		t.Fatal("context is not set up over the stub")
+	}
+}
@@ -0,0 +1,110 @@
+// Package ledger holds the world state plumbing shared by the supply chain
+// contracts: asset keys, composite-key indexes, transaction times and hashes
+// of private data.
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// NewAssetId derives the key of a new asset from the transaction ID, so that
+// creates on different assets never read or write a shared key.
+func NewAssetId(stub shim.ChaincodeStubInterface, prefix string) string {
+	return AssetId(prefix, stub.GetTxID())
+}
+
+// AssetId is the key NewAssetId gives a new asset in transaction txId. Clients
+// know it before they submit the transaction.
+func AssetId(prefix string, txId string) string {
+	return prefix + "-" + txId
+}
+
+// AssetIdRange returns the key range holding every asset created by NewAssetId
+// with the given prefix. '.' is the character right after '-'.
+func AssetIdRange(prefix string) [2]string {
+	return [2]string{prefix + "-", prefix + "."}
+}
+
+// PutIndex writes a composite-key index entry. The asset key the entry points
+// to is its last attribute.
+func PutIndex(stub shim.ChaincodeStubInterface, objectType string, attributes ...string) error {
+	indexKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to create index key: %s", err.Error())
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+// GetIndexedAsset reads the asset an index entry points to.
+func GetIndexedAsset(stub shim.ChaincodeStubInterface, indexKey string) ([]byte, error) {
+	_, attributes, err := stub.SplitCompositeKey(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(attributes) == 0 {
+		return nil, fmt.Errorf("malformed index key %s", indexKey)
+	}
+
+	assetAsBytes, err := stub.GetState(attributes[len(attributes)-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state. %s", err.Error())
+	}
+	if assetAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", attributes[len(attributes)-1])
+	}
+	return assetAsBytes, nil
+}
+
+// TxTime returns the transaction timestamp, which every endorser agrees on.
+func TxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimeAsPtr, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimeAsPtr.Seconds, int64(txTimeAsPtr.Nanos)), nil
+}
+
+// TimestampLayout is the format asset dates are recorded in: RFC 3339 in UTC
+// with a fixed number of decimals, so that dates sort as strings.
+const TimestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// legacyTimestampLayout is the format dates were recorded in before, the
+// peer's local time as written by time.Time.String.
+const legacyTimestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// TxTimestamp returns the transaction timestamp in the format asset dates are
+// recorded in.
+func TxTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	txTime, err := TxTime(stub)
+	if err != nil {
+		return "Error", err
+	}
+	return FormatTimestamp(txTime), nil
+}
+
+// FormatTimestamp writes t the way asset dates are recorded.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampLayout)
+}
+
+// ParseTxTimestamp reads back a date recorded by TxTimestamp, or in the
+// format dates were recorded in before.
+func ParseTxTimestamp(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(legacyTimestampLayout, value)
+	}
+	return t, err
+}
+
+// HashPrivateData returns the hex SHA-256 hash kept on a public record for its
+// private counterpart.
+func HashPrivateData(dataAsBytes []byte) string {
+	hash := sha256.Sum256(dataAsBytes)
+	return hex.EncodeToString(hash[:])
+}
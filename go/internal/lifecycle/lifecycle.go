@@ -0,0 +1,38 @@
+// Package lifecycle checks status changes of assets against transition tables.
+package lifecycle
+
+import (
+	"fmt"
+)
+
+// Transitions lists, for each status, the statuses it may move to. A status
+// with no entry, or an empty one, leads nowhere.
+type Transitions map[string][]string
+
+// TransitionError is returned when an asset is asked to move to a status its
+// current status does not lead to.
+type TransitionError struct {
+	AssetType string
+	AssetId   string
+	Current   string
+	Attempted string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s %s cannot move from %s to %s", e.AssetType, e.AssetId, e.Current, e.Attempted)
+}
+
+// Check returns a TransitionError unless current leads to attempted.
+func (t Transitions) Check(assetType string, assetId string, current string, attempted string) error {
+	for _, status := range t[current] {
+		if status == attempted {
+			return nil
+		}
+	}
+	return &TransitionError{
+		AssetType: assetType,
+		AssetId:   assetId,
+		Current:   current,
+		Attempted: attempted,
+	}
+}
@@ -0,0 +1,69 @@
+// Package units reads and writes the quantities clients send as text.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity in thousandths of its unit. Stock is kept in whole
+// thousandths so that adding and taking amounts is exact: 12.5 kg is 12500.
+type Amount int64
+
+// One is a whole unit.
+const One Amount = 1000
+
+// maxWholeDigits keeps every parsed amount within an int64 of thousandths.
+const maxWholeDigits = 15
+
+// String writes the amount in units, the way clients send it.
+func (a Amount) String() string {
+	return FormatQuantity(a)
+}
+
+// ParseQuantity reads amounts such as "100", "12.5" or "100 kg", with at most
+// three decimals. A unit, when given, must be unit.
+func ParseQuantity(amount string, unit string) (Amount, error) {
+	fields := strings.Fields(amount)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, fmt.Errorf("invalid quantity %q", amount)
+	}
+	if len(fields) == 2 && !strings.EqualFold(fields[1], unit) {
+		return 0, fmt.Errorf("quantity %q is not in %s", amount, unit)
+	}
+
+	whole, fraction, _ := strings.Cut(fields[0], ".")
+	if whole == "" || len(whole) > maxWholeDigits || len(fraction) > 3 || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("invalid quantity %q", amount)
+	}
+	if strings.Contains(fields[0], ".") && fraction == "" {
+		return 0, fmt.Errorf("invalid quantity %q", amount)
+	}
+
+	wholeValue, _ := strconv.ParseInt(whole, 10, 64)
+	fractionValue, _ := strconv.ParseInt((fraction + "000")[:3], 10, 64)
+	return Amount(wholeValue)*One + Amount(fractionValue), nil
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatQuantity writes an amount the way ParseQuantity reads it back.
+func FormatQuantity(value Amount) string {
+	sign := ""
+	if value < 0 {
+		sign, value = "-", -value
+	}
+	formatted := sign + strconv.FormatInt(int64(value/One), 10)
+	if fraction := value % One; fraction != 0 {
+		formatted += "." + strings.TrimRight(fmt.Sprintf("%03d", fraction), "0")
+	}
+	return formatted
+}
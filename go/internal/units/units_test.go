@@ -0,0 +1,62 @@
+package units
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   Amount
+		err    string
+	}{
+		{"100", 100 * One, ""},
+		{"2.5 kg", 2500, ""},
+		{" 3 KG ", 3 * One, ""},
+		{"0.125", 125, ""},
+		{"3 l", 0, "is not in kg"},
+		{"-1", 0, "invalid quantity"},
+		{"", 0, "invalid quantity"},
+		{"1 2 kg", 0, "invalid quantity"},
+		{"NaN", 0, "invalid quantity"},
+		{"Inf", 0, "invalid quantity"},
+		{"+Inf kg", 0, "invalid quantity"},
+		{"1e3", 0, "invalid quantity"},
+		{"0x10", 0, "invalid quantity"},
+		{"1.", 0, "invalid quantity"},
+		{".5", 0, "invalid quantity"},
+		{"0.0001", 0, "invalid quantity"},
+		{"9999999999999999", 0, "invalid quantity"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.amount, func(t *testing.T) {
+			value, err := ParseQuantity(test.amount, "kg")
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != test.want {
+				t.Fatalf("expected %v, got %v", test.want, value)
+			}
+		})
+	}
+}
+
+func TestFormatQuantity(t *testing.T) {
+	for _, value := range []Amount{0, 12500, 100 * One, 125, 1} {
+		parsed, err := ParseQuantity(FormatQuantity(value), "kg")
+		if err != nil || parsed != value {
+			t.Fatalf("%v did not round trip: %v %v", value, parsed, err)
+		}
+	}
+	if formatted := FormatQuantity(12500); formatted != "12.5" {
+		t.Fatalf("expected 12.5, got %s", formatted)
+	}
+}
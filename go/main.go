@@ -3,12 +3,10 @@ package main
 import (
 	"log"
 	"supplychain/chaincode"
-
-	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 func main() {
-	supplyChaincode, err := contractapi.NewChaincode(&chaincode.SmartContract{})
+	supplyChaincode, err := chaincode.NewChaincode()
 	
 	if err != nil {
 		log.Panicf("Error creating supply chaincode: %v", err)